@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"context"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"time"
+)
+
+var auditCollection = database.Collection(database.Client, "auditLog")
+
+// recordAudit adds an entry to the money audit log. The money has already moved
+// by the time this is called, so a failure is logged rather than undoing it.
+func recordAudit(ctx context.Context, entry models.AuditEntry) {
+	entry.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	entry.ID = primitive.NewObjectID()
+	entry.AuditID = entry.ID.Hex()
+
+	_, err := auditCollection.InsertOne(ctx, entry)
+	if err != nil {
+		log.Printf("Error recording %s on invoice %s in the audit log: %v", entry.Action, entry.InvoiceID, err)
+	}
+}
+
+func GetAuditLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if invoiceId := c.Query("invoice_id"); invoiceId != "" {
+			filter["invoice_id"] = invoiceId
+		}
+
+		if paymentId := c.Query("payment_id"); paymentId != "" {
+			filter["payment_id"] = paymentId
+		}
+
+		if action := c.Query("action"); action != "" {
+			filter["action"] = action
+		}
+
+		if userId := c.Query("user_id"); userId != "" {
+			filter["user_id"] = userId
+		}
+
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		entries := []models.AuditEntry{}
+		result, err := listQuery.Find(ctx, auditCollection, filter, &entries)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the audit log"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -7,16 +7,18 @@ import (
 	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
 	"github.com/dastardlyjockey/restaurant-management-backend/models"
 	"github.com/gin-gonic/gin"
-	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 	"log"
 	"net/http"
+	"os"
+	"regexp"
+	"strings"
 	"time"
 )
 
-var validate = validator.New()
+var validate = newValidator()
 var UserCollection = database.Collection(database.Client, "users")
 
 func HashPassword(password string) string {
@@ -40,6 +42,85 @@ func VerifyPassword(userPassword string, providedPassword string) (bool, string)
 	return check, msg
 }
 
+func userIsManager(ctx context.Context, userID string) bool {
+	var user models.User
+	err := UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	return err == nil && user.Role != nil && *user.Role == models.UserRoleManager
+}
+
+// isInitialManager reports whether the email is the INITIAL_MANAGER_EMAIL the
+// restaurant was set up with and no manager has been appointed yet
+func isInitialManager(ctx context.Context, email string) bool {
+	initial := strings.TrimSpace(os.Getenv("INITIAL_MANAGER_EMAIL"))
+	if initial == "" || !strings.EqualFold(initial, strings.TrimSpace(email)) {
+		return false
+	}
+
+	managers, err := UserCollection.CountDocuments(ctx, bson.M{"role": models.UserRoleManager})
+	return err == nil && managers == 0
+}
+
+// SeedInitialManager promotes the user registered with INITIAL_MANAGER_EMAIL
+// when the application starts without any manager. A user who signs up with
+// that email later is made the manager at signup.
+func SeedInitialManager() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	email := strings.TrimSpace(os.Getenv("INITIAL_MANAGER_EMAIL"))
+	if !isInitialManager(ctx, email) {
+		return nil
+	}
+
+	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.M{"email": bson.M{"$regex": "^" + regexp.QuoteMeta(email) + "$", "$options": "i"}}
+	result, err := UserCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+		{"role", models.UserRoleManager},
+		{"updated_at", updatedAt},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount > 0 {
+		log.Printf("%s is now the manager", email)
+	}
+
+	return nil
+}
+
+// managerApproval returns the manager who approved an action. A manager approves
+// their own actions; anyone else needs a manager to sign off with their credentials.
+func managerApproval(ctx context.Context, userID string, approval *models.ManagerApproval) (string, int, string) {
+	if userIsManager(ctx, userID) {
+		return userID, http.StatusOK, ""
+	}
+
+	if approval == nil {
+		return "", http.StatusForbidden, "a manager has to approve this"
+	}
+
+	if err := validate.Struct(approval); err != nil {
+		return "", http.StatusBadRequest, "the approval needs a manager's email and password"
+	}
+
+	var manager models.User
+	err := UserCollection.FindOne(ctx, bson.M{"email": approval.Email}).Decode(&manager)
+	if err != nil {
+		return "", http.StatusForbidden, "the approval is not from a manager"
+	}
+
+	if ok, _ := VerifyPassword(*approval.Password, *manager.Password); !ok {
+		return "", http.StatusForbidden, "the approval is not from a manager"
+	}
+
+	if manager.Role == nil || *manager.Role != models.UserRoleManager {
+		return "", http.StatusForbidden, "the approval is not from a manager"
+	}
+
+	return manager.UserID, http.StatusOK, ""
+}
+
 func Signup(c *gin.Context) {
 	var user models.User
 
@@ -76,6 +157,13 @@ func Signup(c *gin.Context) {
 		return
 	}
 
+	// new staff start without any privileges, apart from the configured first manager
+	role := models.UserRoleStaff
+	if isInitialManager(ctx, *user.Email) {
+		role = models.UserRoleManager
+	}
+	user.Role = &role
+
 	//hash the password
 	hashPassword := HashPassword(*user.Password)
 	user.Password = &hashPassword
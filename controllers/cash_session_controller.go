@@ -0,0 +1,474 @@
+package controllers
+
+import (
+	"context"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"time"
+)
+
+var cashSessionCollection = database.Collection(database.Client, "cashSessions")
+
+// invoiceLockedMsg is the answer to any change to an invoice a Z report has closed off
+const invoiceLockedMsg = "the invoice has been closed off by a Z report and cannot be changed"
+
+// sessionInvoices matches the invoices a Z report closes off: the ones settled or
+// voided between the session opening and closing, the same ones the report adds up.
+// Invoices settled before the session opened belong to an earlier session.
+func sessionInvoices(session models.CashSession, closedAt time.Time) bson.M {
+	window := bson.M{"$gte": session.OpenedAt, "$lte": closedAt}
+	return bson.M{
+		"locked_at": nil,
+		"$or": bson.A{
+			bson.M{
+				"paid_at":        window,
+				"payment_status": bson.M{"$in": bson.A{models.InvoiceStatusPaid, models.InvoiceStatusPartiallyRefunded, models.InvoiceStatusRefunded}},
+			},
+			bson.M{"voided_at": window},
+		},
+	}
+}
+
+// cashReport adds up the takings from when the drawer was opened until to. Cash that
+// went through the drawer is the cash payments with their tips, less cash refunds,
+// plus the float and any cash put in or taken out by hand.
+func cashReport(ctx context.Context, session models.CashSession, reportType string, to time.Time, uid string) (models.CashReport, error) {
+	report := models.CashReport{
+		Type:         reportType,
+		From:         session.OpenedAt,
+		To:           to,
+		OpeningFloat: *session.OpeningFloat,
+		GeneratedBy:  uid,
+		GeneratedAt:  to,
+	}
+	window := bson.M{"$gte": session.OpenedAt, "$lte": to}
+
+	matchStage := bson.D{{"$match", bson.D{{"created_at", window}, {"status", settledPayments}}}}
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", "$method"},
+		{"amount", bson.D{{"$sum", "$amount"}}},
+		{"tips", bson.D{{"$sum", bson.D{{"$ifNull", bson.A{"$tip", 0}}}}}},
+		{"count", bson.D{{"$sum", 1}}},
+	}}}
+
+	cursor, err := paymentCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return report, err
+	}
+
+	var payments []struct {
+		Method string  `bson:"_id"`
+		Amount float64 `bson:"amount"`
+		Tips   float64 `bson:"tips"`
+		Count  int     `bson:"count"`
+	}
+	if err = cursor.All(ctx, &payments); err != nil {
+		return report, err
+	}
+
+	for _, payment := range payments {
+		report.PaymentCount += payment.Count
+		if payment.Method == models.PaymentMethodCash {
+			report.CashSales += payment.Amount
+			report.CashTips += payment.Tips
+		} else {
+			report.CardSales += payment.Amount
+			report.CardTips += payment.Tips
+		}
+	}
+
+	matchStage = bson.D{{"$match", bson.D{{"created_at", window}}}}
+	groupStage = bson.D{{"$group", bson.D{
+		{"_id", "$method"},
+		{"amount", bson.D{{"$sum", "$amount"}}},
+	}}}
+
+	cursor, err = refundCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return report, err
+	}
+
+	var refunds []struct {
+		Method string  `bson:"_id"`
+		Amount float64 `bson:"amount"`
+	}
+	if err = cursor.All(ctx, &refunds); err != nil {
+		return report, err
+	}
+
+	for _, refund := range refunds {
+		if refund.Method == models.PaymentMethodCash {
+			report.CashRefunds += refund.Amount
+		} else {
+			report.CardRefunds += refund.Amount
+		}
+	}
+
+	for _, movement := range session.Movements {
+		if *movement.Type == models.CashMovementIn {
+			report.CashIn += *movement.Amount
+		} else {
+			report.CashOut += *movement.Amount
+		}
+	}
+
+	matchStage = bson.D{{"$match", bson.D{
+		{"paid_at", window},
+		{"payment_status", bson.M{"$in": bson.A{models.InvoiceStatusPaid, models.InvoiceStatusPartiallyRefunded, models.InvoiceStatusRefunded}}},
+	}}}
+	groupStage = bson.D{{"$group", bson.D{
+		{"_id", nil},
+		{"count", bson.D{{"$sum", 1}}},
+		{"total", bson.D{{"$sum", "$total"}}},
+		{"discounts", bson.D{{"$sum", "$discount_total"}}},
+		{"tax", bson.D{{"$sum", "$tax_total"}}},
+	}}}
+
+	cursor, err = invoiceCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return report, err
+	}
+
+	var invoices []struct {
+		Count     int     `bson:"count"`
+		Total     float64 `bson:"total"`
+		Discounts float64 `bson:"discounts"`
+		Tax       float64 `bson:"tax"`
+	}
+	if err = cursor.All(ctx, &invoices); err != nil {
+		return report, err
+	}
+
+	if len(invoices) > 0 {
+		report.InvoiceCount = invoices[0].Count
+		report.SalesTotal = invoices[0].Total
+		report.DiscountTotal = invoices[0].Discounts
+		report.TaxTotal = invoices[0].Tax
+	}
+
+	voids, err := invoiceCollection.CountDocuments(ctx, bson.M{"voided_at": window})
+	if err != nil {
+		return report, err
+	}
+	report.VoidCount = int(voids)
+
+	open, err := invoiceCollection.CountDocuments(ctx, bson.M{
+		"payment_status": bson.M{"$in": bson.A{models.InvoiceStatusPending, models.InvoiceStatusPartiallyPaid}},
+	})
+	if err != nil {
+		return report, err
+	}
+	report.OpenInvoices = int(open)
+
+	report.CashSales = toFixed(report.CashSales, 2)
+	report.CashTips = toFixed(report.CashTips, 2)
+	report.CashRefunds = toFixed(report.CashRefunds, 2)
+	report.CashIn = toFixed(report.CashIn, 2)
+	report.CashOut = toFixed(report.CashOut, 2)
+	report.CardSales = toFixed(report.CardSales, 2)
+	report.CardTips = toFixed(report.CardTips, 2)
+	report.CardRefunds = toFixed(report.CardRefunds, 2)
+	report.SalesTotal = toFixed(report.SalesTotal, 2)
+	report.DiscountTotal = toFixed(report.DiscountTotal, 2)
+	report.TaxTotal = toFixed(report.TaxTotal, 2)
+	report.ExpectedCash = toFixed(report.OpeningFloat+report.CashSales+report.CashTips-report.CashRefunds+report.CashIn-report.CashOut, 2)
+
+	return report, nil
+}
+
+// OpenCashSession opens the cash drawer with the float it starts the day with
+func OpenCashSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var session models.CashSession
+
+		err := c.BindJSON(&session)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cash session JSON"})
+			return
+		}
+
+		err = validate.Struct(session)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "opening the drawer needs the opening float"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		count, err := cashSessionCollection.CountDocuments(ctx, bson.M{"status": models.CashSessionOpen})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while checking the cash drawer"})
+			return
+		}
+
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the cash drawer is already open, close it before opening a new session"})
+			return
+		}
+
+		openingFloat := toFixed(*session.OpeningFloat, 2)
+		session.OpeningFloat = &openingFloat
+		session.Status = models.CashSessionOpen
+		session.Movements = []models.CashMovement{}
+		session.CountedCash = nil
+		session.Note = nil
+		session.ZReport = nil
+		session.ClosedBy = nil
+		session.ClosedAt = nil
+		session.OpenedBy = c.GetString("uid")
+		session.OpenedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		session.ID = primitive.NewObjectID()
+		session.CashSessionID = session.ID.Hex()
+
+		_, err = cashSessionCollection.InsertOne(ctx, session)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Cash session was not created in the database"})
+			return
+		}
+
+		// another drawer may have been opened at the same time; the first one opened stays
+		var first models.CashSession
+		opt := options.FindOne().SetSort(bson.D{{"_id", 1}})
+		err = cashSessionCollection.FindOne(ctx, bson.M{"status": models.CashSessionOpen}, opt).Decode(&first)
+		if err == nil && first.CashSessionID != session.CashSessionID {
+			_, err = cashSessionCollection.DeleteOne(ctx, bson.M{"cash_session_id": session.CashSessionID})
+			if err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "the cash drawer is already open, close it before opening a new session"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+func GetCashSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"opened_at", "closed_at"}, "opened_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		if msg = helpers.DateRangeFilter(c, filter, "opened_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		sessions := []models.CashSession{}
+		result, err := listQuery.Find(ctx, cashSessionCollection, filter, &sessions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the cash sessions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetCashSessionById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cashSessionId := c.Param("cash_session_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var session models.CashSession
+		err := cashSessionCollection.FindOne(ctx, bson.M{"cash_session_id": cashSessionId}).Decode(&session)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cash session was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, session)
+	}
+}
+
+// AddCashMovement records cash put into or taken out of an open drawer
+func AddCashMovement() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var movement models.CashMovement
+
+		err := c.BindJSON(&movement)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cash movement JSON"})
+			return
+		}
+
+		err = validate.Struct(movement)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a cash movement needs an IN or OUT type, an amount and a reason"})
+			return
+		}
+
+		cashSessionId := c.Param("cash_session_id")
+
+		amount := toFixed(*movement.Amount, 2)
+		movement.Amount = &amount
+		movement.MovementID = primitive.NewObjectID().Hex()
+		movement.CreatedBy = c.GetString("uid")
+		movement.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		result, err := cashSessionCollection.UpdateOne(ctx,
+			bson.M{"cash_session_id": cashSessionId, "status": models.CashSessionOpen},
+			bson.D{{"$push", bson.D{{"movements", movement}}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the cash movement"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the cash session was not found or has been closed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, movement)
+	}
+}
+
+// GetXReport shows the takings so far without closing the drawer. A closed
+// session only has its Z report.
+func GetXReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cashSessionId := c.Param("cash_session_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var session models.CashSession
+		err := cashSessionCollection.FindOne(ctx, bson.M{"cash_session_id": cashSessionId}).Decode(&session)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cash session was not found"})
+			return
+		}
+
+		if session.Status == models.CashSessionClosed {
+			c.JSON(http.StatusConflict, gin.H{"error": "the cash session has been closed, see its Z report"})
+			return
+		}
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		report, err := cashReport(ctx, session, models.CashReportX, now, c.GetString("uid"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add up the cash session"})
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// CloseCashSession cashes up the drawer against the amount counted and takes the
+// Z report. Every settled invoice is then locked against further changes; invoices
+// still waiting for payment carry over to the next session.
+func CloseCashSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var count models.CashCount
+
+		err := c.BindJSON(&count)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cash count JSON"})
+			return
+		}
+
+		err = validate.Struct(count)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "closing the drawer needs the counted cash"})
+			return
+		}
+
+		cashSessionId := c.Param("cash_session_id")
+		uid := c.GetString("uid")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var session models.CashSession
+		err = cashSessionCollection.FindOne(ctx, bson.M{"cash_session_id": cashSessionId}).Decode(&session)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "cash session was not found"})
+			return
+		}
+
+		if session.Status == models.CashSessionClosed {
+			c.JSON(http.StatusConflict, gin.H{"error": "the cash session has already been closed"})
+			return
+		}
+
+		closedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		report, err := cashReport(ctx, session, models.CashReportZ, closedAt, uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add up the cash session"})
+			return
+		}
+
+		counted := toFixed(*count.CountedCash, 2)
+		variance := toFixed(counted-report.ExpectedCash, 2)
+		report.CountedCash = &counted
+		report.Variance = &variance
+
+		// the status guard stops the drawer being closed twice at the same time
+		result, err := cashSessionCollection.UpdateOne(ctx, bson.M{
+			"cash_session_id": cashSessionId,
+			"status":          models.CashSessionOpen,
+		}, bson.D{{"$set", bson.D{
+			{"status", models.CashSessionClosed},
+			{"counted_cash", counted},
+			{"note", count.Note},
+			{"closed_by", uid},
+			{"closed_at", closedAt},
+			{"z_report", report},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close the cash session"})
+			return
+		}
+
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the cash session has already been closed"})
+			return
+		}
+
+		locked, err := invoiceCollection.UpdateMany(ctx, sessionInvoices(session, closedAt), bson.D{{"$set", bson.D{
+			{"cash_session_id", cashSessionId},
+			{"locked_at", closedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lock the day's invoices"})
+			return
+		}
+		report.LockedInvoices = int(locked.ModifiedCount)
+
+		_, err = cashSessionCollection.UpdateOne(ctx,
+			bson.M{"cash_session_id": cashSessionId},
+			bson.D{{"$set", bson.D{{"z_report.locked_invoices", report.LockedInvoices}}}},
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save the Z report"})
+			return
+		}
+
+		c.JSON(http.StatusOK, report)
+	}
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestZReportLocksTheSessionsInvoices(t *testing.T) {
+	ctx := testDatabase(t)
+
+	float := 100.0
+	openedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	session := models.CashSession{ID: primitive.NewObjectID(), Status: models.CashSessionOpen, OpeningFloat: &float, OpenedAt: openedAt}
+	session.CashSessionID = session.ID.Hex()
+	fixture(t, ctx, cashSessionCollection, session)
+
+	paid := func(at time.Time) models.Invoice {
+		invoice := newInvoice(primitive.NewObjectID().Hex(), models.InvoiceStatusPaid)
+		invoice.PaidAt = &at
+		return invoice
+	}
+
+	// settled the day before, but never closed off by a Z report
+	earlier := paid(openedAt.Add(-24 * time.Hour))
+	during := paid(openedAt.Add(time.Minute))
+	open := newInvoice(primitive.NewObjectID().Hex(), models.InvoiceStatusPending)
+	fixture(t, ctx, invoiceCollection, earlier, during, open)
+
+	handler := withParam(CloseCashSession(), "cash_session_id", session.CashSessionID)
+	recorder := serve(handler, http.MethodPost, "/cashSessions/"+session.CashSessionID+"/close", gin.H{"counted_cash": float}, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("closing the session: got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	locked := func(invoice models.Invoice) bool {
+		var stored models.Invoice
+		if err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoice.InvoiceID}).Decode(&stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored.LockedAt != nil
+	}
+
+	if !locked(during) {
+		t.Error("an invoice paid during the session was not locked")
+	}
+	if locked(earlier) {
+		t.Error("an invoice paid before the session opened was locked")
+	}
+	if locked(open) {
+		t.Error("an open invoice was locked")
+	}
+}
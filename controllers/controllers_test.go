@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/dastardlyjockey/restaurant-management-backend/storage"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestMain keeps the files the tests upload in a temporary directory
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "uploads")
+	if err != nil {
+		log.Fatal(err)
+	}
+	storage.Default, err = storage.NewLocalStorage(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testDatabase skips tests that need MongoDB unless DB_NAME names a database they
+// may write to, e.g. DB_URL=mongodb://localhost:27017 DB_NAME=restaurant_test
+func testDatabase(t *testing.T) context.Context {
+	t.Helper()
+
+	if os.Getenv("DB_NAME") == "" {
+		t.Skip("set DB_NAME to a test database to run the tests that need MongoDB")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+
+	if err := database.Client.Ping(ctx, nil); err != nil {
+		t.Fatalf("the test database is not reachable: %v", err)
+	}
+
+	return ctx
+}
+
+// serve runs a handler for one JSON request to target made by the signed-in user uid
+func serve(handler gin.HandlerFunc, method string, target string, body interface{}, uid string) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+
+	data, _ := json.Marshal(body)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(method, target, bytes.NewReader(data))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("uid", uid)
+
+	handler(c)
+	return recorder
+}
+
+// withParam runs a handler with a path parameter set, as the router would
+func withParam(handler gin.HandlerFunc, key string, value string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Params = append(c.Params, gin.Param{Key: key, Value: value})
+		handler(c)
+	}
+}
+
+// fixture saves documents for the length of a test and deletes them after it
+func fixture(t *testing.T, ctx context.Context, collection *mongo.Collection, docs ...interface{}) {
+	t.Helper()
+
+	for _, doc := range docs {
+		result, err := collection.InsertOne(ctx, doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id := result.InsertedID
+		t.Cleanup(func() {
+			collection.DeleteOne(ctx, bson.M{"_id": id})
+		})
+	}
+}
+
+// cleanup deletes what the handlers under test saved once the test is over
+func cleanup(t *testing.T, ctx context.Context, collection *mongo.Collection, filter bson.M) {
+	t.Cleanup(func() {
+		collection.DeleteMany(ctx, filter)
+	})
+}
+
+// useSettings swaps the restaurant settings for the length of a test
+func useSettings(t *testing.T, ctx context.Context, settings models.Settings) {
+	t.Helper()
+
+	var saved bson.M
+	err := settingsCollection.FindOneAndDelete(ctx, bson.M{"settings_id": restaurantSettingsID}).Decode(&saved)
+	if err != nil && err != mongo.ErrNoDocuments {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		settingsCollection.DeleteOne(ctx, bson.M{"settings_id": restaurantSettingsID})
+		if saved != nil {
+			settingsCollection.InsertOne(ctx, saved)
+		}
+	})
+
+	settings.ID = primitive.NewObjectID()
+	settings.SettingsID = restaurantSettingsID
+	if _, err := settingsCollection.InsertOne(ctx, settings); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newMenu() models.Menu {
+	now := time.Now()
+	menu := models.Menu{ID: primitive.NewObjectID(), Name: "Test menu", Category: "test", CreatedAt: now, UpdatedAt: now}
+	menu.MenuID = menu.ID.Hex()
+	return menu
+}
+
+func newFood(menuID string, name string, price float64) models.Food {
+	now := time.Now()
+	food := models.Food{ID: primitive.NewObjectID(), Name: &name, Price: &price, MenuID: &menuID, CreatedAt: now, UpdatedAt: now}
+	food.FoodID = food.ID.Hex()
+	return food
+}
+
+func newOrder(tableID *string) models.Order {
+	now := time.Now()
+	status := models.OrderStatusOpen
+	order := models.Order{ID: primitive.NewObjectID(), OrderDate: now, Status: &status, TableID: tableID, CreatedAt: now, UpdatedAt: now}
+	order.OrderID = order.ID.Hex()
+	return order
+}
+
+func newOrderItem(orderID string, foodID string, price float64) models.OrderItem {
+	now := time.Now()
+	size := "M"
+	item := models.OrderItem{ID: primitive.NewObjectID(), Quantity: &size, UnitPrice: &price, FoodID: &foodID, OrderID: orderID, CreatedAt: now, UpdatedAt: now}
+	item.OrderItemID = item.ID.Hex()
+	return item
+}
+
+func newInvoice(orderID string, status string) models.Invoice {
+	now := time.Now()
+	invoice := models.Invoice{ID: primitive.NewObjectID(), OrderID: orderID, PaymentStatus: &status, CreatedAt: now, UpdatedAt: now}
+	invoice.InvoiceID = invoice.ID.Hex()
+	return invoice
+}
+
+func newUser(role string) models.User {
+	first, last, email := "Test", role, primitive.NewObjectID().Hex()+"@example.com"
+	user := models.User{ID: primitive.NewObjectID(), FirstName: &first, LastName: &last, Email: &email, Role: &role, CreatedAt: time.Now()}
+	user.UserID = user.ID.Hex()
+	return user
+}
+
+// newTable is a free table for four, numbered high enough to stay clear of real tables
+func newTable() models.Table {
+	now := time.Now()
+	number, guests, capacity := 100000+rand.Intn(900000), 4, 4
+	table := models.Table{ID: primitive.NewObjectID(), TableNumber: &number, NumberOfGuests: &guests, MaxCapacity: &capacity, CreatedAt: now, UpdatedAt: now}
+	table.TableID = table.ID.Hex()
+	return table
+}
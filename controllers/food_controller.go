@@ -4,16 +4,18 @@ import (
 	"context"
 	"fmt"
 	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
 	"github.com/dastardlyjockey/restaurant-management-backend/models"
 	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"math"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,6 +30,128 @@ func toFixed(num float64, precision int) float64 {
 	return float64(round(num*output)) / output
 }
 
+// validLabels checks that every value is one of the allowed allergen or dietary labels
+func validLabels(values []string, allowed []string) bool {
+	for _, value := range values {
+		found := false
+		for _, label := range allowed {
+			if value == label {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// labelValidator accepts a field holding one of the allowed labels
+func labelValidator(allowed []string) validator.Func {
+	return func(fl validator.FieldLevel) bool {
+		return validLabels([]string{fl.Field().String()}, allowed)
+	}
+}
+
+// newValidator adds the label checks to the validator, so the models and the
+// controllers share the one list of allergens and dietary tags
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	err := v.RegisterValidation("allergen", labelValidator(models.Allergens))
+	if err == nil {
+		err = v.RegisterValidation("dietary_tag", labelValidator(models.DietaryTags))
+	}
+	if err != nil {
+		log.Fatal("error registering the label validations: ", err)
+	}
+
+	return v
+}
+
+// queryLabels reads a label filter given either repeated or comma separated, e.g. exclude=nuts,milk
+func queryLabels(c *gin.Context, key string) []string {
+	var labels []string
+	for _, value := range c.QueryArray(key) {
+		for _, label := range strings.Split(value, ",") {
+			if label = strings.ToLower(strings.TrimSpace(label)); label != "" {
+				labels = append(labels, label)
+			}
+		}
+	}
+
+	return labels
+}
+
+// foodAvailable reports whether the kitchen is still serving the food; nil means it has never been 86'd
+func foodAvailable(food models.Food) bool {
+	return food.Available == nil || *food.Available
+}
+
+// reserveFoodPortions takes the ordered portions off each counted food in one atomic
+// update per food. If any food is unavailable or sold out the portions already taken
+// are put back and a message explains which food could not be ordered.
+func reserveFoodPortions(ctx context.Context, portions map[string]int) (map[string]int, string, error) {
+	reserved := make(map[string]int)
+
+	for foodID, count := range portions {
+		result, err := foodCollection.UpdateOne(ctx, bson.M{
+			"food_id":            foodID,
+			"available":          bson.M{"$ne": false},
+			"remaining_portions": bson.M{"$gte": count},
+		}, bson.D{{"$inc", bson.D{{"remaining_portions", -count}}}})
+		if err != nil {
+			releaseFoodPortions(ctx, reserved)
+			return nil, "", err
+		}
+
+		if result.ModifiedCount == 1 {
+			reserved[foodID] = count
+			continue
+		}
+
+		// nothing was taken off, so the food is either uncounted, 86'd or sold out
+		var food models.Food
+		err = foodCollection.FindOne(ctx, bson.M{"food_id": foodID}).Decode(&food)
+		if err != nil {
+			releaseFoodPortions(ctx, reserved)
+			return nil, "", err
+		}
+
+		if foodAvailable(food) && food.RemainingPortions == nil {
+			continue
+		}
+
+		releaseFoodPortions(ctx, reserved)
+
+		if !foodAvailable(food) {
+			return nil, fmt.Sprintf("%s is unavailable", *food.Name), nil
+		}
+
+		if *food.RemainingPortions == 0 {
+			return nil, fmt.Sprintf("%s is sold out", *food.Name), nil
+		}
+
+		return nil, fmt.Sprintf("only %d portions of %s are left", *food.RemainingPortions, *food.Name), nil
+	}
+
+	return reserved, "", nil
+}
+
+// releaseFoodPortions puts reserved portions back when an order could not be placed
+// or an item was changed to another food. Foods that are not counted are left alone.
+func releaseFoodPortions(ctx context.Context, reserved map[string]int) {
+	for foodID, count := range reserved {
+		filter := bson.M{"food_id": foodID, "remaining_portions": bson.M{"$type": "number"}}
+		_, err := foodCollection.UpdateOne(ctx, filter, bson.D{{"$inc", bson.D{{"remaining_portions", count}}}})
+		if err != nil {
+			log.Printf("Error releasing %d portions of food %s: %v", count, foodID, err)
+		}
+	}
+}
+
 func CreateFood() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// get the food request from the body
@@ -56,6 +180,21 @@ func CreateFood() gin.HandlerFunc {
 			return
 		}
 
+		if menu.Archived {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "food cannot be added to an archived menu"})
+			return
+		}
+
+		if code, msg := checkTaxCategory(ctx, food.TaxCategory); msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		food.Archived = false
+		if food.Available == nil {
+			available := true
+			food.Available = &available
+		}
 		food.CreatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		food.UpdatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		if err != nil {
@@ -82,49 +221,86 @@ func CreateFood() gin.HandlerFunc {
 
 func GetFoods() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
+		listQuery, msg := helpers.ParseListQuery(c, []string{"name", "price", "created_at"}, "name", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		// foods on archived menus are hidden
+		filter := bson.M{"archived": bson.M{"$ne": true}}
+
+		if search := strings.TrimSpace(c.Query("search")); search != "" {
+			filter["name"] = bson.M{"$regex": regexp.QuoteMeta(search), "$options": "i"}
 		}
 
-		page, err := strconv.Atoi(c.Query("Page"))
-		if err != nil || page < 1 {
-			page = 1
+		if menuId := c.Query("menu_id"); menuId != "" {
+			filter["menu_id"] = menuId
 		}
 
-		startIndex := (page - 1) * recordPerPage
-		startIndex, err = strconv.Atoi(c.Query("startIndex"))
+		price := bson.M{}
+		for param, operator := range map[string]string{"min_price": "$gte", "max_price": "$lte"} {
+			if c.Query(param) == "" {
+				continue
+			}
+
+			value, err := strconv.ParseFloat(c.Query(param), 64)
+			if err != nil || value < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a positive number"})
+				return
+			}
+			price[operator] = value
+		}
 
-		matchStage := bson.D{{"$match", bson.D{{}}}}
-		groupStage := bson.D{{"$group", bson.D{
-			{"_id", bson.D{{"_id", "null"}}},
-			{"total_count", bson.D{{"$sum", 1}}},
-			{"data", bson.D{{"$push", "$$ROOT"}}},
-		}}}
-		projectStage := bson.D{{"$project", bson.D{
-			{"_id", 0},
-			{"total_count", 1},
-			{"food_items", bson.D{{"$slice", []interface{}{"$data", startIndex, recordPerPage}}}},
-		}}}
+		if len(price) > 0 {
+			filter["price"] = price
+		}
 
-		// get data from the database
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-		result, err := foodCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, projectStage})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed in retrieving the aggregated data"})
+		// a food is available when it has not been 86'd and is not sold out
+		switch c.Query("available") {
+		case "":
+		case "true":
+			filter["available"] = bson.M{"$ne": false}
+			filter["remaining_portions"] = bson.M{"$ne": 0}
+		case "false":
+			filter["$or"] = bson.A{bson.M{"available": false}, bson.M{"remaining_portions": 0}}
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "available must be true or false"})
 			return
 		}
 
-		var allFood []bson.M
-		err = result.All(ctx, &allFood)
+		// guests can leave out foods containing an allergen and ask for dietary tags
+		exclude := queryLabels(c, "exclude")
+		if !validLabels(exclude, models.Allergens) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "exclude must be one of " + strings.Join(models.Allergens, ", ")})
+			return
+		}
+
+		if len(exclude) > 0 {
+			filter["allergens"] = bson.M{"$nin": exclude}
+		}
+
+		tags := queryLabels(c, "tag")
+		if !validLabels(tags, models.DietaryTags) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tag must be one of " + strings.Join(models.DietaryTags, ", ")})
+			return
+		}
+
+		if len(tags) > 0 {
+			filter["dietary_tags"] = bson.M{"$all": tags}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foods := []models.Food{}
+		result, err := listQuery.Find(ctx, foodCollection, filter, &foods)
 		if err != nil {
-			log.Fatal(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the foods"})
 			return
 		}
 
-		// response
-		c.JSON(http.StatusOK, allFood[0])
+		c.JSON(http.StatusOK, result)
 	}
 }
 
@@ -167,7 +343,7 @@ func UpdateFood() gin.HandlerFunc {
 
 		// verify the data from the food and store it in the update obj
 
-		if *food.Name != "" {
+		if food.Name != nil && *food.Name != "" {
 			updateObj = append(updateObj, bson.E{Key: "name", Value: *food.Name})
 		}
 
@@ -181,16 +357,48 @@ func UpdateFood() gin.HandlerFunc {
 			updateObj = append(updateObj, bson.E{Key: "food_image", Value: *food.FoodImage})
 		}
 
-		//check whether the food belongs to a menu
-		var menu models.Menu
+		if food.Allergens != nil || food.DietaryTags != nil {
+			err = validate.StructPartial(food, "Allergens", "DietaryTags")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "unknown allergen or dietary tag"})
+				return
+			}
+		}
+
+		if food.Allergens != nil {
+			updateObj = append(updateObj, bson.E{Key: "allergens", Value: food.Allergens})
+		}
+
+		if food.DietaryTags != nil {
+			updateObj = append(updateObj, bson.E{Key: "dietary_tags", Value: food.DietaryTags})
+		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		err = menuCollection.FindOne(ctx, bson.M{"menu_id": food.MenuID}).Decode(&menu)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "menu was not found"})
-			return
+		// a food moved to another menu must go to one that exists and is still live
+		if food.MenuID != nil {
+			var menu models.Menu
+			err = menuCollection.FindOne(ctx, bson.M{"menu_id": *food.MenuID}).Decode(&menu)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "menu was not found"})
+				return
+			}
+
+			if menu.Archived {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "food cannot be moved to an archived menu"})
+				return
+			}
+
+			updateObj = append(updateObj, bson.E{Key: "menu_id", Value: *food.MenuID})
+		}
+
+		if food.TaxCategory != nil {
+			if code, msg := checkTaxCategory(ctx, food.TaxCategory); msg != "" {
+				c.JSON(code, gin.H{"error": msg})
+				return
+			}
+			updateObj = append(updateObj, bson.E{Key: "tax_category", Value: *food.TaxCategory})
 		}
 
 		// update the time
@@ -202,16 +410,92 @@ func UpdateFood() gin.HandlerFunc {
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: food.UpdatedAt})
 
 		// update the food id in the database
-		upsert := true
-		opt := options.UpdateOptions{Upsert: &upsert}
-
-		updateResult, err := foodCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &opt)
+		updateResult, err := foodCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed updating food"})
 			return
 		}
 
+		if updateResult.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "food was not found"})
+			return
+		}
+
 		// response
 		c.JSON(http.StatusOK, updateResult)
 	}
 }
+
+// MarkFoodUnavailable 86's a food so it can no longer be ordered
+func MarkFoodUnavailable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodId := c.Param("food_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := foodCollection.UpdateOne(ctx, bson.M{"food_id": foodId}, bson.D{{"$set", bson.D{
+			{"available", false},
+			{"updated_at", updatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark the food as unavailable"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "food was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"food_id": foodId, "available": false})
+	}
+}
+
+// MarkFoodAvailable un-86's a food. Sending remaining_portions restocks it with
+// that many portions; leaving it out removes the counter so the food is unlimited.
+func MarkFoodAvailable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			RemainingPortions *int `json:"remaining_portions" validate:"omitempty,min=0"`
+		}
+
+		if c.Request.ContentLength > 0 {
+			err := c.BindJSON(&request)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid availability JSON"})
+				return
+			}
+
+			err = validate.Struct(request)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "remaining_portions cannot be negative"})
+				return
+			}
+		}
+
+		foodId := c.Param("food_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := foodCollection.UpdateOne(ctx, bson.M{"food_id": foodId}, bson.D{{"$set", bson.D{
+			{"available", true},
+			{"remaining_portions", request.RemainingPortions},
+			{"updated_at", updatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark the food as available"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "food was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"food_id": foodId, "available": true, "remaining_portions": request.RemainingPortions})
+	}
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOrderingCountedFoodTakesPortions(t *testing.T) {
+	ctx := testDatabase(t)
+
+	menu := newMenu()
+	food := newFood(menu.MenuID, "Test special", 12.5)
+	portions := 2
+	food.RemainingPortions = &portions
+	fixture(t, ctx, menuCollection, menu)
+	fixture(t, ctx, foodCollection, food)
+
+	uid := primitive.NewObjectID().Hex()
+	cleanup(t, ctx, orderCollection, bson.M{"created_by": uid})
+	cleanup(t, ctx, orderItemsCollection, bson.M{"food_id": food.FoodID})
+
+	size := "M"
+	item := models.OrderItem{Quantity: &size, UnitPrice: food.Price, FoodID: &food.FoodID}
+	order := func(count int) int {
+		items := make([]models.OrderItem, count)
+		for i := range items {
+			items[i] = item
+		}
+		return serve(CreateOrderItem(), http.MethodPost, "/orderItems", orderItemPack{OrderItems: items}, uid).Code
+	}
+
+	remaining := func() int {
+		var stored models.Food
+		if err := foodCollection.FindOne(ctx, bson.M{"food_id": food.FoodID}).Decode(&stored); err != nil {
+			t.Fatal(err)
+		}
+		return *stored.RemainingPortions
+	}
+
+	if code := order(3); code != http.StatusConflict {
+		t.Fatalf("ordering more portions than are left: got %d, want %d", code, http.StatusConflict)
+	}
+	if left := remaining(); left != 2 {
+		t.Fatalf("a refused order took portions: %d left, want 2", left)
+	}
+
+	if code := order(2); code != http.StatusOK {
+		t.Fatalf("ordering the last portions: got %d, want %d", code, http.StatusOK)
+	}
+	if left := remaining(); left != 0 {
+		t.Fatalf("%d portions left after ordering them all, want 0", left)
+	}
+
+	if code := order(1); code != http.StatusConflict {
+		t.Fatalf("ordering a sold out food: got %d, want %d", code, http.StatusConflict)
+	}
+}
+
+func TestLabelValidation(t *testing.T) {
+	name, price, menuID := "Satay", 9.5, "menu"
+	food := models.Food{Name: &name, Price: &price, MenuID: &menuID}
+
+	tests := []struct {
+		allergens []string
+		tags      []string
+		valid     bool
+	}{
+		{nil, nil, true},
+		{[]string{"peanuts", "soya"}, []string{"vegan"}, true},
+		{[]string{"sulphites"}, []string{"gluten-free"}, true},
+		{[]string{"peanut"}, nil, false},
+		{nil, []string{"paleo"}, false},
+		{[]string{"vegan"}, nil, false},
+	}
+
+	for _, test := range tests {
+		food.Allergens = test.allergens
+		food.DietaryTags = test.tags
+		if err := validate.Struct(food); (err == nil) != test.valid {
+			t.Errorf("allergens %v, tags %v: got error %v, want valid %v", test.allergens, test.tags, err, test.valid)
+		}
+	}
+
+	order := models.Order{Allergies: []string{"milk", "kiwi"}}
+	if err := validate.StructPartial(order, "Allergies"); err == nil {
+		t.Error("an order with an allergy that is not a major allergen passed validation")
+	}
+}
+
+func TestGetFoodsFiltersOnAvailability(t *testing.T) {
+	ctx := testDatabase(t)
+
+	menuID := primitive.NewObjectID().Hex()
+	start := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	none, some := 0, 5
+	unavailable := false
+
+	foods := []struct {
+		name      string
+		portions  *int
+		available *bool
+	}{
+		{"Counted", &some, nil},
+		{"Uncounted", nil, nil},
+		{"Sold out", &none, nil},
+		{"Eighty-sixed", nil, &unavailable},
+	}
+
+	for i, f := range foods {
+		food := newFood(menuID, f.name, 10)
+		food.RemainingPortions = f.portions
+		food.Available = f.available
+		food.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		fixture(t, ctx, foodCollection, food)
+	}
+
+	names := func(query string) []string {
+		recorder := serve(GetFoods(), http.MethodGet, "/foods?menu_id="+menuID+"&"+query, nil, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", query, recorder.Code, recorder.Body)
+		}
+
+		var result struct {
+			Items []models.Food `json:"items"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, food := range result.Items {
+			names = append(names, *food.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"available=true&sort=created_at", []string{"Counted", "Uncounted"}},
+		{"available=false&sort=created_at", []string{"Sold out", "Eighty-sixed"}},
+		{"sort=created_at&order=desc", []string{"Eighty-sixed", "Sold out", "Uncounted", "Counted"}},
+	}
+
+	for _, test := range tests {
+		got := names(test.query)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.query, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %v, want %v", test.query, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestUpdateFood(t *testing.T) {
+	ctx := testDatabase(t)
+
+	lunch, dinner := newMenu(), newMenu()
+	food := newFood(lunch.MenuID, "Test special", 12.5)
+	fixture(t, ctx, menuCollection, lunch, dinner)
+	fixture(t, ctx, foodCollection, food)
+
+	update := func(foodID string, body gin.H) int {
+		handler := withParam(UpdateFood(), "food_id", foodID)
+		return serve(handler, http.MethodPatch, "/foods/"+foodID, body, "").Code
+	}
+
+	// a change that leaves out the name and the menu
+	if code := update(food.FoodID, gin.H{"price": 14}); code != http.StatusOK {
+		t.Fatalf("changing the price alone: got %d", code)
+	}
+
+	if code := update(food.FoodID, gin.H{"menu_id": dinner.MenuID}); code != http.StatusOK {
+		t.Fatalf("moving the food to another menu: got %d", code)
+	}
+
+	var stored models.Food
+	if err := foodCollection.FindOne(ctx, bson.M{"food_id": food.FoodID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if *stored.Price != 14 || *stored.MenuID != dinner.MenuID || *stored.Name != "Test special" {
+		t.Errorf("got %s at %v on menu %s, want Test special at 14 on %s", *stored.Name, *stored.Price, *stored.MenuID, dinner.MenuID)
+	}
+
+	if code := update(food.FoodID, gin.H{"menu_id": primitive.NewObjectID().Hex()}); code != http.StatusBadRequest {
+		t.Errorf("moving the food to an unknown menu: got %d, want %d", code, http.StatusBadRequest)
+	}
+
+	missing := primitive.NewObjectID().Hex()
+	if code := update(missing, gin.H{"price": 14}); code != http.StatusNotFound {
+		t.Errorf("updating a food that does not exist: got %d, want %d", code, http.StatusNotFound)
+	}
+}
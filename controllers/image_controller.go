@@ -0,0 +1,289 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/dastardlyjockey/restaurant-management-backend/storage"
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	// maxImageUpload is the largest image file accepted, in bytes
+	maxImageUpload = 5 << 20
+	// maxImagePixels guards against small files that decode to huge images
+	maxImagePixels = 40_000_000
+)
+
+var imageCollection = database.Collection(database.Client, "images")
+
+// imageTypes are the content types accepted for upload, as sniffed from the file itself
+var imageTypes = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+}
+
+func imageURL(imageID string, size string) string {
+	return fmt.Sprintf("/images/%s/%s", imageID, size)
+}
+
+// storeImage reads the "image" file from a multipart upload, checks it really is
+// an image, and stores the original along with its resized copies
+func storeImage(ctx context.Context, c *gin.Context, ownerType string, ownerID string) (models.Image, int, string) {
+	var stored models.Image
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageUpload+1<<20)
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		return stored, http.StatusBadRequest, "an image file is required in the image field, up to 5MB"
+	}
+
+	if fileHeader.Size > maxImageUpload {
+		return stored, http.StatusRequestEntityTooLarge, "the image cannot be larger than 5MB"
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return stored, http.StatusBadRequest, "the image could not be read"
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return stored, http.StatusBadRequest, "the image could not be read"
+	}
+
+	// trust the file contents rather than the name or the header the client sent
+	contentType := mimetype.Detect(data).String()
+	extension, ok := imageTypes[contentType]
+	if !ok {
+		return stored, http.StatusUnsupportedMediaType, "the image must be a JPEG, PNG or GIF"
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || config.Width*config.Height > maxImagePixels {
+		return stored, http.StatusBadRequest, "the image is damaged or too large to process"
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return stored, http.StatusBadRequest, "the image is damaged or too large to process"
+	}
+
+	stored.ID = primitive.NewObjectID()
+	stored.ImageID = stored.ID.Hex()
+	stored.OwnerType = ownerType
+	stored.OwnerID = ownerID
+	stored.FileName = fileHeader.Filename
+	stored.UploadedBy = c.GetString("uid")
+	stored.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	stored.Variants = make(map[string]models.ImageVariant)
+
+	original := models.ImageVariant{
+		Key:         fmt.Sprintf("images/%s/%s.%s", stored.ImageID, helpers.ImageSizeOriginal, extension),
+		ContentType: contentType,
+		Width:       config.Width,
+		Height:      config.Height,
+	}
+	err = storage.Default.Save(ctx, original.Key, bytes.NewReader(data))
+	if err != nil {
+		return stored, http.StatusInternalServerError, "Failed to store the image"
+	}
+	stored.Variants[helpers.ImageSizeOriginal] = original
+
+	for size, maxSide := range helpers.ImageSizes {
+		resized := helpers.ResizeImage(img, maxSide)
+		encoded, resizedType, err := helpers.EncodeImage(resized, contentType)
+		if err != nil {
+			deleteImageFiles(ctx, stored)
+			return stored, http.StatusInternalServerError, "Failed to resize the image"
+		}
+
+		variant := models.ImageVariant{
+			Key:         fmt.Sprintf("images/%s/%s.%s", stored.ImageID, size, imageTypes[resizedType]),
+			ContentType: resizedType,
+			Width:       resized.Bounds().Dx(),
+			Height:      resized.Bounds().Dy(),
+		}
+		err = storage.Default.Save(ctx, variant.Key, bytes.NewReader(encoded))
+		if err != nil {
+			deleteImageFiles(ctx, stored)
+			return stored, http.StatusInternalServerError, "Failed to store the resized image"
+		}
+		stored.Variants[size] = variant
+	}
+
+	_, err = imageCollection.InsertOne(ctx, stored)
+	if err != nil {
+		deleteImageFiles(ctx, stored)
+		return stored, http.StatusInternalServerError, "Image was not created in the database"
+	}
+
+	return stored, http.StatusCreated, ""
+}
+
+// deleteImageFiles removes every stored size of an image
+func deleteImageFiles(ctx context.Context, stored models.Image) {
+	for _, variant := range stored.Variants {
+		err := storage.Default.Delete(ctx, variant.Key)
+		if err != nil {
+			log.Printf("Error deleting the image file %s: %v", variant.Key, err)
+		}
+	}
+}
+
+// deleteImage removes an image that is no longer used, both its files and its record
+func deleteImage(ctx context.Context, imageID string) {
+	var stored models.Image
+	err := imageCollection.FindOneAndDelete(ctx, bson.M{"image_id": imageID}).Decode(&stored)
+	if err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Printf("Error deleting the image %s: %v", imageID, err)
+		}
+		return
+	}
+
+	deleteImageFiles(ctx, stored)
+}
+
+// imageResponse lists where each size of a stored image is served from
+func imageResponse(stored models.Image) gin.H {
+	urls := gin.H{}
+	for size := range stored.Variants {
+		urls[size] = imageURL(stored.ImageID, size)
+	}
+
+	return gin.H{"image_id": stored.ImageID, "urls": urls, "variants": stored.Variants}
+}
+
+// UploadFoodImage stores a photo of a food and points the food at its medium size
+func UploadFoodImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		foodId := c.Param("food_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		count, err := foodCollection.CountDocuments(ctx, bson.M{"food_id": foodId})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "food was not found"})
+			return
+		}
+
+		stored, code, msg := storeImage(ctx, c, models.ImageOwnerFood, foodId)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		// the food comes back as it was, so the photo it replaces can be removed
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		var previous models.Food
+		err = foodCollection.FindOneAndUpdate(ctx, bson.M{"food_id": foodId}, bson.D{{"$set", bson.D{
+			{"food_image", imageURL(stored.ImageID, helpers.ImageSizeMedium)},
+			{"image_id", stored.ImageID},
+			{"updated_at", updatedAt},
+		}}}).Decode(&previous)
+		if err != nil {
+			deleteImage(ctx, stored.ImageID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set the food image"})
+			return
+		}
+
+		if previous.ImageID != nil && *previous.ImageID != stored.ImageID {
+			deleteImage(ctx, *previous.ImageID)
+		}
+
+		c.JSON(http.StatusCreated, imageResponse(stored))
+	}
+}
+
+// UploadAvatar stores a profile picture; staff can only change their own
+func UploadAvatar() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId := c.Param("user_id")
+		if userId != c.GetString("uid") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "you can only change your own avatar"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		stored, code, msg := storeImage(ctx, c, models.ImageOwnerUser, userId)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		// the user comes back as they were, so the avatar it replaces can be removed
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		var previous models.User
+		err := UserCollection.FindOneAndUpdate(ctx, bson.M{"user_id": userId}, bson.D{{"$set", bson.D{
+			{"avatar", imageURL(stored.ImageID, helpers.ImageSizeThumbnail)},
+			{"avatar_id", stored.ImageID},
+			{"updated_at", updatedAt},
+		}}}).Decode(&previous)
+		if err != nil {
+			deleteImage(ctx, stored.ImageID)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set the avatar"})
+			return
+		}
+
+		if previous.AvatarID != nil && *previous.AvatarID != stored.ImageID {
+			deleteImage(ctx, *previous.AvatarID)
+		}
+
+		c.JSON(http.StatusCreated, imageResponse(stored))
+	}
+}
+
+// GetImage serves one size of a stored image
+func GetImage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		imageId := c.Param("image_id")
+		size := c.Param("size")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var stored models.Image
+		err := imageCollection.FindOne(ctx, bson.M{"image_id": imageId}).Decode(&stored)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "image was not found"})
+			return
+		}
+
+		variant, ok := stored.Variants[size]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "size must be original, medium or thumbnail"})
+			return
+		}
+
+		file, err := storage.Default.Open(ctx, variant.Key)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "image file was not found"})
+			return
+		}
+		defer file.Close()
+
+		// a stored image never changes, a new upload gets a new id
+		c.DataFromReader(http.StatusOK, -1, variant.ContentType, file, map[string]string{
+			"Cache-Control":          "public, max-age=31536000, immutable",
+			"X-Content-Type-Options": "nosniff",
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"context"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+var ingredientCollection = database.Collection(database.Client, "ingredients")
+var stockMovementCollection = database.Collection(database.Client, "stockMovements")
+
+// lowStockFilter matches ingredients at or below their low-stock threshold
+var lowStockFilter = bson.M{
+	"low_stock_threshold": bson.M{"$ne": nil},
+	"$expr":               bson.M{"$lte": bson.A{"$stock_level", "$low_stock_threshold"}},
+}
+
+func ingredientLow(ingredient models.Ingredient) bool {
+	return ingredient.LowStockThreshold != nil && *ingredient.StockLevel <= *ingredient.LowStockThreshold
+}
+
+// depleteStock takes the recipe quantities for one served portion off the ingredient
+// stock and returns the ingredients that have dropped to their low-stock threshold.
+// If an ingredient cannot be updated, the quantities already taken are put back so
+// a portion is never half deducted.
+func depleteStock(ctx context.Context, orderItem models.OrderItem, servedBy string) ([]models.Ingredient, error) {
+	var lowStock []models.Ingredient
+
+	var recipe models.Recipe
+	err := recipeCollection.FindOne(ctx, bson.M{"food_id": orderItem.FoodID}).Decode(&recipe)
+	if err != nil {
+		// foods without a recipe are not stock tracked
+		return lowStock, nil
+	}
+
+	taken := make(map[string]float64)
+	var movementIDs []string
+
+	servedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	for _, recipeIngredient := range recipe.Ingredients {
+		var ingredient models.Ingredient
+		opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err = ingredientCollection.FindOneAndUpdate(ctx,
+			bson.M{"ingredient_id": recipeIngredient.IngredientID},
+			bson.D{{"$inc", bson.D{{"stock_level", -*recipeIngredient.Quantity}}}, {"$set", bson.D{{"updated_at", servedAt}}}},
+			opt,
+		).Decode(&ingredient)
+		if err != nil {
+			restoreStock(ctx, taken, movementIDs)
+			return nil, err
+		}
+		taken[ingredient.IngredientID] += *recipeIngredient.Quantity
+
+		movement := models.StockMovement{
+			ID:           primitive.NewObjectID(),
+			IngredientID: ingredient.IngredientID,
+			Quantity:     -*recipeIngredient.Quantity,
+			Reason:       models.StockMovementServed,
+			OrderItemID:  &orderItem.OrderItemID,
+			CreatedBy:    servedBy,
+			CreatedAt:    servedAt,
+		}
+		movement.StockMovementID = movement.ID.Hex()
+
+		_, err = stockMovementCollection.InsertOne(ctx, movement)
+		if err != nil {
+			restoreStock(ctx, taken, movementIDs)
+			return nil, err
+		}
+		movementIDs = append(movementIDs, movement.StockMovementID)
+
+		if ingredientLow(ingredient) {
+			lowStock = append(lowStock, ingredient)
+		}
+	}
+
+	for _, ingredient := range lowStock {
+		log.Printf("Low stock alert: %s is down to %.2f %s", *ingredient.Name, *ingredient.StockLevel, *ingredient.Unit)
+	}
+
+	return lowStock, nil
+}
+
+// restoreStock puts back the quantities depleteStock took before it failed and
+// removes the movements it recorded for them
+func restoreStock(ctx context.Context, taken map[string]float64, movementIDs []string) {
+	for ingredientID, quantity := range taken {
+		_, err := ingredientCollection.UpdateOne(ctx, bson.M{"ingredient_id": ingredientID}, bson.D{{"$inc", bson.D{{"stock_level", quantity}}}})
+		if err != nil {
+			log.Printf("Error restoring %.2f of ingredient %s: %v", quantity, ingredientID, err)
+		}
+	}
+
+	if len(movementIDs) == 0 {
+		return
+	}
+
+	_, err := stockMovementCollection.DeleteMany(ctx, bson.M{"stock_movement_id": bson.M{"$in": movementIDs}})
+	if err != nil {
+		log.Println("Error removing the stock movements of a failed deduction:", err)
+	}
+}
+
+func CreateIngredient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ingredient models.Ingredient
+
+		err := c.BindJSON(&ingredient)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ingredient JSON"})
+			return
+		}
+
+		err = validate.Struct(ingredient)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the ingredient structure failed"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		count, err := ingredientCollection.CountDocuments(ctx, bson.M{"name": ingredient.Name})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the existing ingredients"})
+			return
+		}
+
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "an ingredient with this name already exists"})
+			return
+		}
+
+		if ingredient.SupplierID != nil && !supplierExists(ctx, *ingredient.SupplierID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "supplier was not found"})
+			return
+		}
+
+		if ingredient.StockLevel == nil {
+			stockLevel := 0.0
+			ingredient.StockLevel = &stockLevel
+		}
+
+		ingredient.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		ingredient.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		ingredient.ID = primitive.NewObjectID()
+		ingredient.IngredientID = ingredient.ID.Hex()
+
+		_, err = ingredientCollection.InsertOne(ctx, ingredient)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ingredient was not created in the database"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, ingredient)
+	}
+}
+
+func GetIngredients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"name", "stock_level", "created_at"}, "name", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if supplierId := c.Query("supplier_id"); supplierId != "" {
+			filter["supplier_id"] = supplierId
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ingredients := []models.Ingredient{}
+		result, err := listQuery.Find(ctx, ingredientCollection, filter, &ingredients)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the ingredients"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetLowStockIngredients lists the ingredients that are at or below their low-stock threshold
+func GetLowStockIngredients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"name", "stock_level"}, "name", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := lowStockFilter
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ingredients := []models.Ingredient{}
+		result, err := listQuery.Find(ctx, ingredientCollection, filter, &ingredients)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the low stock ingredients"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetIngredientById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ingredientId := c.Param("ingredient_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var ingredient models.Ingredient
+		err := ingredientCollection.FindOne(ctx, bson.M{"ingredient_id": ingredientId}).Decode(&ingredient)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the ingredient in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, ingredient)
+	}
+}
+
+// UpdateIngredient changes the details of an ingredient; the stock level only
+// moves through serving, receiving and stock takes so the movements stay complete
+func UpdateIngredient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ingredient models.Ingredient
+
+		err := c.BindJSON(&ingredient)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while binding the ingredient JSON from the request body"})
+			return
+		}
+
+		err = validate.StructExcept(ingredient, "Name", "Unit")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "stock thresholds and costs cannot be negative"})
+			return
+		}
+
+		ingredientId := c.Param("ingredient_id")
+		filter := bson.M{"ingredient_id": ingredientId}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var updateObj primitive.D
+
+		if ingredient.Name != nil {
+			count, err := ingredientCollection.CountDocuments(ctx, bson.M{
+				"name":          ingredient.Name,
+				"ingredient_id": bson.M{"$ne": ingredientId},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the existing ingredients"})
+				return
+			}
+
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "an ingredient with this name already exists"})
+				return
+			}
+
+			updateObj = append(updateObj, bson.E{"name", ingredient.Name})
+		}
+
+		if ingredient.Unit != nil {
+			updateObj = append(updateObj, bson.E{"unit", ingredient.Unit})
+		}
+
+		if ingredient.LowStockThreshold != nil {
+			updateObj = append(updateObj, bson.E{"low_stock_threshold", ingredient.LowStockThreshold})
+		}
+
+		if ingredient.ParLevel != nil {
+			updateObj = append(updateObj, bson.E{"par_level", ingredient.ParLevel})
+		}
+
+		if ingredient.UnitCost != nil {
+			updateObj = append(updateObj, bson.E{"unit_cost", ingredient.UnitCost})
+		}
+
+		if ingredient.SupplierID != nil {
+			if !supplierExists(ctx, *ingredient.SupplierID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "supplier was not found"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{"supplier_id", ingredient.SupplierID})
+		}
+
+		ingredient.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{"updated_at", ingredient.UpdatedAt})
+
+		result, err := ingredientCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "ingredient failed to update"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ingredient was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestIngredientLow(t *testing.T) {
+	ingredient := func(stock float64, threshold *float64) models.Ingredient {
+		return models.Ingredient{StockLevel: &stock, LowStockThreshold: threshold}
+	}
+	five := 5.0
+
+	tests := []struct {
+		name       string
+		ingredient models.Ingredient
+		want       bool
+	}{
+		{"no threshold", ingredient(0, nil), false},
+		{"above the threshold", ingredient(6, &five), false},
+		{"at the threshold", ingredient(5, &five), true},
+		{"below zero", ingredient(-1, &five), true},
+	}
+
+	for _, test := range tests {
+		if got := ingredientLow(test.ingredient); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func newRecipe(foodID string, ingredients ...models.RecipeIngredient) models.Recipe {
+	now := time.Now()
+	recipe := models.Recipe{ID: primitive.NewObjectID(), FoodID: &foodID, Ingredients: ingredients, CreatedAt: now, UpdatedAt: now}
+	recipe.RecipeID = recipe.ID.Hex()
+	return recipe
+}
+
+func stockLevel(t *testing.T, ctx context.Context, ingredient models.Ingredient) float64 {
+	t.Helper()
+
+	var stored models.Ingredient
+	if err := ingredientCollection.FindOne(ctx, bson.M{"ingredient_id": ingredient.IngredientID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	return *stored.StockLevel
+}
+
+func TestServedPortionDepletesStock(t *testing.T) {
+	ctx := testDatabase(t)
+
+	flour, butter := newIngredient(10, 2), newIngredient(1, 8)
+	threshold := 0.5
+	butter.LowStockThreshold = &threshold
+	flourQuantity, butterQuantity := 0.25, 0.5
+	food := newFood(primitive.NewObjectID().Hex(), "Test pie", 6)
+	fixture(t, ctx, ingredientCollection, flour, butter)
+	fixture(t, ctx, recipeCollection, newRecipe(food.FoodID,
+		models.RecipeIngredient{IngredientID: &flour.IngredientID, Quantity: &flourQuantity},
+		models.RecipeIngredient{IngredientID: &butter.IngredientID, Quantity: &butterQuantity},
+	))
+
+	item := newOrderItem(newOrder(nil).OrderID, food.FoodID, *food.Price)
+	cleanup(t, ctx, stockMovementCollection, bson.M{"order_item_id": item.OrderItemID})
+
+	lowStock, err := depleteStock(ctx, item, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := stockLevel(t, ctx, flour); got != 9.75 {
+		t.Errorf("flour is at %v, want 9.75", got)
+	}
+	if got := stockLevel(t, ctx, butter); got != 0.5 {
+		t.Errorf("butter is at %v, want 0.5", got)
+	}
+	if len(lowStock) != 1 || lowStock[0].IngredientID != butter.IngredientID {
+		t.Errorf("got %d low stock ingredients, want only the butter", len(lowStock))
+	}
+
+	count, err := stockMovementCollection.CountDocuments(ctx, bson.M{"order_item_id": item.OrderItemID, "reason": models.StockMovementServed})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%d stock movements were recorded, want one per ingredient", count)
+	}
+}
+
+func TestFailedDepletionPutsStockBack(t *testing.T) {
+	ctx := testDatabase(t)
+
+	flour := newIngredient(10, 2)
+	missingID := primitive.NewObjectID().Hex()
+	quantity := 0.25
+	food := newFood(primitive.NewObjectID().Hex(), "Test pie", 6)
+	fixture(t, ctx, ingredientCollection, flour)
+	// the second ingredient was deleted after the recipe was written
+	fixture(t, ctx, recipeCollection, newRecipe(food.FoodID,
+		models.RecipeIngredient{IngredientID: &flour.IngredientID, Quantity: &quantity},
+		models.RecipeIngredient{IngredientID: &missingID, Quantity: &quantity},
+	))
+
+	item := newOrderItem(newOrder(nil).OrderID, food.FoodID, *food.Price)
+	cleanup(t, ctx, stockMovementCollection, bson.M{"order_item_id": item.OrderItemID})
+
+	if _, err := depleteStock(ctx, item, ""); err == nil {
+		t.Fatal("depleting a recipe with a missing ingredient did not fail")
+	}
+
+	if got := stockLevel(t, ctx, flour); got != 10 {
+		t.Errorf("flour is at %v, want it put back to 10", got)
+	}
+
+	count, err := stockMovementCollection.CountDocuments(ctx, bson.M{"order_item_id": item.OrderItemID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d stock movements were kept for a portion that was not deducted", count)
+	}
+}
@@ -2,20 +2,25 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
 	"github.com/dastardlyjockey/restaurant-management-backend/models"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
+	"math"
 	"net/http"
 	"time"
 )
 
 type InvoiceViewFormat struct {
 	InvoiceID      string
+	InvoiceNumber  string
 	PaymentMethod  string
 	OrderID        string
 	PaymentStatus  *string
@@ -23,9 +28,492 @@ type InvoiceViewFormat struct {
 	TableNumber    interface{}
 	PaymentDueDate time.Time
 	OrderDetails   interface{}
+	Subtotal       float64
+	Discounts      []models.InvoiceDiscount
+	DiscountTotal  float64
+	ServiceCharge  *models.InvoiceServiceCharge
+	TaxTotal       float64
+	Taxes          []models.InvoiceTax
+	Total          float64
+	AmountPaid     float64
+	TipTotal       float64
+	RefundTotal    float64
+	Payments       []models.Payment
 }
 
 var invoiceCollection = database.Collection(database.Client, "invoice")
+var counterCollection = database.Collection(database.Client, "counters")
+
+// fiscalYear is the fiscal year a date falls in, named after the calendar year it starts in
+func fiscalYear(settings models.Settings, date time.Time) int {
+	year := date.Year()
+	if int(date.Month()) < *settings.FiscalYearStart {
+		year--
+	}
+	return year
+}
+
+// invoiceCounterID names the invoice number sequence of the restaurant for a fiscal year
+func invoiceCounterID(year int) string {
+	return fmt.Sprintf("invoice:%s:%d", restaurantSettingsID, year)
+}
+
+// nextInvoiceNumber takes the next invoice number of the fiscal year. The counter is
+// bumped with a single findAndModify, so two invoices can never get the same number.
+func nextInvoiceNumber(ctx context.Context, year int) (int, error) {
+	var counter models.Counter
+	opt := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(true)
+	err := counterCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": invoiceCounterID(year)},
+		bson.D{{"$inc", bson.D{{"sequence", 1}}}},
+		opt,
+	).Decode(&counter)
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.Sequence, nil
+}
+
+// errOrderInvoiced is returned when an order already has an invoice that was not voided
+var errOrderInvoiced = errors.New("the order already has an invoice")
+
+// insertNumberedInvoice numbers an invoice and saves it in one transaction, so a
+// number is only ever taken by an invoice that is saved and the sequence has no
+// gaps. Transactions need MongoDB to run as a replica set; a single server can be
+// started as a one member set with --replSet and rs.initiate().
+//
+// Every transaction bumps the same counter, so two invoices for one order at once
+// conflict and the one that is retried finds the other and is refused.
+func insertNumberedInvoice(ctx context.Context, invoice *models.Invoice) (*mongo.InsertOneResult, error) {
+	session, err := database.Client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	insert := func(sessCtx mongo.SessionContext) (interface{}, error) {
+		sequence, err := nextInvoiceNumber(sessCtx, invoice.FiscalYear)
+		if err != nil {
+			return nil, err
+		}
+
+		count, err := invoiceCollection.CountDocuments(sessCtx, bson.M{
+			"order_id":       invoice.OrderID,
+			"payment_status": bson.M{"$ne": models.InvoiceStatusVoid},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if count > 0 {
+			return nil, errOrderInvoiced
+		}
+
+		invoice.InvoiceSequence = sequence
+		invoice.InvoiceNumber = invoiceNumber(invoice.FiscalYear, sequence)
+		return invoiceCollection.InsertOne(sessCtx, invoice)
+	}
+
+	result, err := session.WithTransaction(ctx, insert)
+	if mongo.IsDuplicateKeyError(err) {
+		// two first invoices of the year raced to create the counter; it exists now
+		result, err = session.WithTransaction(ctx, insert)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return result.(*mongo.InsertOneResult), nil
+}
+
+func invoiceNumber(year int, sequence int) string {
+	return fmt.Sprintf("%d-%06d", year, sequence)
+}
+
+// invoiceTotals is what an invoice charges, worked out from the items on its order.
+// Subtotal is the menu prices of the items before discounts; when menu prices include
+// tax the tax is already part of them, otherwise it is added on top to make the total.
+type invoiceTotals struct {
+	Subtotal         float64
+	Discounts        []models.InvoiceDiscount
+	DiscountTotal    float64
+	ServiceCharge    *models.InvoiceServiceCharge
+	TaxTotal         float64
+	Taxes            []models.InvoiceTax
+	PricesIncludeTax bool
+	Total            float64
+}
+
+// storedTotals are the totals an invoice was last saved with
+func storedTotals(invoice models.Invoice) invoiceTotals {
+	return invoiceTotals{
+		Subtotal:         invoice.Subtotal,
+		Discounts:        invoice.Discounts,
+		DiscountTotal:    invoice.DiscountTotal,
+		ServiceCharge:    invoice.ServiceCharge,
+		TaxTotal:         invoice.TaxTotal,
+		Taxes:            invoice.Taxes,
+		PricesIncludeTax: invoice.PricesIncludeTax,
+		Total:            invoice.Total,
+	}
+}
+
+func (totals invoiceTotals) apply(invoice *models.Invoice) {
+	invoice.Subtotal = totals.Subtotal
+	invoice.Discounts = totals.Discounts
+	invoice.DiscountTotal = totals.DiscountTotal
+	invoice.ServiceCharge = totals.ServiceCharge
+	invoice.TaxTotal = totals.TaxTotal
+	invoice.Taxes = totals.Taxes
+	invoice.PricesIncludeTax = totals.PricesIncludeTax
+	invoice.Total = totals.Total
+}
+
+// computeTax works out the tax on what is paid for each line, after discounts, at the
+// rate of the food's tax category. With LINE rounding each line's tax is rounded before
+// it is added up, with INVOICE rounding the tax is only rounded once per category.
+func computeTax(settings models.Settings, lines []invoiceLine) []models.InvoiceTax {
+	type taxBucket struct {
+		rate  models.TaxRate
+		gross float64
+		tax   float64
+	}
+
+	var categories []string
+	buckets := make(map[string]*taxBucket)
+	for _, line := range lines {
+		rate, ok := taxRate(settings, line.Food.TaxCategory)
+		if !ok {
+			continue
+		}
+
+		bucket, ok := buckets[*rate.Category]
+		if !ok {
+			bucket = &taxBucket{rate: rate}
+			buckets[*rate.Category] = bucket
+			categories = append(categories, *rate.Category)
+		}
+
+		price := line.Price
+		tax := price * *rate.Rate / 100
+		if *settings.PricesIncludeTax {
+			tax = price - price/(1+*rate.Rate/100)
+		}
+		if *settings.TaxRounding == models.TaxRoundingLine {
+			tax = toFixed(tax, 2)
+		}
+
+		bucket.gross += price
+		bucket.tax += tax
+	}
+
+	taxes := []models.InvoiceTax{}
+	for _, category := range categories {
+		bucket := buckets[category]
+
+		tax := toFixed(bucket.tax, 2)
+		taxable := toFixed(bucket.gross, 2)
+		if *settings.PricesIncludeTax {
+			taxable = toFixed(bucket.gross-tax, 2)
+		}
+
+		taxes = append(taxes, models.InvoiceTax{
+			Category: category,
+			Name:     *bucket.rate.Name,
+			Rate:     *bucket.rate.Rate,
+			Taxable:  taxable,
+			Tax:      tax,
+		})
+	}
+
+	return taxes
+}
+
+// orderPartySize is the number of guests on an order's table, or on all the tables of its group
+func orderPartySize(ctx context.Context, order models.Order) (int, error) {
+	tableIDs := []string{}
+	if order.TableID != nil {
+		tableIDs = append(tableIDs, *order.TableID)
+	}
+
+	if order.TableGroupID != nil {
+		var group models.TableGroup
+		err := tableGroupCollection.FindOne(ctx, bson.M{"table_group_id": *order.TableGroupID}).Decode(&group)
+		if err != nil {
+			return 0, err
+		}
+		tableIDs = group.TableIDs
+	}
+
+	if len(tableIDs) == 0 {
+		return 0, nil
+	}
+
+	cursor, err := tableCollection.Find(ctx, bson.M{"table_id": bson.M{"$in": tableIDs}})
+	if err != nil {
+		return 0, err
+	}
+
+	var tables []models.Table
+	if err = cursor.All(ctx, &tables); err != nil {
+		return 0, err
+	}
+
+	guests := 0
+	for _, table := range tables {
+		if table.NumberOfGuests != nil {
+			guests += *table.NumberOfGuests
+		}
+	}
+
+	return guests, nil
+}
+
+// serviceChargeRule finds the first of the restaurant's service charge rules that applies to the order
+func serviceChargeRule(settings models.Settings, order models.Order, partySize int) (models.ServiceChargeRule, bool) {
+	orderType := models.OrderTypeDineIn
+	if order.OrderType != nil {
+		orderType = *order.OrderType
+	}
+
+	for _, rule := range settings.ServiceChargeRules {
+		if rule.MinPartySize != nil && partySize < *rule.MinPartySize {
+			continue
+		}
+
+		if len(rule.OrderTypes) > 0 {
+			matches := false
+			for _, ruleOrderType := range rule.OrderTypes {
+				if ruleOrderType == orderType {
+					matches = true
+				}
+			}
+			if !matches {
+				continue
+			}
+		}
+
+		return rule, true
+	}
+
+	return models.ServiceChargeRule{}, false
+}
+
+func computeInvoiceTotals(ctx context.Context, invoice models.Invoice) (invoiceTotals, error) {
+	var totals invoiceTotals
+
+	settings, err := restaurantSettings(ctx)
+	if err != nil {
+		return totals, err
+	}
+
+	lines, err := invoiceLines(ctx, invoice.OrderID)
+	if err != nil {
+		return totals, err
+	}
+
+	subtotal := 0.0
+	for _, line := range lines {
+		subtotal += line.Price
+	}
+	totals.Subtotal = toFixed(subtotal, 2)
+
+	totals.Discounts, err = applyDiscounts(ctx, invoice, lines)
+	if err != nil {
+		return totals, err
+	}
+
+	afterDiscounts := 0.0
+	for _, line := range lines {
+		afterDiscounts += line.Price
+	}
+	totals.DiscountTotal = toFixed(totals.Subtotal-afterDiscounts, 2)
+
+	// the service charge is worked out on the bill after discounts and is taxed as a line of its own
+	taxLines := lines
+	serviceCharge := 0.0
+	if invoice.ServiceChargeRemoval == nil && len(settings.ServiceChargeRules) > 0 {
+		var order models.Order
+		err = orderCollection.FindOne(ctx, bson.M{"order_id": invoice.OrderID}).Decode(&order)
+		if err != nil {
+			return totals, err
+		}
+
+		partySize, err := orderPartySize(ctx, order)
+		if err != nil {
+			return totals, err
+		}
+
+		if rule, ok := serviceChargeRule(settings, order, partySize); ok {
+			serviceCharge = toFixed(*rule.Value, 2)
+			if *rule.Type == models.PromotionPercentage {
+				serviceCharge = percentOff(*rule.Type, *rule.Value, afterDiscounts)
+			}
+
+			totals.ServiceCharge = &models.InvoiceServiceCharge{
+				Name:      *rule.Name,
+				Type:      *rule.Type,
+				Value:     *rule.Value,
+				PartySize: partySize,
+				Amount:    serviceCharge,
+				Taxable:   rule.Taxable,
+			}
+
+			if rule.Taxable {
+				chargeLine := invoiceLine{Price: serviceCharge}
+				chargeLine.Food.TaxCategory = rule.TaxCategory
+				taxLines = append(append([]invoiceLine{}, lines...), chargeLine)
+			}
+		}
+	}
+
+	totals.PricesIncludeTax = *settings.PricesIncludeTax
+	totals.Taxes = computeTax(settings, taxLines)
+	for _, tax := range totals.Taxes {
+		totals.TaxTotal += tax.Tax
+	}
+	totals.TaxTotal = toFixed(totals.TaxTotal, 2)
+
+	totals.Total = toFixed(afterDiscounts+serviceCharge, 2)
+	if !totals.PricesIncludeTax {
+		totals.Total = toFixed(afterDiscounts+serviceCharge+totals.TaxTotal, 2)
+	}
+
+	return totals, nil
+}
+
+// invoiceStatus is worked out from the payments: PAID once the amounts taken cover
+// the total, PARTIALLY_PAID while some money has been taken and PENDING before that.
+// Refunds are counted apart from what was taken, so giving money back never makes
+// a balance due again: a paid invoice with some of its money given back is
+// PARTIALLY_REFUNDED and one with all of it given back is REFUNDED. A voided
+// invoice stays VOID.
+func invoiceStatus(current string, total float64, payments paymentTotals) string {
+	switch {
+	case current == models.InvoiceStatusVoid:
+		return models.InvoiceStatusVoid
+	case payments.Gross > 0 && payments.Paid+payments.Tips <= 0:
+		return models.InvoiceStatusRefunded
+	case payments.Gross >= total && (total > 0 || current == models.InvoiceStatusPaid):
+		if payments.Refunded > 0 {
+			return models.InvoiceStatusPartiallyRefunded
+		}
+		return models.InvoiceStatusPaid
+	case payments.Gross > 0:
+		return models.InvoiceStatusPartiallyPaid
+	default:
+		return models.InvoiceStatusPending
+	}
+}
+
+// recalculateInvoice refreshes the stored totals and payment status of an invoice
+// and closes the order the first time the invoice is paid in full. Once an invoice
+// has been paid or voided its totals are kept as they were charged.
+func recalculateInvoice(ctx context.Context, invoiceID string) (models.Invoice, error) {
+	var invoice models.Invoice
+	err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice)
+	if err != nil {
+		return invoice, err
+	}
+
+	current := ""
+	if invoice.PaymentStatus != nil {
+		current = *invoice.PaymentStatus
+	}
+
+	totals := storedTotals(invoice)
+	if invoice.PaidAt == nil && current != models.InvoiceStatusVoid {
+		totals, err = computeInvoiceTotals(ctx, invoice)
+		if err != nil {
+			return invoice, err
+		}
+	}
+
+	payments, err := invoicePayments(ctx, invoiceID)
+	if err != nil {
+		return invoice, err
+	}
+
+	status := invoiceStatus(current, totals.Total, payments)
+
+	// the balance is what is still to be taken; money given back is not owed again
+	totals.apply(&invoice)
+	invoice.AmountPaid = payments.Paid
+	invoice.TipTotal = payments.Tips
+	invoice.BalanceDue = toFixed(math.Max(totals.Total-payments.Gross, 0), 2)
+	invoice.RefundTotal = payments.Refunded
+	if status == models.InvoiceStatusVoid {
+		invoice.BalanceDue = 0
+	}
+	invoice.PaymentStatus = &status
+	invoice.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	settled := false
+	fullyPaid := status == models.InvoiceStatusPaid || status == models.InvoiceStatusPartiallyRefunded
+	if fullyPaid && invoice.PaidAt == nil {
+		invoice.PaidAt = &invoice.UpdatedAt
+		settled = true
+	}
+
+	_, err = invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoiceID}, bson.D{{"$set", bson.D{
+		{"subtotal", invoice.Subtotal},
+		{"discounts", invoice.Discounts},
+		{"discount_total", invoice.DiscountTotal},
+		{"service_charge", invoice.ServiceCharge},
+		{"tax_total", invoice.TaxTotal},
+		{"taxes", invoice.Taxes},
+		{"prices_include_tax", invoice.PricesIncludeTax},
+		{"total", invoice.Total},
+		{"amount_paid", invoice.AmountPaid},
+		{"tip_total", invoice.TipTotal},
+		{"balance_due", invoice.BalanceDue},
+		{"refund_total", invoice.RefundTotal},
+		{"payment_status", status},
+		{"paid_at", invoice.PaidAt},
+		{"updated_at", invoice.UpdatedAt},
+	}}})
+	if err != nil {
+		return invoice, err
+	}
+
+	// the first time the invoice is paid it settles the order and frees its table
+	if settled && invoice.OrderID != "" {
+		err = closeOrder(ctx, invoice.OrderID)
+		if err != nil {
+			log.Println("Error closing the paid order: ", err)
+		}
+	}
+
+	return invoice, nil
+}
+
+// recalculateOpenInvoices refreshes the invoices matching the filter that are still
+// open, after a change to what they are worked out from: the order items, the
+// settings or the promotions. Settled and voided invoices keep their totals.
+func recalculateOpenInvoices(ctx context.Context, filter bson.M) error {
+	filter["paid_at"] = nil
+	filter["payment_status"] = bson.M{"$ne": models.InvoiceStatusVoid}
+
+	cursor, err := invoiceCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"invoice_id": 1}))
+	if err != nil {
+		return err
+	}
+
+	var invoices []models.Invoice
+	if err = cursor.All(ctx, &invoices); err != nil {
+		return err
+	}
+
+	for _, invoice := range invoices {
+		_, err = recalculateInvoice(ctx, invoice.InvoiceID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
 func CreateInvoice() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -42,7 +530,7 @@ func CreateInvoice() gin.HandlerFunc {
 
 		err = validate.Struct(invoice)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "invoice validation failed"})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invoice validation failed"})
 			return
 		}
 
@@ -53,24 +541,68 @@ func CreateInvoice() gin.HandlerFunc {
 		defer cancel()
 		err = orderCollection.FindOne(ctx, bson.M{"order_id": invoice.OrderID}).Decode(&order)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "order is not found"})
+			c.JSON(http.StatusNotFound, gin.H{"error": "order is not found"})
+			return
+		}
+
+		// the guests are counted now, before the table is freed for the next party
+		invoice.Covers, err = orderPartySize(ctx, order)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count the guests on the order"})
+			return
+		}
+
+		// discounts are added to the invoice once it exists
+		invoice.CouponCode = nil
+		invoice.ManualDiscounts = nil
+		invoice.ServiceChargeRemoval = nil
+		invoice.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		// the amount due comes from the order, payments are recorded against the invoice
+		totals, err := computeInvoiceTotals(ctx, invoice)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to work out the invoice total"})
 			return
 		}
 
+		status := models.InvoiceStatusPending
+		invoice.PaymentStatus = &status
+		totals.apply(&invoice)
+		invoice.AmountPaid = 0
+		invoice.TipTotal = 0
+		invoice.BalanceDue = totals.Total
+		invoice.RefundTotal = 0
+		invoice.VoidReason = nil
+		invoice.VoidedBy = nil
+		invoice.VoidApprovedBy = nil
+		invoice.VoidedAt = nil
+		invoice.PaidAt = nil
+
 		// add the invoice to the database
 		invoice.ID = primitive.NewObjectID()
 		invoice.InvoiceID = invoice.ID.Hex()
 
-		invoice.CreatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		invoice.UpdatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		if err != nil {
-			log.Println("Error creating timestamp: ", err)
+			msg := fmt.Sprintf("Failed to create timestamp, error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
 
-		result, err := invoiceCollection.InsertOne(ctx, &invoice)
+		settings, err := restaurantSettings(ctx)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": ""})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the settings in the database"})
+			return
+		}
+
+		invoice.FiscalYear = fiscalYear(settings, invoice.CreatedAt)
+		result, err := insertNumberedInvoice(ctx, &invoice)
+		if errors.Is(err, errOrderInvoiced) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the order already has an invoice, void it before billing the order again"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to number and save the invoice"})
 			return
 		}
 
@@ -81,21 +613,46 @@ func CreateInvoice() gin.HandlerFunc {
 
 func GetInvoices() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cursor, err := invoiceCollection.Find(context.TODO(), bson.M{})
-		if err != nil {
-			msg := fmt.Sprintf("Error getting the invoices, error:%s", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at", "payment_due_date", "invoice_number"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if paymentStatus := c.Query("payment_status"); paymentStatus != "" {
+			filter["payment_status"] = paymentStatus
+		}
+
+		if paymentMethod := c.Query("payment_method"); paymentMethod != "" {
+			filter["payment_method"] = paymentMethod
+		}
+
+		if orderId := c.Query("order_id"); orderId != "" {
+			filter["order_id"] = orderId
+		}
+
+		if invoiceNumber := c.Query("invoice_number"); invoiceNumber != "" {
+			filter["invoice_number"] = invoiceNumber
+		}
+
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 			return
 		}
 
-		var results []bson.M
-		err = cursor.All(context.TODO(), &results)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		invoices := []models.Invoice{}
+		result, err := listQuery.Find(ctx, invoiceCollection, filter, &invoices)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store the invoice results"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error getting the invoices"})
 			return
 		}
 
-		c.JSON(http.StatusOK, results)
+		c.JSON(http.StatusOK, result)
 	}
 }
 
@@ -107,6 +664,7 @@ func GetInvoiceById() gin.HandlerFunc {
 		//search for the invoice in the database
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
+
 		var invoice models.Invoice
 		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceId}).Decode(&invoice)
 		if err != nil {
@@ -114,6 +672,12 @@ func GetInvoiceById() gin.HandlerFunc {
 			return
 		}
 
+		payments, err := paymentsForInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the invoice payments"})
+			return
+		}
+
 		// integrate the invoice into my created format
 		var invoiceView InvoiceViewFormat
 
@@ -125,12 +689,25 @@ func GetInvoiceById() gin.HandlerFunc {
 
 		invoiceView.OrderID = invoice.OrderID
 		invoiceView.InvoiceID = invoice.InvoiceID
+		invoiceView.InvoiceNumber = invoice.InvoiceNumber
 		invoiceView.PaymentDueDate = invoice.PaymentDueDate
 		invoiceView.PaymentMethod = "Null"
 		if invoice.PaymentMethod != nil {
 			invoiceView.PaymentMethod = *invoice.PaymentMethod
 		}
 		invoiceView.PaymentStatus = *&invoice.PaymentStatus
+		invoiceView.PaymentDue = invoice.BalanceDue
+		invoiceView.Subtotal = invoice.Subtotal
+		invoiceView.Discounts = invoice.Discounts
+		invoiceView.DiscountTotal = invoice.DiscountTotal
+		invoiceView.ServiceCharge = invoice.ServiceCharge
+		invoiceView.TaxTotal = invoice.TaxTotal
+		invoiceView.Taxes = invoice.Taxes
+		invoiceView.Total = invoice.Total
+		invoiceView.AmountPaid = invoice.AmountPaid
+		invoiceView.TipTotal = invoice.TipTotal
+		invoiceView.RefundTotal = invoice.RefundTotal
+		invoiceView.Payments = payments
 		//invoiceView.PaymentDue = orderItems[0]{"payment_due"}
 		//invoiceView.OrderDetails = orderItems[0]{"order_details"}
 		//invoiceView.TableNumber = orderItems[0]{"table_number"}
@@ -162,36 +739,405 @@ func UpdateInvoice() gin.HandlerFunc {
 		invoiceId := c.Param("invoice_id")
 		filter := bson.M{"invoice_id": invoiceId}
 
-		var updateObj primitive.D
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var existing models.Invoice
+		err = invoiceCollection.FindOne(ctx, filter).Decode(&existing)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice was not found"})
+			return
+		}
+
+		if existing.PaymentStatus != nil && *existing.PaymentStatus == models.InvoiceStatusVoid {
+			c.JSON(http.StatusConflict, gin.H{"error": "the invoice has been voided"})
+			return
+		}
+
+		if existing.LockedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": invoiceLockedMsg})
+			return
+		}
+
+		// refunds and voids have their own endpoints so they are approved and logged
+		switch *invoice.PaymentStatus {
+		case models.InvoiceStatusVoid, models.InvoiceStatusRefunded, models.InvoiceStatusPartiallyRefunded:
+			msg := fmt.Sprintf("an invoice cannot be set to %s here, refund its payments or void it instead", *invoice.PaymentStatus)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
 
 		// add the update to the database
 		if invoice.PaymentMethod != nil {
-			updateObj = append(updateObj, bson.E{Key: "payment_method", Value: invoice.PaymentMethod})
+			_, err = invoiceCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{{"payment_method", invoice.PaymentMethod}}}})
+			if err != nil {
+				msg := fmt.Sprintf("Error updating the invoice database: %s", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				return
+			}
+		}
+
+		// the payment status follows the payments recorded against the invoice
+		current, err := recalculateInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to work out the invoice payments"})
+			return
+		}
+
+		if *invoice.PaymentStatus == models.InvoiceStatusPaid && current.AmountPaid < current.Total {
+			msg := fmt.Sprintf("payments of %.2f do not cover the invoice total of %.2f", current.AmountPaid, current.Total)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		if *invoice.PaymentStatus != models.InvoiceStatusPaid && *invoice.PaymentStatus != *current.PaymentStatus {
+			msg := fmt.Sprintf("the invoice is %s from the payments recorded against it", *current.PaymentStatus)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
 		}
 
-		if invoice.PaymentStatus != nil {
-			updateObj = append(updateObj, bson.E{Key: "payment_status", Value: invoice.PaymentStatus})
+		// an invoice with nothing left to pay can be marked paid
+		if *invoice.PaymentStatus == models.InvoiceStatusPaid && *current.PaymentStatus != models.InvoiceStatusPaid {
+			_, err = invoiceCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{{"payment_status", models.InvoiceStatusPaid}}}})
+			if err == nil {
+				current, err = recalculateInvoice(ctx, invoiceId)
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark the invoice as paid"})
+				return
+			}
+
+			recordAudit(ctx, models.AuditEntry{
+				Action:    models.AuditInvoiceMarkedPaid,
+				InvoiceID: invoiceId,
+				Amount:    current.Total,
+				UserID:    c.GetString("uid"),
+			})
 		}
 
-		status := "PENDING"
-		if invoice.PaymentStatus == nil {
-			invoice.PaymentStatus = &status
-			updateObj = append(updateObj, bson.E{Key: "payment_status", Value: invoice.PaymentStatus})
+		//response
+		c.JSON(http.StatusOK, current)
+	}
+}
+
+// VoidInvoice cancels an invoice so nothing more can be paid against it. It needs
+// a reason and a manager's approval, and any money taken has to be refunded first.
+func VoidInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Reason   *string                 `json:"reason" validate:"required,min=3,max=500"`
+			Approval *models.ManagerApproval `json:"approval"`
 		}
 
-		upsert := true
-		obj := options.UpdateOptions{Upsert: &upsert}
+		err := c.BindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid void JSON"})
+			return
+		}
+
+		err = validate.Struct(request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "voiding an invoice needs a reason"})
+			return
+		}
+
+		invoiceId := c.Param("invoice_id")
+		uid := c.GetString("uid")
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		result, err := invoiceCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &obj)
+
+		approvedBy, code, msg := managerApproval(ctx, uid, request.Approval)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		invoice, err := recalculateInvoice(ctx, invoiceId)
 		if err != nil {
-			msg := fmt.Sprintf("Error updating the invoice database: %s", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice was not found"})
 			return
 		}
 
-		//response
-		c.JSON(http.StatusOK, result)
+		if *invoice.PaymentStatus == models.InvoiceStatusVoid {
+			c.JSON(http.StatusConflict, gin.H{"error": "the invoice has already been voided"})
+			return
+		}
+
+		if invoice.LockedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": invoiceLockedMsg})
+			return
+		}
+
+		if invoice.AmountPaid > 0 || invoice.TipTotal > 0 {
+			msg := fmt.Sprintf("%.2f has been taken on the invoice, refund it before voiding", toFixed(invoice.AmountPaid+invoice.TipTotal, 2))
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		// the status guard stops a payment or a second void slipping in at the same time
+		voidedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := invoiceCollection.UpdateOne(ctx, bson.M{
+			"invoice_id":     invoiceId,
+			"payment_status": invoice.PaymentStatus,
+		}, bson.D{{"$set", bson.D{
+			{"payment_status", models.InvoiceStatusVoid},
+			{"balance_due", 0},
+			{"void_reason", request.Reason},
+			{"voided_by", uid},
+			{"void_approved_by", approvedBy},
+			{"voided_at", voidedAt},
+			{"updated_at", voidedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to void the invoice"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the invoice changed while it was being voided, try again"})
+			return
+		}
+
+		recordAudit(ctx, models.AuditEntry{
+			Action:     models.AuditInvoiceVoided,
+			InvoiceID:  invoiceId,
+			Amount:     -invoice.Total,
+			Reason:     request.Reason,
+			UserID:     uid,
+			ApprovedBy: &approvedBy,
+		})
+
+		invoice, err = recalculateInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+			return
+		}
+
+		c.JSON(http.StatusOK, invoice)
+	}
+}
+
+// RemoveServiceCharge takes the service charge off an invoice before it is paid.
+// It needs a reason and a manager's approval.
+func RemoveServiceCharge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Reason   *string                 `json:"reason" validate:"required,min=3,max=500"`
+			Approval *models.ManagerApproval `json:"approval"`
+		}
+
+		err := c.BindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service charge JSON"})
+			return
+		}
+
+		err = validate.Struct(request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "removing the service charge needs a reason"})
+			return
+		}
+
+		invoiceId := c.Param("invoice_id")
+		uid := c.GetString("uid")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		approvedBy, code, msg := managerApproval(ctx, uid, request.Approval)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		invoice, code, msg := openInvoice(ctx, invoiceId)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		if invoice.ServiceCharge == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the invoice has no service charge"})
+			return
+		}
+
+		removal := models.ServiceChargeRemoval{
+			Reason:     *request.Reason,
+			RemovedBy:  uid,
+			ApprovedBy: approvedBy,
+		}
+		removal.RemovedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		_, err = invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoiceId}, bson.D{{"$set", bson.D{
+			{"service_charge_removal", removal},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove the service charge"})
+			return
+		}
+
+		before := invoice.Total
+		invoice, err = recalculateInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+			return
+		}
+
+		recordAudit(ctx, models.AuditEntry{
+			Action:     models.AuditServiceChargeRemoved,
+			InvoiceID:  invoiceId,
+			Amount:     toFixed(invoice.Total-before, 2),
+			Reason:     request.Reason,
+			UserID:     uid,
+			ApprovedBy: &approvedBy,
+		})
+
+		c.JSON(http.StatusOK, invoice)
+	}
+}
+
+// invoiceReceipt gathers what is printed on an invoice's receipt: the restaurant's
+// details, the items at menu price, and the payments that went through
+func invoiceReceipt(ctx context.Context, invoice models.Invoice, settings models.Settings) (models.Receipt, error) {
+	receipt := models.Receipt{
+		Invoice:  invoice,
+		Lines:    []models.ReceiptLine{},
+		Payments: []models.ReceiptPayment{},
+	}
+	receipt.PrintedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+	if settings.RestaurantName != nil {
+		receipt.RestaurantName = *settings.RestaurantName
+	}
+	if settings.Address != nil {
+		receipt.Address = *settings.Address
+	}
+	if settings.Phone != nil {
+		receipt.Phone = *settings.Phone
+	}
+	if settings.TaxNumber != nil {
+		receipt.TaxNumber = *settings.TaxNumber
+	}
+	if settings.ReceiptFooter != nil {
+		receipt.Footer = *settings.ReceiptFooter
+	}
+
+	lines, err := invoiceLines(ctx, invoice.OrderID)
+	if err != nil {
+		return receipt, err
+	}
+
+	for _, line := range lines {
+		name := ""
+		if line.Food.Name != nil {
+			name = *line.Food.Name
+		}
+		receipt.Lines = append(receipt.Lines, models.ReceiptLine{
+			Name:     name,
+			Quantity: *line.OrderItem.Quantity,
+			Price:    line.Price,
+		})
+	}
+
+	var order models.Order
+	err = orderCollection.FindOne(ctx, bson.M{"order_id": invoice.OrderID}).Decode(&order)
+	if err == nil && order.TableID != nil {
+		var table models.Table
+		err = tableCollection.FindOne(ctx, bson.M{"table_id": *order.TableID}).Decode(&table)
+		if err == nil && table.TableNumber != nil {
+			receipt.TableNumber = *table.TableNumber
+		}
+	}
+
+	payments, err := paymentsForInvoice(ctx, invoice.InvoiceID)
+	if err != nil {
+		return receipt, err
+	}
+
+	for _, payment := range payments {
+		switch payment.Status {
+		case models.PaymentStatusPending, models.PaymentStatusDeclined, models.PaymentStatusFailed:
+			continue
+		}
+
+		printed := models.ReceiptPayment{
+			Method:   *payment.Method,
+			Amount:   *payment.Amount,
+			Change:   payment.Change,
+			Refunded: payment.Refunded,
+		}
+		if payment.Tip != nil {
+			printed.Tip = *payment.Tip
+		}
+		if payment.Tendered != nil {
+			printed.Tendered = *payment.Tendered
+		}
+		receipt.Payments = append(receipt.Payments, printed)
+	}
+
+	return receipt, nil
+}
+
+// GetInvoiceReceipt prints the customer receipt for an invoice with the restaurant's
+// receipt template. The format query picks a PDF (the default), an ESC/POS stream
+// for a thermal printer, or plain text. The QR code holds the invoice ID.
+func GetInvoiceReceipt() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invoiceId := c.Param("invoice_id")
+
+		format := c.DefaultQuery("format", "pdf")
+		if format != "pdf" && format != "escpos" && format != "text" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf, escpos or text"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var invoice models.Invoice
+		err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceId}).Decode(&invoice)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "The invoice is not in the database"})
+			return
+		}
+
+		settings, err := restaurantSettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the settings in the database"})
+			return
+		}
+
+		receipt, err := invoiceReceipt(ctx, invoice, settings)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the receipt details"})
+			return
+		}
+
+		layout := ""
+		if settings.ReceiptTemplate != nil {
+			layout = *settings.ReceiptTemplate
+		}
+
+		lines, err := helpers.RenderReceipt(layout, receipt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to print the receipt: " + err.Error()})
+			return
+		}
+
+		switch format {
+		case "escpos":
+			c.Data(http.StatusOK, "application/octet-stream", helpers.ReceiptESCPOS(lines, invoice.InvoiceID))
+		case "text":
+			c.Data(http.StatusOK, "text/plain; charset=utf-8", helpers.ReceiptText(lines, invoice.InvoiceID))
+		default:
+			pdf, err := helpers.ReceiptPDF(lines, invoice.InvoiceID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to make the receipt PDF"})
+				return
+			}
+			c.Header("Content-Disposition", fmt.Sprintf("inline; filename=receipt-%s.pdf", invoice.InvoiceID))
+			c.Data(http.StatusOK, "application/pdf", pdf)
+		}
 	}
 }
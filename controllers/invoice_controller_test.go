@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFiscalYear(t *testing.T) {
+	april, january := 4, 1
+	tests := []struct {
+		start int
+		date  time.Time
+		want  int
+	}{
+		{january, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), 2026},
+		{january, time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC), 2026},
+		{april, time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC), 2025},
+		{april, time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), 2026},
+	}
+
+	for _, test := range tests {
+		settings := models.Settings{FiscalYearStart: &test.start}
+		if got := fiscalYear(settings, test.date); got != test.want {
+			t.Errorf("fiscal year of %s starting in month %d: got %d, want %d", test.date.Format("2006-01-02"), test.start, got, test.want)
+		}
+	}
+}
+
+func TestRemovedServiceChargeStaysOff(t *testing.T) {
+	ctx := testDatabase(t)
+
+	chargeName, chargeType, chargeValue := "Service", models.PromotionFixed, 4.0
+	useSettings(t, ctx, models.Settings{ServiceChargeRules: []models.ServiceChargeRule{
+		{Name: &chargeName, Type: &chargeType, Value: &chargeValue},
+	}})
+
+	order := newOrder(nil)
+	invoice := newInvoice(order.OrderID, models.InvoiceStatusPending)
+	manager := newUser(models.UserRoleManager)
+	fixture(t, ctx, orderCollection, order)
+	fixture(t, ctx, orderItemsCollection, newOrderItem(order.OrderID, primitive.NewObjectID().Hex(), 20))
+	fixture(t, ctx, invoiceCollection, invoice)
+	fixture(t, ctx, UserCollection, manager)
+	cleanup(t, ctx, auditCollection, bson.M{"invoice_id": invoice.InvoiceID})
+
+	charged, err := recalculateInvoice(ctx, invoice.InvoiceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if charged.ServiceCharge == nil || charged.Total != 24 {
+		t.Fatalf("got service charge %+v and total %v, want a charge of 4 on 24", charged.ServiceCharge, charged.Total)
+	}
+
+	recorder := serve(withParam(RemoveServiceCharge(), "invoice_id", invoice.InvoiceID), http.MethodDelete, "/invoices/"+invoice.InvoiceID+"/service-charge", gin.H{"reason": "slow service"}, manager.UserID)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("removing the service charge: got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var removed models.Invoice
+	if err := json.Unmarshal(recorder.Body.Bytes(), &removed); err != nil {
+		t.Fatal(err)
+	}
+	if removed.ServiceCharge != nil || removed.Total != 20 {
+		t.Errorf("got service charge %+v and total %v after removing it, want none on 20", removed.ServiceCharge, removed.Total)
+	}
+
+	// the removal has to be read back on every later recalculation
+	recalculated, err := recalculateInvoice(ctx, invoice.InvoiceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recalculated.ServiceChargeRemoval == nil || recalculated.ServiceCharge != nil || recalculated.Total != 20 {
+		t.Errorf("the service charge came back: got %+v and total %v", recalculated.ServiceCharge, recalculated.Total)
+	}
+}
+
+func TestOrderIsInvoicedOnce(t *testing.T) {
+	ctx := testDatabase(t)
+
+	order := newOrder(nil)
+	fixture(t, ctx, orderCollection, order)
+	fixture(t, ctx, orderItemsCollection, newOrderItem(order.OrderID, primitive.NewObjectID().Hex(), 20))
+	cleanup(t, ctx, invoiceCollection, bson.M{"order_id": order.OrderID})
+
+	create := func(orderID string) int {
+		return serve(CreateInvoice(), http.MethodPost, "/invoices", gin.H{"order_id": orderID, "payment_status": models.InvoiceStatusPending}, "").Code
+	}
+
+	if code := create(order.OrderID); code != http.StatusOK {
+		t.Fatalf("billing the order: got %d", code)
+	}
+
+	if code := create(order.OrderID); code != http.StatusConflict {
+		t.Errorf("billing the order a second time: got %d, want %d", code, http.StatusConflict)
+	}
+
+	// once the first invoice is voided the order can be billed again
+	_, err := invoiceCollection.UpdateMany(ctx, bson.M{"order_id": order.OrderID}, bson.D{{"$set", bson.D{
+		{"payment_status", models.InvoiceStatusVoid},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := create(order.OrderID); code != http.StatusOK {
+		t.Errorf("billing the order after voiding its invoice: got %d", code)
+	}
+
+	if code := create(primitive.NewObjectID().Hex()); code != http.StatusNotFound {
+		t.Errorf("billing an order that does not exist: got %d, want %d", code, http.StatusNotFound)
+	}
+
+	recorder := serve(CreateInvoice(), http.MethodPost, "/invoices", gin.H{"order_id": order.OrderID}, "")
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("an invoice without a status: got %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestInvoiceStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		current  string
+		total    float64
+		payments paymentTotals
+		want     string
+	}{
+		{"nothing taken", models.InvoiceStatusPending, 60, paymentTotals{}, models.InvoiceStatusPending},
+		{"part paid", models.InvoiceStatusPending, 60, paymentTotals{Gross: 20, Paid: 20}, models.InvoiceStatusPartiallyPaid},
+		{"paid in full", models.InvoiceStatusPartiallyPaid, 60, paymentTotals{Gross: 60, Paid: 60, Tips: 5}, models.InvoiceStatusPaid},
+		{"nothing due", models.InvoiceStatusPaid, 0, paymentTotals{}, models.InvoiceStatusPaid},
+		{"part refunded", models.InvoiceStatusPaid, 60, paymentTotals{Gross: 60, Paid: 40, Tips: 5, Refunded: 20}, models.InvoiceStatusPartiallyRefunded},
+		{"tip refunded", models.InvoiceStatusPaid, 60, paymentTotals{Gross: 60, Paid: 60, Refunded: 5}, models.InvoiceStatusPartiallyRefunded},
+		{"all refunded", models.InvoiceStatusPartiallyRefunded, 60, paymentTotals{Gross: 60, Refunded: 65}, models.InvoiceStatusRefunded},
+		{"part paid and refunded", models.InvoiceStatusPartiallyPaid, 60, paymentTotals{Gross: 20, Refunded: 20}, models.InvoiceStatusRefunded},
+		{"part paid, some refunded", models.InvoiceStatusPartiallyPaid, 60, paymentTotals{Gross: 20, Paid: 10, Refunded: 10}, models.InvoiceStatusPartiallyPaid},
+		{"voided", models.InvoiceStatusVoid, 60, paymentTotals{}, models.InvoiceStatusVoid},
+	}
+
+	for _, test := range tests {
+		if got := invoiceStatus(test.current, test.total, test.payments); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
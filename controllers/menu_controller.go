@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
 	"github.com/dastardlyjockey/restaurant-management-backend/models"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,13 +12,136 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 )
 
 var menuCollection = database.Collection(database.Client, "menu")
 
-func inTimeSpan(start, end, check time.Time) bool {
-	return start.After(time.Now()) && end.After(start)
+// validMenuDates checks that a menu's date window, when both ends are given, starts before it ends
+func validMenuDates(start, end *time.Time) bool {
+	if start == nil || end == nil {
+		return true
+	}
+	return start.Before(*end)
+}
+
+// menuNameTaken reports whether another live menu in the category already uses the name
+func menuNameTaken(ctx context.Context, name string, category string, excludeMenuID string) (bool, error) {
+	filter := bson.M{
+		"name":     name,
+		"category": category,
+		"archived": bson.M{"$ne": true},
+	}
+	if excludeMenuID != "" {
+		filter["menu_id"] = bson.M{"$ne": excludeMenuID}
+	}
+
+	count, err := menuCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// minuteOfDay parses a "15:04" clock time into minutes after midnight
+func minuteOfDay(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func weekdayCode(day time.Weekday) string {
+	return strings.ToUpper(day.String()[:3])
+}
+
+func validSchedules(schedules []models.MenuSchedule) error {
+	for _, schedule := range schedules {
+		start, err := minuteOfDay(schedule.StartTime)
+		if err != nil {
+			return fmt.Errorf("start_time %q must be in the format HH:MM", schedule.StartTime)
+		}
+
+		end, err := minuteOfDay(schedule.EndTime)
+		if err != nil {
+			return fmt.Errorf("end_time %q must be in the format HH:MM", schedule.EndTime)
+		}
+
+		if start == end {
+			return fmt.Errorf("the %s schedule starts and ends at the same time", schedule.Name)
+		}
+
+		if _, err = time.LoadLocation(schedule.Timezone); err != nil {
+			return fmt.Errorf("unknown timezone %q", schedule.Timezone)
+		}
+	}
+
+	return nil
+}
+
+// scheduleActiveAt reports whether the daypart is running at the given time in its own timezone.
+// A daypart that ends before it starts, such as 22:00 to 02:00, runs past midnight into the next day.
+func scheduleActiveAt(schedule models.MenuSchedule, at time.Time) bool {
+	loc, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return false
+	}
+
+	start, err := minuteOfDay(schedule.StartTime)
+	if err != nil {
+		return false
+	}
+
+	end, err := minuteOfDay(schedule.EndTime)
+	if err != nil {
+		return false
+	}
+
+	local := at.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	runsOn := func(day time.Weekday) bool {
+		for _, code := range schedule.Days {
+			if code == weekdayCode(day) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if start < end {
+		return runsOn(local.Weekday()) && minute >= start && minute < end
+	}
+
+	yesterday := local.AddDate(0, 0, -1).Weekday()
+	return (runsOn(local.Weekday()) && minute >= start) || (runsOn(yesterday) && minute < end)
+}
+
+// menuActiveAt reports whether the menu is being served at the given time,
+// taking both its date window and its daypart schedules into account
+func menuActiveAt(menu models.Menu, at time.Time) bool {
+	if menu.StartDate != nil && at.Before(*menu.StartDate) {
+		return false
+	}
+
+	if menu.EndDate != nil && !at.Before(*menu.EndDate) {
+		return false
+	}
+
+	if len(menu.Schedules) == 0 {
+		return true
+	}
+
+	for _, schedule := range menu.Schedules {
+		if scheduleActiveAt(schedule, at) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func CreateMenu() gin.HandlerFunc {
@@ -38,45 +162,129 @@ func CreateMenu() gin.HandlerFunc {
 			return
 		}
 
+		err = validSchedules(menu.Schedules)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if !validMenuDates(menu.StartDate, menu.EndDate) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "start_date must be before end_date"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		defer cancel()
+
+		// menu names are unique within a category
+		taken, err := menuNameTaken(ctx, menu.Name, menu.Category, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the menu name"})
+			return
+		}
+
+		if taken {
+			msg := fmt.Sprintf("a %s menu named %s already exists", menu.Category, menu.Name)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
 		// insert into the database
 		menu.CreatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		menu.UpdatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		if err != nil {
-			log.Println("Failed in creating food timestamp")
+			log.Println("Failed in creating menu timestamp")
 		}
+		menu.Archived = false
+		menu.ArchivedAt = nil
 		menu.ID = primitive.NewObjectID()
 		menu.MenuID = menu.ID.Hex()
 
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
-		defer cancel()
-		result, err := foodCollection.InsertOne(ctx, menu)
+		_, err = menuCollection.InsertOne(ctx, menu)
 		if err != nil {
 			msg := fmt.Sprintf("Menu was not created in the database")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
 
-		// response for successful food item insertion
-		c.JSON(http.StatusOK, result)
+		// response for successful menu insertion
+		c.JSON(http.StatusCreated, menu)
 	}
 }
 
 func GetMenus() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		result, err := menuCollection.Find(context.TODO(), bson.M{})
+		listQuery, msg := helpers.ParseListQuery(c, []string{"name", "category", "start_date", "created_at"}, "name", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		// archived menus are hidden unless asked for
+		filter := bson.M{"archived": bson.M{"$ne": true}}
+		if c.Query("include_archived") == "true" {
+			filter = bson.M{}
+		}
+
+		if category := c.Query("category"); category != "" {
+			filter["category"] = category
+		}
+
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		menus := []models.Menu{}
+		result, err := listQuery.Find(ctx, menuCollection, filter, &menus)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the menus"})
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetActiveMenus() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// menus can be previewed for another time with ?at=
+		at := time.Now()
+		if c.Query("at") != "" {
+			var err error
+			at, err = time.Parse(time.RFC3339, c.Query("at"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "at must be an RFC3339 timestamp"})
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		var allMenus []bson.M
-		if err = result.All(ctx, &allMenus); err != nil {
-			log.Fatal(err)
+
+		cursor, err := menuCollection.Find(ctx, bson.M{"archived": bson.M{"$ne": true}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the menus"})
+			return
 		}
 
-		c.JSON(http.StatusOK, allMenus)
+		var allMenus []models.Menu
+		if err = cursor.All(ctx, &allMenus); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the menus"})
+			return
+		}
+
+		activeMenus := []models.Menu{}
+		for _, menu := range allMenus {
+			if menuActiveAt(menu, at) {
+				activeMenus = append(activeMenus, menu)
+			}
+		}
+
+		c.JSON(http.StatusOK, activeMenus)
 	}
 }
 
@@ -104,7 +312,7 @@ func UpdateMenu() gin.HandlerFunc {
 		// get menu from the request body
 		var menu models.Menu
 
-		err := c.BindJSON(menu)
+		err := c.BindJSON(&menu)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while binding the menu JSON from the request body"})
 			return
@@ -114,51 +322,147 @@ func UpdateMenu() gin.HandlerFunc {
 		menuId := c.Param("menu_id")
 		filter := bson.M{"menu_id": menuId}
 
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var current models.Menu
+		err = menuCollection.FindOne(ctx, filter).Decode(&current)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "menu was not found"})
+			return
+		}
+
+		if current.Archived {
+			c.JSON(http.StatusConflict, gin.H{"error": "an archived menu cannot be updated"})
+			return
+		}
+
 		// create the update obj to be used in the menu collection
 		var updateObj primitive.D
 
 		//run check to see if the details are inputted correctly
-		if menu.StartDate != nil && menu.EndDate != nil {
-			if !inTimeSpan(*menu.StartDate, *menu.EndDate, time.Now()) {
-				msg := fmt.Sprintf("Kindly retype the time")
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		if menu.StartDate != nil || menu.EndDate != nil {
+			startDate, endDate := current.StartDate, current.EndDate
+			if menu.StartDate != nil {
+				startDate = menu.StartDate
+			}
+			if menu.EndDate != nil {
+				endDate = menu.EndDate
+			}
+
+			if !validMenuDates(startDate, endDate) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "start_date must be before end_date"})
 				return
 			}
 
-			updateObj = append(updateObj, bson.E{Key: "start_date", Value: menu.StartDate})
-			updateObj = append(updateObj, bson.E{Key: "end_date", Value: menu.EndDate})
+			updateObj = append(updateObj, bson.E{Key: "start_date", Value: startDate})
+			updateObj = append(updateObj, bson.E{Key: "end_date", Value: endDate})
+		}
 
+		if menu.Name != "" || menu.Category != "" {
+			name, category := current.Name, current.Category
 			if menu.Name != "" {
-				updateObj = append(updateObj, bson.E{Key: "name", Value: menu.Name})
+				name = menu.Name
 			}
-
 			if menu.Category != "" {
-				updateObj = append(updateObj, bson.E{Key: "category", Value: menu.Category})
+				category = menu.Category
 			}
 
-			menu.UpdatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			taken, err := menuNameTaken(ctx, name, category, menuId)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error parsing the updated time"})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the menu name"})
 				return
 			}
 
-			updateObj = append(updateObj, bson.E{Key: "updated_at", Value: menu.UpdatedAt})
+			if taken {
+				msg := fmt.Sprintf("a %s menu named %s already exists", category, name)
+				c.JSON(http.StatusConflict, gin.H{"error": msg})
+				return
+			}
+		}
 
-			//update the menu collection
-			upsert := true
-			opt := options.UpdateOptions{Upsert: &upsert}
+		if menu.Name != "" {
+			updateObj = append(updateObj, bson.E{Key: "name", Value: menu.Name})
+		}
+
+		if menu.Category != "" {
+			updateObj = append(updateObj, bson.E{Key: "category", Value: menu.Category})
+		}
+
+		// an empty list of schedules clears them, so the menu runs all day again
+		if menu.Schedules != nil {
+			err = validate.Var(menu.Schedules, "dive")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the menu schedules failed"})
+				return
+			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-			result, err := menuCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &opt)
+			err = validSchedules(menu.Schedules)
 			if err != nil {
-				msg := fmt.Sprintf("menu failed to update")
-				c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
 
-			// a successful response
-			c.JSON(http.StatusOK, result)
+			updateObj = append(updateObj, bson.E{Key: "schedules", Value: menu.Schedules})
+		}
+
+		menu.UpdatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error parsing the updated time"})
+			return
+		}
+
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: menu.UpdatedAt})
+
+		//update the menu collection
+		upsert := true
+		opt := options.UpdateOptions{Upsert: &upsert}
+
+		result, err := menuCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &opt)
+		if err != nil {
+			msg := fmt.Sprintf("menu failed to update")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+			return
+		}
+
+		// a successful response
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func ArchiveMenu() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		menuId := c.Param("menu_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		archivedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := menuCollection.UpdateOne(ctx, bson.M{"menu_id": menuId, "archived": bson.M{"$ne": true}}, bson.D{{"$set", bson.D{
+			{"archived", true},
+			{"archived_at", archivedAt},
+			{"updated_at", archivedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "menu failed to archive"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no live menu was found to archive"})
+			return
 		}
+
+		// the foods on an archived menu are hidden with it
+		foodResult, err := foodCollection.UpdateMany(ctx, bson.M{"menu_id": menuId}, bson.D{{"$set", bson.D{
+			{"archived", true},
+			{"updated_at", archivedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hide the foods on the menu"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"menu_id": menuId, "archived": true, "foods_hidden": foodResult.ModifiedCount})
 	}
 }
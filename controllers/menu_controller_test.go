@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+)
+
+func TestValidSchedules(t *testing.T) {
+	schedule := func(start, end, timezone string) models.MenuSchedule {
+		return models.MenuSchedule{Name: "Lunch", Days: []string{"MON"}, StartTime: start, EndTime: end, Timezone: timezone}
+	}
+
+	tests := []struct {
+		name     string
+		schedule models.MenuSchedule
+		ok       bool
+	}{
+		{"daytime", schedule("11:30", "15:00", "UTC"), true},
+		{"past midnight", schedule("22:00", "02:00", "UTC"), true},
+		{"bad start", schedule("11.30", "15:00", "UTC"), false},
+		{"bad end", schedule("11:30", "25:00", "UTC"), false},
+		{"no length", schedule("11:30", "11:30", "UTC"), false},
+		{"unknown timezone", schedule("11:30", "15:00", "Nowhere/Special"), false},
+	}
+
+	for _, test := range tests {
+		if err := validSchedules([]models.MenuSchedule{test.schedule}); (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
+
+func TestScheduleActiveAt(t *testing.T) {
+	lunch := models.MenuSchedule{Name: "Lunch", Days: []string{"MON", "TUE"}, StartTime: "11:30", EndTime: "15:00", Timezone: "UTC"}
+	late := models.MenuSchedule{Name: "Late", Days: []string{"FRI"}, StartTime: "22:00", EndTime: "02:00", Timezone: "UTC"}
+
+	// 19 October 2026 is a Monday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name     string
+		schedule models.MenuSchedule
+		at       time.Time
+		want     bool
+	}{
+		{"lunch on monday", lunch, at(19, 12, 0), true},
+		{"lunch starts", lunch, at(19, 11, 30), true},
+		{"lunch has ended", lunch, at(19, 15, 0), false},
+		{"before lunch", lunch, at(19, 11, 29), false},
+		{"no lunch on wednesday", lunch, at(21, 12, 0), false},
+		{"late on friday", late, at(23, 23, 0), true},
+		{"late runs into saturday", late, at(24, 1, 59), true},
+		{"late is over on saturday", late, at(24, 2, 0), false},
+		{"no late on saturday night", late, at(24, 23, 0), false},
+	}
+
+	for _, test := range tests {
+		if got := scheduleActiveAt(test.schedule, test.at); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMenuActiveAt(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	before, after := now.Add(-time.Hour), now.Add(time.Hour)
+	lunch := models.MenuSchedule{Name: "Lunch", Days: []string{"MON"}, StartTime: "11:30", EndTime: "15:00", Timezone: "UTC"}
+	dinner := models.MenuSchedule{Name: "Dinner", Days: []string{"MON"}, StartTime: "18:00", EndTime: "22:00", Timezone: "UTC"}
+
+	tests := []struct {
+		name string
+		menu models.Menu
+		want bool
+	}{
+		{"always served", models.Menu{}, true},
+		{"not started", models.Menu{StartDate: &after}, false},
+		{"ended", models.Menu{EndDate: &before}, false},
+		{"within its dates", models.Menu{StartDate: &before, EndDate: &after}, true},
+		{"one daypart running", models.Menu{Schedules: []models.MenuSchedule{dinner, lunch}}, true},
+		{"no daypart running", models.Menu{Schedules: []models.MenuSchedule{dinner}}, false},
+	}
+
+	for _, test := range tests {
+		if got := menuActiveAt(test.menu, now); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
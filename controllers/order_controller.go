@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
 	"github.com/dastardlyjockey/restaurant-management-backend/models"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,16 +17,190 @@ import (
 
 var orderCollection = database.Collection(database.Client, "orders")
 
-func OrderItemOrderCreator(order models.Order) string {
+// AllergenAlertFormat flags an item containing allergens the guests have asked to avoid
+type AllergenAlertFormat struct {
+	OrderItemID string   `json:"order_item_id"`
+	FoodID      string   `json:"food_id"`
+	FoodName    string   `json:"food_name"`
+	Allergens   []string `json:"allergens"`
+}
+
+type OrderViewFormat struct {
+	models.Order
+	AllergenAlerts []AllergenAlertFormat `json:"allergen_alerts"`
+}
+
+type KitchenTicketItemFormat struct {
+	OrderItemID string     `json:"order_item_id"`
+	FoodID      string     `json:"food_id"`
+	FoodName    string     `json:"food_name"`
+	Quantity    string     `json:"quantity"`
+	ServedAt    *time.Time `json:"served_at"`
+	Allergens   []string   `json:"allergens"`
+}
+
+type KitchenTicketFormat struct {
+	OrderID        string                    `json:"order_id"`
+	TableNumber    *int                      `json:"table_number"`
+	TableGroupID   *string                   `json:"table_group_id"`
+	ServerID       *string                   `json:"server_id"`
+	Allergies      []string                  `json:"allergies"`
+	Items          []KitchenTicketItemFormat `json:"items"`
+	AllergenAlerts []AllergenAlertFormat     `json:"allergen_alerts"`
+	PrintedAt      time.Time                 `json:"printed_at"`
+}
+
+// orderItemsWithFoods loads the items on an order along with the foods they are for
+func orderItemsWithFoods(ctx context.Context, orderID string) ([]models.OrderItem, map[string]models.Food, error) {
+	opt := options.Find().SetSort(bson.D{{"created_at", 1}})
+	cursor, err := orderItemsCollection.Find(ctx, bson.M{"order_id": orderID}, opt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var orderItems []models.OrderItem
+	if err = cursor.All(ctx, &orderItems); err != nil {
+		return nil, nil, err
+	}
+
+	var foodIDs []string
+	for _, orderItem := range orderItems {
+		foodIDs = append(foodIDs, *orderItem.FoodID)
+	}
+
+	foods := make(map[string]models.Food)
+	if len(foodIDs) == 0 {
+		return orderItems, foods, nil
+	}
+
+	cursor, err = foodCollection.Find(ctx, bson.M{"food_id": bson.M{"$in": foodIDs}})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var allFoods []models.Food
+	if err = cursor.All(ctx, &allFoods); err != nil {
+		return nil, nil, err
+	}
+
+	for _, food := range allFoods {
+		foods[food.FoodID] = food
+	}
+
+	return orderItems, foods, nil
+}
+
+// flaggedAllergens are the allergens in a food that the guests have flagged
+func flaggedAllergens(food models.Food, allergies []string) []string {
+	flagged := []string{}
+	for _, allergen := range food.Allergens {
+		for _, allergy := range allergies {
+			if allergen == allergy {
+				flagged = append(flagged, allergen)
+			}
+		}
+	}
+
+	return flagged
+}
+
+func allergenAlerts(orderItems []models.OrderItem, foods map[string]models.Food, allergies []string) []AllergenAlertFormat {
+	alerts := []AllergenAlertFormat{}
+	for _, orderItem := range orderItems {
+		food, ok := foods[*orderItem.FoodID]
+		if !ok {
+			continue
+		}
+
+		if flagged := flaggedAllergens(food, allergies); len(flagged) > 0 {
+			alerts = append(alerts, AllergenAlertFormat{
+				OrderItemID: orderItem.OrderItemID,
+				FoodID:      food.FoodID,
+				FoodName:    *food.Name,
+				Allergens:   flagged,
+			})
+		}
+	}
+
+	return alerts
+}
+
+func OrderItemOrderCreator(order models.Order) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status := models.OrderStatusOpen
+	order.Status = &status
+	if order.ServerID == nil && order.TableID != nil {
+		serverID := defaultServer(ctx, *order.TableID, order.CreatedBy)
+		order.ServerID = &serverID
+	}
 	order.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 	order.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	order.ID = primitive.NewObjectID()
+	if order.ID.IsZero() {
+		order.ID = primitive.NewObjectID()
+	}
 	order.OrderID = order.ID.Hex()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	orderCollection.InsertOne(ctx, order)
-	return order.OrderID
+	_, err := orderCollection.InsertOne(ctx, order)
+	if err != nil {
+		return "", err
+	}
+	return order.OrderID, nil
+}
+
+// closeOrder closes an order once it has been settled and flags its table for cleaning
+func closeOrder(ctx context.Context, orderID string) error {
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{"order_id": orderID}).Decode(&order)
+	if err != nil {
+		return err
+	}
+
+	// only the first close frees the tables, a second one must not flag tables
+	// that have been seated again since or split a group that was formed since
+	closedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	result, err := orderCollection.UpdateOne(ctx, bson.M{"order_id": orderID, "status": bson.M{"$ne": models.OrderStatusClosed}}, bson.D{{"$set", bson.D{
+		{"status", models.OrderStatusClosed},
+		{"closed_at", closedAt},
+		{"updated_at", closedAt},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return nil
+	}
+
+	var tableIDs []string
+	if order.TableID != nil {
+		tableIDs = append(tableIDs, *order.TableID)
+	}
+
+	// a group order frees every table in the group, and the group is split up
+	if order.TableGroupID != nil {
+		group, err := findActiveTableGroup(ctx, *order.TableGroupID)
+		if err == nil {
+			tableIDs = group.TableIDs
+		}
+
+		err = dissolveTableGroup(ctx, *order.TableGroupID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(tableIDs) == 0 {
+		return nil
+	}
+
+	_, err = tableCollection.UpdateMany(ctx, bson.M{"table_id": bson.M{"$in": tableIDs}}, bson.D{{"$set", bson.D{
+		{"needs_cleaning", true},
+		{"seated_order_id", nil},
+		{"updated_at", closedAt},
+	}}})
+	return err
 }
 
 func CreateOrder() gin.HandlerFunc {
@@ -39,6 +214,24 @@ func CreateOrder() gin.HandlerFunc {
 			return
 		}
 
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// an order opened against a table group sits on one of the group's tables
+		code, msg := resolveOrderTableGroup(ctx, &order)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		if order.TableGroupID != nil {
+			_, err = openGroupOrder(ctx, *order.TableGroupID)
+			if err == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "the table group already has an open order"})
+				return
+			}
+		}
+
 		// validate the order
 		err = validate.Struct(order)
 		if err != nil {
@@ -48,14 +241,22 @@ func CreateOrder() gin.HandlerFunc {
 
 		//check if the table id is valid
 		var table models.Table
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
 		err = tableCollection.FindOne(ctx, bson.M{"table_id": order.TableID}).Decode(&table)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "The table Id is not available"})
 			return
 		}
 
+		// record who took the order and which server owns it
+		order.CreatedBy = c.GetString("uid")
+		if order.ServerID == nil {
+			serverID := defaultServer(ctx, *order.TableID, order.CreatedBy)
+			order.ServerID = &serverID
+		} else if !staffExists(ctx, *order.ServerID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the server is not a registered user"})
+			return
+		}
+
 		// input the data to the database
 		order.CreatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		order.UpdatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
@@ -64,11 +265,39 @@ func CreateOrder() gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			return
 		}
+		status := models.OrderStatusOpen
+		order.Status = &status
+		if order.OrderType == nil {
+			orderType := models.OrderTypeDineIn
+			order.OrderType = &orderType
+		}
 		order.ID = primitive.NewObjectID()
 		order.OrderID = order.ID.Hex()
 
+		// the table is claimed for the order the same way seating a waitlist party
+		// claims it, so a table never carries two open orders
+		claim, err := tableCollection.UpdateOne(ctx, bson.M{"table_id": table.TableID, "seated_order_id": nil}, bson.D{{"$set", bson.D{
+			{"seated_order_id", order.OrderID},
+			{"updated_at", order.UpdatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the table"})
+			return
+		}
+
+		if claim.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the table already has an open order"})
+			return
+		}
+
 		result, err := orderCollection.InsertOne(ctx, order)
 		if err != nil {
+			_, releaseErr := tableCollection.UpdateOne(ctx, bson.M{"table_id": table.TableID, "seated_order_id": order.OrderID}, bson.D{{"$set", bson.D{
+				{"seated_order_id", nil},
+			}}})
+			if releaseErr != nil {
+				log.Println("Error releasing the table after the order failed:", releaseErr)
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert the order into the database"})
 			return
 		}
@@ -80,22 +309,46 @@ func CreateOrder() gin.HandlerFunc {
 
 func GetOrders() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		result, err := orderCollection.Find(context.TODO(), bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the orders from the database"})
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at", "order_date", "closed_at"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		// servers can list only their own orders with server_id=me
+		if serverId := c.Query("server_id"); serverId != "" {
+			if serverId == "me" {
+				serverId = c.GetString("uid")
+			}
+			filter["server_id"] = serverId
+		}
+
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		if tableId := c.Query("table_id"); tableId != "" {
+			filter["table_id"] = tableId
+		}
+
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
 			return
 		}
 
-		var allResult []bson.M
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		err = result.All(ctx, &allResult)
+
+		orders := []models.Order{}
+		result, err := listQuery.Find(ctx, orderCollection, filter, &orders)
 		if err != nil {
-			log.Fatal("Failed to iterate the orders")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve the orders from the database"})
 			return
 		}
 
-		c.JSON(http.StatusOK, allResult)
+		c.JSON(http.StatusOK, result)
 	}
 }
 
@@ -114,7 +367,76 @@ func GetOrderById() gin.HandlerFunc {
 			return
 		}
 
-		c.JSON(http.StatusOK, order)
+		orderView := OrderViewFormat{Order: order, AllergenAlerts: []AllergenAlertFormat{}}
+		if len(order.Allergies) > 0 {
+			orderItems, foods, err := orderItemsWithFoods(ctx, orderId)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the order items for allergens"})
+				return
+			}
+			orderView.AllergenAlerts = allergenAlerts(orderItems, foods, order.Allergies)
+		}
+
+		c.JSON(http.StatusOK, orderView)
+	}
+}
+
+// GetKitchenTicket lays out an order for the kitchen, with the allergens the
+// guests have flagged called out on the items that contain them
+func GetKitchenTicket() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderId := c.Param("order_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var order models.Order
+		err := orderCollection.FindOne(ctx, bson.M{"order_id": orderId}).Decode(&order)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order was not found"})
+			return
+		}
+
+		orderItems, foods, err := orderItemsWithFoods(ctx, orderId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the order items"})
+			return
+		}
+
+		ticket := KitchenTicketFormat{
+			OrderID:        order.OrderID,
+			TableGroupID:   order.TableGroupID,
+			ServerID:       order.ServerID,
+			Allergies:      order.Allergies,
+			Items:          []KitchenTicketItemFormat{},
+			AllergenAlerts: allergenAlerts(orderItems, foods, order.Allergies),
+			PrintedAt:      time.Now(),
+		}
+
+		var table models.Table
+		err = tableCollection.FindOne(ctx, bson.M{"table_id": order.TableID}).Decode(&table)
+		if err == nil {
+			ticket.TableNumber = table.TableNumber
+		}
+
+		for _, orderItem := range orderItems {
+			item := KitchenTicketItemFormat{
+				OrderItemID: orderItem.OrderItemID,
+				FoodID:      *orderItem.FoodID,
+				Quantity:    *orderItem.Quantity,
+				ServedAt:    orderItem.ServedAt,
+				Allergens:   []string{},
+			}
+
+			if food, ok := foods[*orderItem.FoodID]; ok {
+				item.FoodName = *food.Name
+				item.Allergens = flaggedAllergens(food, order.Allergies)
+			}
+
+			ticket.Items = append(ticket.Items, item)
+		}
+
+		c.JSON(http.StatusOK, ticket)
 	}
 }
 
@@ -150,6 +472,41 @@ func UpdateOrder() gin.HandlerFunc {
 			return
 		}
 
+		// validate the status before it is applied
+		if order.Status != nil {
+			err = validate.Var(*order.Status, "eq=OPEN|eq=CLOSED")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "status must be OPEN or CLOSED"})
+				return
+			}
+
+			// a reopened order is no longer closed
+			if *order.Status == models.OrderStatusOpen {
+				updateObj = append(updateObj, bson.E{Key: "status", Value: *order.Status})
+				updateObj = append(updateObj, bson.E{Key: "closed_at", Value: nil})
+			}
+		}
+
+		if order.Allergies != nil {
+			err = validate.StructPartial(order, "Allergies")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "allergies must be from the 14 major allergens"})
+				return
+			}
+
+			updateObj = append(updateObj, bson.E{Key: "allergies", Value: order.Allergies})
+		}
+
+		if order.OrderType != nil {
+			err = validate.StructPartial(order, "OrderType")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "order_type must be DINE_IN, TAKEAWAY or DELIVERY"})
+				return
+			}
+
+			updateObj = append(updateObj, bson.E{Key: "order_type", Value: *order.OrderType})
+		}
+
 		// update the time
 		order.UpdatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		if err != nil {
@@ -168,7 +525,86 @@ func UpdateOrder() gin.HandlerFunc {
 			return
 		}
 
+		// a new order type can change which service charge applies
+		err = recalculateOpenInvoices(ctx, bson.M{"order_id": orderID})
+		if err != nil {
+			log.Println("Error recalculating the order's invoice: ", err)
+		}
+
+		// closing an order frees the table for cleaning
+		if order.Status != nil && *order.Status == models.OrderStatusClosed {
+			err = closeOrder(ctx, orderID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed closing the order"})
+				return
+			}
+		}
+
 		// response
 		c.JSON(http.StatusOK, updateResult)
 	}
 }
+
+func TransferOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			ServerID *string `json:"server_id" validate:"required"`
+		}
+
+		err := c.BindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer JSON"})
+			return
+		}
+
+		err = validate.Struct(request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "server_id is required"})
+			return
+		}
+
+		orderId := c.Param("order_id")
+		filter := bson.M{"order_id": orderId}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var order models.Order
+		err = orderCollection.FindOne(ctx, filter).Decode(&order)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order was not found"})
+			return
+		}
+
+		if order.Status != nil && *order.Status == models.OrderStatusClosed {
+			c.JSON(http.StatusConflict, gin.H{"error": "a closed order cannot be transferred"})
+			return
+		}
+
+		if !staffExists(ctx, *request.ServerID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the server is not a registered user"})
+			return
+		}
+
+		// keep a record of every hand-over
+		transfer := models.OrderTransfer{
+			ToServerID:    *request.ServerID,
+			TransferredBy: c.GetString("uid"),
+		}
+		if order.ServerID != nil {
+			transfer.FromServerID = *order.ServerID
+		}
+		transfer.TransferredAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		result, err := orderCollection.UpdateOne(ctx, filter, bson.D{
+			{"$set", bson.D{{"server_id", *request.ServerID}, {"updated_at", transfer.TransferredAt}}},
+			{"$push", bson.D{{"transfers", transfer}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to transfer the order"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
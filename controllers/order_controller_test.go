@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOrderIsClosedOnce(t *testing.T) {
+	ctx := testDatabase(t)
+
+	table := newTable()
+	order := newOrder(&table.TableID)
+	table.SeatedOrderID = &order.OrderID
+	fixture(t, ctx, tableCollection, table)
+	fixture(t, ctx, orderCollection, order)
+
+	if err := closeOrder(ctx, order.OrderID); err != nil {
+		t.Fatal(err)
+	}
+
+	var freed models.Table
+	if err := tableCollection.FindOne(ctx, bson.M{"table_id": table.TableID}).Decode(&freed); err != nil {
+		t.Fatal(err)
+	}
+	if freed.SeatedOrderID != nil || freed.NeedsCleaning == nil || !*freed.NeedsCleaning {
+		t.Fatalf("closing the order left the table seated %v and needing cleaning %v", freed.SeatedOrderID, freed.NeedsCleaning)
+	}
+
+	// the table is cleaned and the next party sits down
+	next := primitive.NewObjectID().Hex()
+	_, err := tableCollection.UpdateOne(ctx, bson.M{"table_id": table.TableID}, bson.D{{"$set", bson.D{
+		{"needs_cleaning", false},
+		{"seated_order_id", next},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := closeOrder(ctx, order.OrderID); err != nil {
+		t.Fatal(err)
+	}
+
+	var seated models.Table
+	if err := tableCollection.FindOne(ctx, bson.M{"table_id": table.TableID}).Decode(&seated); err != nil {
+		t.Fatal(err)
+	}
+	if seated.SeatedOrderID == nil || *seated.SeatedOrderID != next || *seated.NeedsCleaning {
+		t.Errorf("closing the order again took the table from the next party")
+	}
+}
+
+func TestReopenedOrderIsNotClosed(t *testing.T) {
+	ctx := testDatabase(t)
+
+	table := newTable()
+	order := newOrder(&table.TableID)
+	fixture(t, ctx, tableCollection, table)
+	fixture(t, ctx, orderCollection, order)
+
+	if err := closeOrder(ctx, order.OrderID); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := withParam(UpdateOrder(), "order_id", order.OrderID)
+	recorder := serve(handler, http.MethodPatch, "/orders/"+order.OrderID, gin.H{"table_id": table.TableID, "status": models.OrderStatusOpen}, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("reopening the order: got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var reopened models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": order.OrderID}).Decode(&reopened); err != nil {
+		t.Fatal(err)
+	}
+	if *reopened.Status != models.OrderStatusOpen || reopened.ClosedAt != nil {
+		t.Errorf("the reopened order is %s and closed at %v", *reopened.Status, reopened.ClosedAt)
+	}
+}
@@ -2,13 +2,16 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
 	"github.com/dastardlyjockey/restaurant-management-backend/models"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
 	"net/http"
 	"time"
 )
@@ -16,8 +19,10 @@ import (
 var orderItemsCollection = database.Collection(database.Client, "orderItems")
 
 type orderItemPack struct {
-	TableID    *string
-	OrderItems []models.OrderItem
+	TableID      *string
+	TableGroupID *string
+	Allergies    []string
+	OrderItems   []models.OrderItem
 }
 
 func ItemsByOrder(id string) (orderItems []primitive.M, err error) {
@@ -83,6 +88,39 @@ func ItemsByOrder(id string) (orderItems []primitive.M, err error) {
 	return orderItems, nil
 }
 
+// checkFoodOrderable makes sure the food exists and belongs to a menu that is being served at the order time
+func checkFoodOrderable(ctx context.Context, foodID string, at time.Time) (int, string) {
+	var food models.Food
+	err := foodCollection.FindOne(ctx, bson.M{"food_id": foodID}).Decode(&food)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Sprintf("food %s was not found", foodID)
+	}
+
+	var menu models.Menu
+	err = menuCollection.FindOne(ctx, bson.M{"menu_id": food.MenuID}).Decode(&menu)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Sprintf("the menu for %s was not found", *food.Name)
+	}
+
+	if food.Archived || menu.Archived {
+		return http.StatusBadRequest, fmt.Sprintf("%s is no longer on the menu", *food.Name)
+	}
+
+	if !foodAvailable(food) {
+		return http.StatusConflict, fmt.Sprintf("%s is unavailable", *food.Name)
+	}
+
+	if food.RemainingPortions != nil && *food.RemainingPortions == 0 {
+		return http.StatusConflict, fmt.Sprintf("%s is sold out", *food.Name)
+	}
+
+	if !menuActiveAt(menu, at) {
+		return http.StatusBadRequest, fmt.Sprintf("%s is on the %s menu, which is not being served right now", *food.Name, menu.Name)
+	}
+
+	return http.StatusOK, ""
+}
+
 func CreateOrderItem() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// get the request from the client
@@ -94,20 +132,95 @@ func CreateOrderItem() gin.HandlerFunc {
 			return
 		}
 
+		if len(orderItemPack.OrderItems) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "no order items were sent"})
+			return
+		}
+
+		if !validLabels(orderItemPack.Allergies, models.Allergens) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "allergies must be from the 14 major allergens"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// check every item before an order is opened for them
+		orderedAt := time.Now()
+		portions := make(map[string]int)
+		for _, orderItem := range orderItemPack.OrderItems {
+			validateErr := validate.StructExcept(orderItem, "OrderID")
+			if validateErr != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid order item"})
+				return
+			}
+
+			code, msg := checkFoodOrderable(ctx, *orderItem.FoodID, orderedAt)
+			if msg != "" {
+				c.JSON(code, gin.H{"message": msg})
+				return
+			}
+
+			portions[*orderItem.FoodID]++
+		}
+
 		// add the order item to the database
 		orderItemToBeInserted := []interface{}{}
 		order.TableID = orderItemPack.TableID
-		orderID := OrderItemOrderCreator(order)
+		order.TableGroupID = orderItemPack.TableGroupID
+		order.Allergies = orderItemPack.Allergies
+		order.CreatedBy = c.GetString("uid")
 
-		for _, orderItem := range orderItemPack.OrderItems {
-			orderItem.OrderID = orderID
+		code, msg := resolveOrderTableGroup(ctx, &order)
+		if msg != "" {
+			c.JSON(code, gin.H{"message": msg})
+			return
+		}
 
-			validateErr := validate.Struct(orderItem)
-			if validateErr != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid order item"})
+		// take the portions off the counted foods before the order goes through
+		reserved, msg, err := reserveFoodPortions(ctx, portions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to check the food stock"})
+			return
+		}
+
+		if msg != "" {
+			c.JSON(http.StatusConflict, gin.H{"message": msg})
+			return
+		}
+
+		// items for a table group go on the group's single open order
+		var orderID string
+		if order.TableGroupID != nil {
+			groupOrder, err := openGroupOrder(ctx, *order.TableGroupID)
+			if err == nil {
+				orderID = groupOrder.OrderID
+			}
+
+			// allergies flagged with later rounds are added to the group's order
+			if orderID != "" && len(order.Allergies) > 0 {
+				_, err = orderCollection.UpdateOne(ctx, bson.M{"order_id": orderID}, bson.D{
+					{"$addToSet", bson.D{{"allergies", bson.D{{"$each", order.Allergies}}}}},
+				})
+				if err != nil {
+					releaseFoodPortions(ctx, reserved)
+					c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to record the allergies on the order"})
+					return
+				}
+			}
+		}
+
+		if orderID == "" {
+			orderID, err = OrderItemOrderCreator(order)
+			if err != nil {
+				releaseFoodPortions(ctx, reserved)
+				c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to create the order"})
 				return
 			}
+		}
 
+		for _, orderItem := range orderItemPack.OrderItems {
+			orderItem.OrderID = orderID
 			orderItem.ID = primitive.NewObjectID()
 			orderItem.OrderItemID = orderItem.ID.Hex()
 			num := toFixed(*orderItem.UnitPrice, 2)
@@ -115,6 +228,7 @@ func CreateOrderItem() gin.HandlerFunc {
 			orderItem.CreatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 			orderItem.CreatedAt, err = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 			if err != nil {
+				releaseFoodPortions(ctx, reserved)
 				c.JSON(http.StatusInternalServerError, gin.H{"message": "failed to create time"})
 				return
 			}
@@ -122,14 +236,19 @@ func CreateOrderItem() gin.HandlerFunc {
 			orderItemToBeInserted = append(orderItemToBeInserted, orderItem)
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
 		result, err := orderItemsCollection.InsertMany(ctx, orderItemToBeInserted)
 		if err != nil {
+			releaseFoodPortions(ctx, reserved)
 			c.JSON(http.StatusBadRequest, gin.H{"message": "failed to insert into database"})
 			return
 		}
 
+		// a later round on an order that has been billed goes onto the open bill
+		err = recalculateOpenInvoices(ctx, bson.M{"order_id": orderID})
+		if err != nil {
+			log.Println("Error recalculating the order's invoice: ", err)
+		}
+
 		//response
 		c.JSON(http.StatusOK, result)
 
@@ -138,23 +257,49 @@ func CreateOrderItem() gin.HandlerFunc {
 
 func GetOrderItems() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
 
-		cursor, err := orderItemsCollection.Find(context.TODO(), bson.M{})
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing the order items"})
+		filter := bson.M{}
+
+		if orderId := c.Query("order_id"); orderId != "" {
+			filter["order_id"] = orderId
+		}
+
+		if foodId := c.Query("food_id"); foodId != "" {
+			filter["food_id"] = foodId
+		}
+
+		switch c.Query("served") {
+		case "":
+		case "true":
+			filter["served_at"] = bson.M{"$ne": nil}
+		case "false":
+			filter["served_at"] = nil
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "served must be true or false"})
 			return
 		}
 
-		var allOrder []bson.D
-		err = cursor.All(ctx, &allOrder)
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		orderItems := []models.OrderItem{}
+		result, err := listQuery.Find(ctx, orderItemsCollection, filter, &orderItems)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error occurred while listing the order items"})
 			return
 		}
 
-		c.JSON(http.StatusOK, allOrder)
+		c.JSON(http.StatusOK, result)
 	}
 }
 
@@ -197,9 +342,22 @@ func UpdateOrderItem() gin.HandlerFunc {
 		orderItemId := c.Param("orderItem_id")
 		filter := bson.M{"order_item_id": orderItemId}
 
+		err := c.BindJSON(&orderItem)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while binding the order item JSON from the request body"})
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
+		var current models.OrderItem
+		err = orderItemsCollection.FindOne(ctx, filter).Decode(&current)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "order item was not found"})
+			return
+		}
+
 		var updateObj primitive.D
 
 		if orderItem.UnitPrice != nil {
@@ -207,6 +365,12 @@ func UpdateOrderItem() gin.HandlerFunc {
 		}
 
 		if orderItem.Quantity != nil {
+			err = validate.Var(*orderItem.Quantity, "eq=S|eq=M|eq=L")
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "quantity must be S, M or L"})
+				return
+			}
+
 			updateObj = append(updateObj, bson.E{"quantity", *orderItem.Quantity})
 		}
 
@@ -214,19 +378,111 @@ func UpdateOrderItem() gin.HandlerFunc {
 			updateObj = append(updateObj, bson.E{"food_id", *orderItem.FoodID})
 		}
 
+		// a changed item is checked the way a new one is, and a new food takes its
+		// portion while the old food gets its portion back
+		foodChanged := orderItem.FoodID != nil && (current.FoodID == nil || *orderItem.FoodID != *current.FoodID)
+		sizeChanged := orderItem.Quantity != nil && (current.Quantity == nil || *orderItem.Quantity != *current.Quantity)
+
+		foodID := current.FoodID
+		if foodChanged {
+			foodID = orderItem.FoodID
+		}
+
+		var reserved map[string]int
+		if (foodChanged || sizeChanged) && foodID != nil {
+			code, msg := checkFoodOrderable(ctx, *foodID, time.Now())
+			if msg != "" {
+				c.JSON(code, gin.H{"error": msg})
+				return
+			}
+		}
+
+		if foodChanged {
+			var msg string
+			reserved, msg, err = reserveFoodPortions(ctx, map[string]int{*orderItem.FoodID: 1})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check the food stock"})
+				return
+			}
+
+			if msg != "" {
+				c.JSON(http.StatusConflict, gin.H{"error": msg})
+				return
+			}
+		}
+
 		orderItem.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		updateObj = append(updateObj, bson.E{"updated_at", orderItem.UpdatedAt})
 
 		//update the order item in the database
-		upsert := true
-		opt := options.UpdateOptions{Upsert: &upsert}
-
-		result, err := orderItemsCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &opt)
+		result, err := orderItemsCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}})
 		if err != nil {
+			releaseFoodPortions(ctx, reserved)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the order item"})
 			return
 		}
 
+		if foodChanged && current.FoodID != nil {
+			releaseFoodPortions(ctx, map[string]int{*current.FoodID: 1})
+		}
+
+		// a price change on a billed order goes onto the open bill
+		var updated models.OrderItem
+		err = orderItemsCollection.FindOne(ctx, filter).Decode(&updated)
+		if err == nil && updated.OrderID != "" {
+			err = recalculateOpenInvoices(ctx, bson.M{"order_id": updated.OrderID})
+		}
+		if err != nil {
+			log.Println("Error recalculating the order's invoice: ", err)
+		}
+
 		c.JSON(http.StatusOK, result)
 	}
 }
+
+// ServeOrderItem marks an order item as served and depletes the ingredient stock for its recipe
+func ServeOrderItem() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderItemId := c.Param("orderItem_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// only the first serve goes through so the stock is never depleted twice
+		servedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		var orderItem models.OrderItem
+		opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		err := orderItemsCollection.FindOneAndUpdate(ctx,
+			bson.M{"order_item_id": orderItemId, "served_at": nil},
+			bson.D{{"$set", bson.D{{"served_at", servedAt}, {"updated_at", servedAt}}}},
+			opt,
+		).Decode(&orderItem)
+		if err == mongo.ErrNoDocuments {
+			count, _ := orderItemsCollection.CountDocuments(ctx, bson.M{"order_item_id": orderItemId})
+			if count == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "order item was not found"})
+				return
+			}
+
+			c.JSON(http.StatusConflict, gin.H{"error": "the order item has already been served"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serve the order item"})
+			return
+		}
+
+		lowStock, err := depleteStock(ctx, orderItem, c.GetString("uid"))
+		if err != nil {
+			// nothing was deducted, so the item goes back to unserved and can be served again
+			_, resetErr := orderItemsCollection.UpdateOne(ctx, bson.M{"order_item_id": orderItemId}, bson.D{{"$set", bson.D{{"served_at", nil}}}})
+			if resetErr != nil {
+				log.Println("Error resetting an order item after the stock failed to update:", resetErr)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "the stock failed to update, please serve the item again"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"order_item": orderItem, "low_stock": lowStock})
+	}
+}
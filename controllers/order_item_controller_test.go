@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestChangingTheFoodMovesItsPortion(t *testing.T) {
+	ctx := testDatabase(t)
+
+	menu := newMenu()
+	soup, stew, pie := newFood(menu.MenuID, "Test soup", 5), newFood(menu.MenuID, "Test stew", 8), newFood(menu.MenuID, "Test pie", 6)
+	soupLeft, stewLeft, pieLeft := 4, 3, 0
+	soup.RemainingPortions, stew.RemainingPortions, pie.RemainingPortions = &soupLeft, &stewLeft, &pieLeft
+	item := newOrderItem(newOrder(nil).OrderID, soup.FoodID, *soup.Price)
+	fixture(t, ctx, menuCollection, menu)
+	fixture(t, ctx, foodCollection, soup, stew, pie)
+	fixture(t, ctx, orderItemsCollection, item)
+
+	update := func(body gin.H) int {
+		handler := withParam(UpdateOrderItem(), "orderItem_id", item.OrderItemID)
+		return serve(handler, http.MethodPatch, "/orderItems/"+item.OrderItemID, body, "").Code
+	}
+
+	remaining := func(food models.Food) int {
+		var stored models.Food
+		if err := foodCollection.FindOne(ctx, bson.M{"food_id": food.FoodID}).Decode(&stored); err != nil {
+			t.Fatal(err)
+		}
+		return *stored.RemainingPortions
+	}
+
+	if code := update(gin.H{"food_id": pie.FoodID}); code != http.StatusConflict {
+		t.Errorf("changing the item to a sold out food: got %d, want %d", code, http.StatusConflict)
+	}
+
+	if code := update(gin.H{"food_id": stew.FoodID}); code != http.StatusOK {
+		t.Fatalf("changing the item to another food: got %d", code)
+	}
+
+	if got := remaining(soup); got != 5 {
+		t.Errorf("the old food has %d portions left, want its portion back for 5", got)
+	}
+	if got := remaining(stew); got != 2 {
+		t.Errorf("the new food has %d portions left, want 2", got)
+	}
+
+	if code := update(gin.H{"quantity": "XL"}); code != http.StatusBadRequest {
+		t.Errorf("changing the item to an unknown size: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestUpdatingAMissingOrderItem(t *testing.T) {
+	testDatabase(t)
+
+	handler := withParam(UpdateOrderItem(), "orderItem_id", "missing")
+	recorder := serve(handler, http.MethodPatch, "/orderItems/missing", gin.H{"quantity": "S"}, "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("updating an order item that does not exist: got %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
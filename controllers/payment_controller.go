@@ -0,0 +1,422 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/gateway"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"math"
+	"net/http"
+	"time"
+)
+
+var paymentCollection = database.Collection(database.Client, "payments")
+
+// paymentProviderTimeout leaves time to record the outcome before the request times out
+const paymentProviderTimeout = 5 * time.Second
+
+// settledPayments matches the payments that have actually been taken; payments
+// recorded before statuses were introduced have none and count as taken
+var settledPayments = bson.M{"$nin": bson.A{models.PaymentStatusPending, models.PaymentStatusDeclined, models.PaymentStatusFailed}}
+
+// paymentTotals is what has been taken on an invoice. Gross is every amount taken,
+// before anything was given back; Paid and Tips are what is kept after refunds, and
+// Refunded is what was given back off amounts and tips together.
+type paymentTotals struct {
+	Gross    float64
+	Paid     float64
+	Tips     float64
+	Refunded float64
+}
+
+// invoicePayments adds up the payments taken on an invoice. Refunds come off a
+// payment's amount first and its tip last.
+func invoicePayments(ctx context.Context, invoiceID string) (paymentTotals, error) {
+	refunded := bson.D{{"$ifNull", bson.A{"$refunded", 0}}}
+	matchStage := bson.D{{"$match", bson.D{{"invoice_id", invoiceID}, {"status", settledPayments}}}}
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", nil},
+		{"gross", bson.D{{"$sum", "$amount"}}},
+		{"paid", bson.D{{"$sum", bson.D{{"$max", bson.A{bson.D{{"$subtract", bson.A{"$amount", refunded}}}, 0}}}}}},
+		{"tips", bson.D{{"$sum", bson.D{{"$subtract", bson.A{
+			"$tip",
+			bson.D{{"$max", bson.A{bson.D{{"$subtract", bson.A{refunded, "$amount"}}}, 0}}},
+		}}}}}},
+		{"refunded", bson.D{{"$sum", refunded}}},
+	}}}
+
+	var totals paymentTotals
+	cursor, err := paymentCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return totals, err
+	}
+
+	var result []struct {
+		Gross    float64 `bson:"gross"`
+		Paid     float64 `bson:"paid"`
+		Tips     float64 `bson:"tips"`
+		Refunded float64 `bson:"refunded"`
+	}
+	if err = cursor.All(ctx, &result); err != nil {
+		return totals, err
+	}
+
+	if len(result) == 0 {
+		return totals, nil
+	}
+
+	totals.Gross = toFixed(result[0].Gross, 2)
+	totals.Paid = toFixed(result[0].Paid, 2)
+	totals.Tips = toFixed(result[0].Tips, 2)
+	totals.Refunded = toFixed(result[0].Refunded, 2)
+	return totals, nil
+}
+
+// paymentRefunds splits what has been refunded off a payment into the part that
+// came off the amount and the part that came off the tip
+func paymentRefunds(payment models.Payment) (float64, float64) {
+	fromAmount := math.Min(payment.Refunded, *payment.Amount)
+	return toFixed(fromAmount, 2), toFixed(payment.Refunded-fromAmount, 2)
+}
+
+func paymentsForInvoice(ctx context.Context, invoiceID string) ([]models.Payment, error) {
+	opt := options.Find().SetSort(bson.D{{"_id", 1}})
+	cursor, err := paymentCollection.Find(ctx, bson.M{"invoice_id": invoiceID}, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []models.Payment{}
+	if err = cursor.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+
+	return payments, nil
+}
+
+// chargeCard authorizes the amount and tip on the card and captures it straight
+// away, recording the provider's transaction reference on the payment. An
+// authorization that cannot be captured is voided so the hold is released.
+func chargeCard(ctx context.Context, payment *models.Payment, cardToken string) (int, string) {
+	provider := gateway.Default
+	name := provider.Name()
+	payment.Provider = &name
+
+	ctx, cancel := context.WithTimeout(ctx, paymentProviderTimeout)
+	defer cancel()
+
+	charge := toFixed(*payment.Amount+*payment.Tip, 2)
+
+	request := gateway.AuthorizeRequest{
+		Amount:    charge,
+		CardToken: cardToken,
+		Reference: payment.PaymentID,
+	}
+	if payment.IdempotencyKey != nil {
+		request.IdempotencyKey = payment.InvoiceID + ":" + *payment.IdempotencyKey
+	}
+
+	transaction, err := provider.Authorize(ctx, request)
+	if err == nil {
+		payment.ProviderTransactionID = &transaction.ID
+		transaction, err = provider.Capture(ctx, transaction.ID, charge)
+		if err != nil {
+			_, _ = provider.Void(context.Background(), *payment.ProviderTransactionID)
+		}
+	}
+
+	var decline *gateway.DeclineError
+	switch {
+	case err == nil:
+		payment.Status = models.PaymentStatusCaptured
+		return http.StatusCreated, ""
+	case errors.As(err, &decline):
+		payment.Status = models.PaymentStatusDeclined
+		payment.FailureReason = &decline.Reason
+		return http.StatusPaymentRequired, "the card was declined: " + decline.Reason
+	case errors.Is(err, gateway.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		reason := "the payment provider timed out"
+		payment.Status = models.PaymentStatusFailed
+		payment.FailureReason = &reason
+		return http.StatusGatewayTimeout, reason + ", retry with the same idempotency key"
+	default:
+		reason := err.Error()
+		payment.Status = models.PaymentStatusFailed
+		payment.FailureReason = &reason
+		return http.StatusBadGateway, "the payment provider could not take the payment"
+	}
+}
+
+// CreatePayment records one tender against an invoice. An invoice can be settled
+// with several payments, e.g. part cash and part card; a payment cannot take more
+// than the balance still due, and cash over the amount and tip is given back as change.
+// Card payments are charged through the payment provider with the card_token given.
+// Sending the same Idempotency-Key again returns the original payment instead of
+// taking it twice; a declined or failed attempt can be retried with the same key.
+func CreatePayment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			models.Payment
+			CardToken *string `json:"card_token"`
+		}
+
+		err := c.BindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment JSON"})
+			return
+		}
+		payment := request.Payment
+
+		if key := c.GetHeader("Idempotency-Key"); key != "" {
+			payment.IdempotencyKey = &key
+		}
+
+		err = validate.Struct(payment)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a payment needs a CASH or CARD method and an amount"})
+			return
+		}
+
+		if *payment.Method == models.PaymentMethodCard && (request.CardToken == nil || *request.CardToken == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a card payment needs a card_token"})
+			return
+		}
+
+		invoiceId := c.Param("invoice_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if payment.IdempotencyKey != nil {
+			var existing models.Payment
+			err = paymentCollection.FindOne(ctx, bson.M{
+				"invoice_id":      invoiceId,
+				"idempotency_key": *payment.IdempotencyKey,
+				"status":          bson.M{"$nin": bson.A{models.PaymentStatusDeclined, models.PaymentStatusFailed}},
+			}).Decode(&existing)
+			if err == nil {
+				if existing.Status == models.PaymentStatusPending {
+					c.JSON(http.StatusConflict, gin.H{"error": "a payment with this idempotency key is still being processed"})
+					return
+				}
+
+				invoice, err := recalculateInvoice(ctx, invoiceId)
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{"payment": existing, "invoice": invoice})
+				return
+			}
+		}
+
+		invoice, err := recalculateInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice was not found"})
+			return
+		}
+
+		// money given back on a paid invoice is not a balance to take again
+		if *invoice.PaymentStatus == models.InvoiceStatusPaid || invoice.PaidAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "the invoice has already been paid"})
+			return
+		}
+
+		if *invoice.PaymentStatus == models.InvoiceStatusVoid {
+			c.JSON(http.StatusConflict, gin.H{"error": "the invoice has been voided"})
+			return
+		}
+
+		if invoice.LockedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": invoiceLockedMsg})
+			return
+		}
+
+		amount := toFixed(*payment.Amount, 2)
+		payment.Amount = &amount
+		if amount > invoice.BalanceDue {
+			msg := fmt.Sprintf("the payment of %.2f is more than the balance due of %.2f", amount, invoice.BalanceDue)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		tip := 0.0
+		if payment.Tip != nil {
+			tip = toFixed(*payment.Tip, 2)
+		}
+		payment.Tip = &tip
+
+		payment.Change = 0
+		payment.Refunded = 0
+		payment.Provider = nil
+		payment.ProviderTransactionID = nil
+		payment.FailureReason = nil
+		if *payment.Method == models.PaymentMethodCash {
+			if payment.Tendered == nil {
+				tendered := amount + tip
+				payment.Tendered = &tendered
+			}
+
+			tendered := toFixed(*payment.Tendered, 2)
+			payment.Tendered = &tendered
+			if tendered < amount+tip {
+				msg := fmt.Sprintf("%.2f tendered does not cover the payment and tip of %.2f", tendered, amount+tip)
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			payment.Change = toFixed(tendered-amount-tip, 2)
+			payment.Status = models.PaymentStatusCaptured
+		} else if payment.Tendered != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tendered only applies to cash payments"})
+			return
+		} else {
+			// the card is only charged once the payment holds its place against the balance
+			payment.Status = models.PaymentStatusPending
+		}
+
+		payment.InvoiceID = invoice.InvoiceID
+		payment.ReceivedBy = c.GetString("uid")
+		payment.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		payment.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		payment.ID = primitive.NewObjectID()
+		payment.PaymentID = payment.ID.Hex()
+
+		_, err = paymentCollection.InsertOne(ctx, payment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Payment was not created in the database"})
+			return
+		}
+
+		// another payment may have been taken at the same time; payments are applied
+		// in the order they were created and the one that overpays, or repeats an
+		// idempotency key, backs out
+		payments, err := paymentsForInvoice(ctx, invoice.InvoiceID)
+		if err != nil {
+			// without the other payments the card cannot safely be charged
+			_, deleteErr := paymentCollection.DeleteOne(ctx, bson.M{"payment_id": payment.PaymentID})
+			if deleteErr != nil {
+				log.Println("Error backing out a payment that could not be checked: ", deleteErr)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the other payments on the invoice"})
+			return
+		}
+
+		paid := 0.0
+		duplicate := false
+		for _, existing := range payments {
+			if existing.Status == models.PaymentStatusDeclined || existing.Status == models.PaymentStatusFailed {
+				continue
+			}
+
+			fromAmount, _ := paymentRefunds(existing)
+			paid = toFixed(paid+*existing.Amount-fromAmount, 2)
+			if existing.PaymentID == payment.PaymentID {
+				break
+			}
+
+			if payment.IdempotencyKey != nil && existing.IdempotencyKey != nil && *existing.IdempotencyKey == *payment.IdempotencyKey {
+				duplicate = true
+			}
+		}
+
+		// a payment that lost the race is never charged, even if it cannot be backed out
+		if duplicate || paid > invoice.Total {
+			_, err = paymentCollection.DeleteOne(ctx, bson.M{"payment_id": payment.PaymentID})
+			if err != nil {
+				log.Println("Error backing out a conflicting payment: ", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to back out a payment that conflicted with another, check the invoice's payments"})
+				return
+			}
+
+			if duplicate {
+				c.JSON(http.StatusConflict, gin.H{"error": "a payment with this idempotency key is already being processed"})
+				return
+			}
+
+			c.JSON(http.StatusConflict, gin.H{"error": "another payment was taken on the invoice at the same time, check the balance and try again"})
+			return
+		}
+
+		if *payment.Method == models.PaymentMethodCard {
+			code, msg := chargeCard(ctx, &payment, *request.CardToken)
+
+			payment.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+			_, err = paymentCollection.UpdateOne(ctx, bson.M{"payment_id": payment.PaymentID}, bson.D{{"$set", bson.D{
+				{"status", payment.Status},
+				{"provider", payment.Provider},
+				{"provider_transaction_id", payment.ProviderTransactionID},
+				{"failure_reason", payment.FailureReason},
+				{"updated_at", payment.UpdatedAt},
+			}}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the payment provider's response"})
+				return
+			}
+
+			if msg != "" {
+				c.JSON(code, gin.H{"error": msg, "payment": payment})
+				return
+			}
+		}
+
+		recordAudit(ctx, models.AuditEntry{
+			Action:    models.AuditPaymentTaken,
+			InvoiceID: payment.InvoiceID,
+			PaymentID: &payment.PaymentID,
+			Amount:    toFixed(*payment.Amount+*payment.Tip, 2),
+			UserID:    payment.ReceivedBy,
+		})
+
+		invoice, err = recalculateInvoice(ctx, invoice.InvoiceID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"payment": payment, "invoice": invoice})
+	}
+}
+
+func GetInvoicePayments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invoiceId := c.Param("invoice_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		payments, err := paymentsForInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the payments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, payments)
+	}
+}
+
+func GetPaymentById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentId := c.Param("payment_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var payment models.Payment
+		err := paymentCollection.FindOne(ctx, bson.M{"payment_id": paymentId}).Decode(&payment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the payment in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, payment)
+	}
+}
@@ -0,0 +1,930 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+var promotionCollection = database.Collection(database.Client, "promotions")
+
+// invoiceLine is an item on the bill and what is left to pay for it after discounts
+type invoiceLine struct {
+	OrderItem    models.OrderItem
+	Food         models.Food
+	MenuCategory string
+	Price        float64
+}
+
+// checkPromotion fills in the defaults of a promotion and checks that its rules make sense
+func checkPromotion(ctx context.Context, promotion *models.Promotion, excludePromotionID string) (int, string) {
+	if promotion.Scope == nil {
+		scope := models.PromotionScopeItem
+		promotion.Scope = &scope
+	}
+
+	switch *promotion.Type {
+	case models.PromotionPercentage:
+		if promotion.Value == nil || *promotion.Value > 100 {
+			return http.StatusBadRequest, "a percentage promotion needs a value between 0 and 100"
+		}
+	case models.PromotionFixed:
+		if promotion.Value == nil {
+			return http.StatusBadRequest, "a fixed promotion needs a value"
+		}
+	case models.PromotionBuyXGetY:
+		if promotion.BuyQuantity == nil || promotion.GetQuantity == nil {
+			return http.StatusBadRequest, "a buy x get y promotion needs a buy_quantity and a get_quantity"
+		}
+		if *promotion.Scope != models.PromotionScopeItem {
+			return http.StatusBadRequest, "a buy x get y promotion can only apply to items"
+		}
+	}
+
+	if !validMenuDates(promotion.StartDate, promotion.EndDate) {
+		return http.StatusBadRequest, "the start_date must be before the end_date"
+	}
+
+	if err := validSchedules(promotion.Schedules); err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+
+	if len(promotion.FoodIDs) > 0 {
+		count, err := foodCollection.CountDocuments(ctx, bson.M{"food_id": bson.M{"$in": promotion.FoodIDs}})
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to check the foods"
+		}
+		if int(count) != len(promotion.FoodIDs) {
+			return http.StatusBadRequest, "one or more of the foods do not exist"
+		}
+	}
+
+	if promotion.CouponCode != nil {
+		code := strings.ToUpper(strings.TrimSpace(*promotion.CouponCode))
+		promotion.CouponCode = &code
+
+		filter := bson.M{"coupon_code": code}
+		if excludePromotionID != "" {
+			filter["promotion_id"] = bson.M{"$ne": excludePromotionID}
+		}
+
+		count, err := promotionCollection.CountDocuments(ctx, filter)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to check the coupon codes"
+		}
+		if count > 0 {
+			return http.StatusConflict, "the coupon code is already in use"
+		}
+	}
+
+	return http.StatusOK, ""
+}
+
+// promotionActiveAt reports whether a promotion is running at the given time. The
+// date window of a coupon is checked when the coupon is added to an invoice, so a
+// coupon that has been added is honoured even if it expires before the bill is paid.
+func promotionActiveAt(promotion models.Promotion, at time.Time) bool {
+	if promotion.Active != nil && !*promotion.Active {
+		return false
+	}
+
+	if promotion.CouponCode == nil {
+		if promotion.StartDate != nil && at.Before(*promotion.StartDate) {
+			return false
+		}
+		if promotion.EndDate != nil && !at.Before(*promotion.EndDate) {
+			return false
+		}
+	}
+
+	if len(promotion.Schedules) == 0 {
+		return true
+	}
+
+	for _, schedule := range promotion.Schedules {
+		if scheduleActiveAt(schedule, at) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// promotionMatches reports whether an item is one the promotion applies to
+func promotionMatches(promotion models.Promotion, line invoiceLine) bool {
+	if len(promotion.FoodIDs) == 0 && len(promotion.MenuCategories) == 0 {
+		return true
+	}
+
+	for _, foodID := range promotion.FoodIDs {
+		if foodID == *line.OrderItem.FoodID {
+			return true
+		}
+	}
+
+	for _, category := range promotion.MenuCategories {
+		if strings.EqualFold(category, line.MenuCategory) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// percentOff works out a percentage or fixed discount on an amount, never more than the amount
+func percentOff(discountType string, value float64, amount float64) float64 {
+	if discountType == models.PromotionPercentage {
+		return toFixed(amount*value/100, 2)
+	}
+	return toFixed(math.Min(value, amount), 2)
+}
+
+// invoiceLines pairs each item on the order with its food and the category of the food's menu
+func invoiceLines(ctx context.Context, orderID string) ([]invoiceLine, error) {
+	orderItems, foods, err := orderItemsWithFoods(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	var menuIDs []string
+	for _, food := range foods {
+		menuIDs = append(menuIDs, *food.MenuID)
+	}
+
+	categories := make(map[string]string)
+	if len(menuIDs) > 0 {
+		cursor, err := menuCollection.Find(ctx, bson.M{"menu_id": bson.M{"$in": menuIDs}})
+		if err != nil {
+			return nil, err
+		}
+
+		var menus []models.Menu
+		if err = cursor.All(ctx, &menus); err != nil {
+			return nil, err
+		}
+
+		for _, menu := range menus {
+			categories[menu.MenuID] = menu.Category
+		}
+	}
+
+	lines := []invoiceLine{}
+	for _, orderItem := range orderItems {
+		line := invoiceLine{OrderItem: orderItem, Price: toFixed(*orderItem.UnitPrice, 2)}
+		if food, ok := foods[*orderItem.FoodID]; ok {
+			line.Food = food
+			line.MenuCategory = categories[*food.MenuID]
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+// spreadDiscount takes an invoice level discount off the lines it applies to in
+// proportion to their price, so the tax on each line is worked out on what is paid
+func spreadDiscount(lines []invoiceLine, eligible []int, amount float64) {
+	base := 0.0
+	for _, i := range eligible {
+		base += lines[i].Price
+	}
+
+	if base <= 0 {
+		return
+	}
+
+	remaining := amount
+	for n, i := range eligible {
+		share := toFixed(amount*lines[i].Price/base, 2)
+		if n == len(eligible)-1 {
+			share = toFixed(remaining, 2)
+		}
+		share = math.Min(share, lines[i].Price)
+
+		lines[i].Price = toFixed(lines[i].Price-share, 2)
+		remaining -= share
+	}
+}
+
+// applyDiscounts takes the promotions, coupon and manual discounts off the lines of
+// an invoice and returns the discount lines for the bill. Item discounts do not stack:
+// each item gets the biggest promotion it qualifies for, then any manual discount on
+// it. Invoice discounts follow in turn: the biggest automatic promotion, the coupon
+// and then the manual discounts, each on what is left to pay.
+func applyDiscounts(ctx context.Context, invoice models.Invoice, lines []invoiceLine) ([]models.InvoiceDiscount, error) {
+	filter := bson.M{
+		"active": bson.M{"$ne": false},
+		"$or":    bson.A{bson.M{"coupon_code": nil}},
+	}
+	if invoice.CouponCode != nil {
+		filter["$or"] = bson.A{bson.M{"coupon_code": nil}, bson.M{"coupon_code": *invoice.CouponCode}}
+	}
+
+	cursor, err := promotionCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var promotions []models.Promotion
+	if err = cursor.All(ctx, &promotions); err != nil {
+		return nil, err
+	}
+
+	discounts := []models.InvoiceDiscount{}
+	source := func(promotion models.Promotion) string {
+		if promotion.CouponCode != nil {
+			return models.DiscountSourceCoupon
+		}
+		return models.DiscountSourcePromotion
+	}
+
+	// the biggest item promotion on each line
+	best := make([]float64, len(lines))
+	bestPromotion := make([]*models.Promotion, len(lines))
+	offer := func(i int, amount float64, promotion *models.Promotion) {
+		if amount > best[i] {
+			best[i] = amount
+			bestPromotion[i] = promotion
+		}
+	}
+
+	for p := range promotions {
+		promotion := &promotions[p]
+		if *promotion.Scope != models.PromotionScopeItem {
+			continue
+		}
+
+		var matching []int
+		for i, line := range lines {
+			if promotionMatches(*promotion, line) && promotionActiveAt(*promotion, line.OrderItem.CreatedAt) {
+				matching = append(matching, i)
+			}
+		}
+
+		if *promotion.Type != models.PromotionBuyXGetY {
+			for _, i := range matching {
+				offer(i, percentOff(*promotion.Type, *promotion.Value, lines[i].Price), promotion)
+			}
+			continue
+		}
+
+		// the cheapest of every buy+get items are free
+		sort.SliceStable(matching, func(a, b int) bool {
+			return lines[matching[a]].Price < lines[matching[b]].Price
+		})
+		free := len(matching) / (*promotion.BuyQuantity + *promotion.GetQuantity) * *promotion.GetQuantity
+		for _, i := range matching[:free] {
+			offer(i, lines[i].Price, promotion)
+		}
+	}
+
+	for i := range lines {
+		if bestPromotion[i] == nil {
+			continue
+		}
+
+		lines[i].Price = toFixed(lines[i].Price-best[i], 2)
+		discounts = append(discounts, models.InvoiceDiscount{
+			Source:      source(*bestPromotion[i]),
+			ID:          bestPromotion[i].PromotionID,
+			Name:        *bestPromotion[i].Name,
+			OrderItemID: &lines[i].OrderItem.OrderItemID,
+			Amount:      best[i],
+		})
+	}
+
+	for _, manual := range invoice.ManualDiscounts {
+		if manual.OrderItemID == nil {
+			continue
+		}
+
+		for i := range lines {
+			if lines[i].OrderItem.OrderItemID != *manual.OrderItemID {
+				continue
+			}
+
+			amount := percentOff(*manual.Type, *manual.Value, lines[i].Price)
+			lines[i].Price = toFixed(lines[i].Price-amount, 2)
+			discounts = append(discounts, models.InvoiceDiscount{
+				Source:      models.DiscountSourceManual,
+				ID:          manual.DiscountID,
+				Name:        *manual.Reason,
+				OrderItemID: manual.OrderItemID,
+				Amount:      amount,
+			})
+		}
+	}
+
+	// invoice promotions apply to the matching items, or the whole bill
+	invoicePromotion := func(promotion models.Promotion) {
+		var eligible []int
+		base := 0.0
+		for i, line := range lines {
+			if promotionMatches(promotion, line) {
+				eligible = append(eligible, i)
+				base += line.Price
+			}
+		}
+
+		amount := percentOff(*promotion.Type, *promotion.Value, base)
+		if amount <= 0 {
+			return
+		}
+
+		spreadDiscount(lines, eligible, amount)
+		discounts = append(discounts, models.InvoiceDiscount{
+			Source: source(promotion),
+			ID:     promotion.PromotionID,
+			Name:   *promotion.Name,
+			Amount: amount,
+		})
+	}
+
+	var automatic *models.Promotion
+	var coupon *models.Promotion
+	bestAmount := 0.0
+	for p := range promotions {
+		promotion := &promotions[p]
+		if *promotion.Scope != models.PromotionScopeInvoice || !promotionActiveAt(*promotion, invoice.CreatedAt) {
+			continue
+		}
+
+		if promotion.CouponCode != nil {
+			coupon = promotion
+			continue
+		}
+
+		base := 0.0
+		for _, line := range lines {
+			if promotionMatches(*promotion, line) {
+				base += line.Price
+			}
+		}
+
+		if amount := percentOff(*promotion.Type, *promotion.Value, base); amount > bestAmount {
+			bestAmount = amount
+			automatic = promotion
+		}
+	}
+
+	if automatic != nil {
+		invoicePromotion(*automatic)
+	}
+	if coupon != nil {
+		invoicePromotion(*coupon)
+	}
+
+	for _, manual := range invoice.ManualDiscounts {
+		if manual.OrderItemID != nil {
+			continue
+		}
+
+		var eligible []int
+		base := 0.0
+		for i, line := range lines {
+			eligible = append(eligible, i)
+			base += line.Price
+		}
+
+		amount := percentOff(*manual.Type, *manual.Value, base)
+		spreadDiscount(lines, eligible, amount)
+		discounts = append(discounts, models.InvoiceDiscount{
+			Source: models.DiscountSourceManual,
+			ID:     manual.DiscountID,
+			Name:   *manual.Reason,
+			Amount: amount,
+		})
+	}
+
+	return discounts, nil
+}
+
+// openInvoice finds an invoice that discounts can still be changed on
+func openInvoice(ctx context.Context, invoiceID string) (models.Invoice, int, string) {
+	var invoice models.Invoice
+	err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoiceID}).Decode(&invoice)
+	if err != nil {
+		return invoice, http.StatusNotFound, "invoice was not found"
+	}
+
+	if invoice.PaidAt != nil || (invoice.PaymentStatus != nil && *invoice.PaymentStatus != models.InvoiceStatusPending) {
+		return invoice, http.StatusConflict, "discounts can only be changed before any payment is taken"
+	}
+
+	return invoice, http.StatusOK, ""
+}
+
+func CreatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var promotion models.Promotion
+
+		err := c.BindJSON(&promotion)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promotion JSON"})
+			return
+		}
+
+		err = validate.Struct(promotion)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the promotion structure failed"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if !userIsManager(ctx, c.GetString("uid")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only a manager can set up promotions"})
+			return
+		}
+
+		if code, msg := checkPromotion(ctx, &promotion, ""); msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		if promotion.Active == nil {
+			active := true
+			promotion.Active = &active
+		}
+		promotion.UsageCount = 0
+		promotion.CreatedBy = c.GetString("uid")
+		promotion.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		promotion.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		promotion.ID = primitive.NewObjectID()
+		promotion.PromotionID = promotion.ID.Hex()
+
+		_, err = promotionCollection.InsertOne(ctx, promotion)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Promotion was not created in the database"})
+			return
+		}
+
+		// open bills pick up the new promotion
+		err = recalculateOpenInvoices(ctx, bson.M{})
+		if err != nil {
+			log.Println("Error recalculating the open invoices: ", err)
+		}
+
+		c.JSON(http.StatusCreated, promotion)
+	}
+}
+
+func GetPromotions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"name", "created_at"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		switch c.Query("active") {
+		case "":
+		case "true":
+			filter["active"] = bson.M{"$ne": false}
+		case "false":
+			filter["active"] = false
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "active must be true or false"})
+			return
+		}
+
+		if promotionType := c.Query("type"); promotionType != "" {
+			filter["type"] = promotionType
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		promotions := []models.Promotion{}
+		result, err := listQuery.Find(ctx, promotionCollection, filter, &promotions)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the promotions"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetPromotionById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		promotionId := c.Param("promotion_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var promotion models.Promotion
+		err := promotionCollection.FindOne(ctx, bson.M{"promotion_id": promotionId}).Decode(&promotion)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the promotion in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, promotion)
+	}
+}
+
+// UpdatePromotion changes the rules of a promotion; the changes apply to every
+// invoice that has not been paid yet. Setting active to false ends the promotion.
+func UpdatePromotion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var update models.Promotion
+
+		err := c.BindJSON(&update)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while binding the promotion JSON from the request body"})
+			return
+		}
+
+		promotionId := c.Param("promotion_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if !userIsManager(ctx, c.GetString("uid")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only a manager can change promotions"})
+			return
+		}
+
+		var promotion models.Promotion
+		err = promotionCollection.FindOne(ctx, bson.M{"promotion_id": promotionId}).Decode(&promotion)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "promotion was not found"})
+			return
+		}
+
+		if update.Name != nil {
+			promotion.Name = update.Name
+		}
+		if update.Type != nil {
+			promotion.Type = update.Type
+		}
+		if update.Scope != nil {
+			promotion.Scope = update.Scope
+		}
+		if update.Value != nil {
+			promotion.Value = update.Value
+		}
+		if update.BuyQuantity != nil {
+			promotion.BuyQuantity = update.BuyQuantity
+		}
+		if update.GetQuantity != nil {
+			promotion.GetQuantity = update.GetQuantity
+		}
+		if update.FoodIDs != nil {
+			promotion.FoodIDs = update.FoodIDs
+		}
+		if update.MenuCategories != nil {
+			promotion.MenuCategories = update.MenuCategories
+		}
+		if update.Schedules != nil {
+			promotion.Schedules = update.Schedules
+		}
+		if update.StartDate != nil {
+			promotion.StartDate = update.StartDate
+		}
+		if update.EndDate != nil {
+			promotion.EndDate = update.EndDate
+		}
+		if update.CouponCode != nil {
+			promotion.CouponCode = update.CouponCode
+		}
+		if update.UsageLimit != nil {
+			promotion.UsageLimit = update.UsageLimit
+		}
+		if update.Active != nil {
+			promotion.Active = update.Active
+		}
+
+		err = validate.Struct(promotion)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the promotion structure failed"})
+			return
+		}
+
+		if code, msg := checkPromotion(ctx, &promotion, promotion.PromotionID); msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		promotion.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		_, err = promotionCollection.UpdateOne(ctx, bson.M{"promotion_id": promotion.PromotionID}, bson.D{{"$set", bson.D{
+			{"name", promotion.Name},
+			{"type", promotion.Type},
+			{"scope", promotion.Scope},
+			{"value", promotion.Value},
+			{"buy_quantity", promotion.BuyQuantity},
+			{"get_quantity", promotion.GetQuantity},
+			{"food_ids", promotion.FoodIDs},
+			{"menu_categories", promotion.MenuCategories},
+			{"schedules", promotion.Schedules},
+			{"start_date", promotion.StartDate},
+			{"end_date", promotion.EndDate},
+			{"coupon_code", promotion.CouponCode},
+			{"usage_limit", promotion.UsageLimit},
+			{"active", promotion.Active},
+			{"updated_at", promotion.UpdatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the promotion"})
+			return
+		}
+
+		// open bills pick up the changed promotion
+		err = recalculateOpenInvoices(ctx, bson.M{})
+		if err != nil {
+			log.Println("Error recalculating the open invoices: ", err)
+		}
+
+		c.JSON(http.StatusOK, promotion)
+	}
+}
+
+// ApplyCoupon adds a coupon to an invoice, using up one of the coupon's uses.
+// An invoice takes one coupon; adding another gives back the use of the first.
+func ApplyCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Code *string `json:"code" validate:"required"`
+		}
+
+		err := c.BindJSON(&request)
+		if err != nil || validate.Struct(request) != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a coupon code is required"})
+			return
+		}
+		code := strings.ToUpper(strings.TrimSpace(*request.Code))
+
+		invoiceId := c.Param("invoice_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		invoice, status, msg := openInvoice(ctx, invoiceId)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		if invoice.CouponCode != nil && *invoice.CouponCode == code {
+			c.JSON(http.StatusConflict, gin.H{"error": "the coupon is already on the invoice"})
+			return
+		}
+
+		var promotion models.Promotion
+		err = promotionCollection.FindOne(ctx, bson.M{"coupon_code": code}).Decode(&promotion)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the coupon code is not valid"})
+			return
+		}
+
+		now := time.Now()
+		if (promotion.Active != nil && !*promotion.Active) ||
+			(promotion.StartDate != nil && now.Before(*promotion.StartDate)) ||
+			(promotion.EndDate != nil && !now.Before(*promotion.EndDate)) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the coupon has expired or is not valid yet"})
+			return
+		}
+
+		// the use is only taken while there are uses left, so a limited coupon cannot be over-redeemed
+		filter := bson.M{"promotion_id": promotion.PromotionID}
+		if promotion.UsageLimit != nil {
+			filter["usage_count"] = bson.M{"$lt": *promotion.UsageLimit}
+		}
+
+		result, err := promotionCollection.UpdateOne(ctx, filter, bson.D{{"$inc", bson.D{{"usage_count", 1}}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to redeem the coupon"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the coupon has been used up"})
+			return
+		}
+
+		if invoice.CouponCode != nil {
+			_, _ = promotionCollection.UpdateOne(ctx, bson.M{"coupon_code": *invoice.CouponCode}, bson.D{{"$inc", bson.D{{"usage_count", -1}}}})
+		}
+
+		_, err = invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoiceId}, bson.D{{"$set", bson.D{{"coupon_code", code}}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add the coupon to the invoice"})
+			return
+		}
+
+		invoice, err = recalculateInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+			return
+		}
+
+		c.JSON(http.StatusOK, invoice)
+	}
+}
+
+func RemoveCoupon() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		invoiceId := c.Param("invoice_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		invoice, status, msg := openInvoice(ctx, invoiceId)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		if invoice.CouponCode == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the invoice has no coupon"})
+			return
+		}
+
+		result, err := invoiceCollection.UpdateOne(ctx, bson.M{
+			"invoice_id":  invoiceId,
+			"coupon_code": *invoice.CouponCode,
+		}, bson.D{{"$set", bson.D{{"coupon_code", nil}}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove the coupon"})
+			return
+		}
+
+		// only the request that took the coupon off gives its use back
+		if result.ModifiedCount > 0 {
+			_, _ = promotionCollection.UpdateOne(ctx, bson.M{"coupon_code": *invoice.CouponCode}, bson.D{{"$inc", bson.D{{"usage_count", -1}}}})
+		}
+
+		invoice, err = recalculateInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+			return
+		}
+
+		c.JSON(http.StatusOK, invoice)
+	}
+}
+
+// AddManualDiscount lets a manager take money off an item or the whole invoice.
+// Staff who are not managers need a manager to approve it, and a reason is always recorded.
+func AddManualDiscount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			models.ManualDiscount
+			Approval *models.ManagerApproval `json:"approval"`
+		}
+
+		err := c.BindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid discount JSON"})
+			return
+		}
+		discount := request.ManualDiscount
+
+		err = validate.Struct(discount)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a discount needs a PERCENTAGE or FIXED type, a value and a reason"})
+			return
+		}
+
+		if *discount.Type == models.PromotionPercentage && *discount.Value > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a percentage discount cannot be more than 100"})
+			return
+		}
+
+		invoiceId := c.Param("invoice_id")
+		uid := c.GetString("uid")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		approvedBy, status, msg := managerApproval(ctx, uid, request.Approval)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		invoice, status, msg := openInvoice(ctx, invoiceId)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		if discount.OrderItemID != nil {
+			count, err := orderItemsCollection.CountDocuments(ctx, bson.M{
+				"order_item_id": *discount.OrderItemID,
+				"order_id":      invoice.OrderID,
+			})
+			if err != nil || count == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the item is not on the invoice's order"})
+				return
+			}
+		}
+
+		discount.AppliedBy = uid
+		discount.ApprovedBy = approvedBy
+		discount.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		discount.DiscountID = primitive.NewObjectID().Hex()
+
+		_, err = invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoiceId}, bson.D{{"$push", bson.D{{"manual_discounts", discount}}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add the discount to the invoice"})
+			return
+		}
+
+		before := invoice.Total
+		invoice, err = recalculateInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+			return
+		}
+
+		recordAudit(ctx, models.AuditEntry{
+			Action:     models.AuditDiscountApplied,
+			InvoiceID:  invoiceId,
+			Amount:     toFixed(invoice.Total-before, 2),
+			Reason:     discount.Reason,
+			UserID:     uid,
+			ApprovedBy: &approvedBy,
+		})
+
+		c.JSON(http.StatusCreated, invoice)
+	}
+}
+
+func RemoveManualDiscount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Approval *models.ManagerApproval `json:"approval"`
+		}
+
+		// the approval is only needed from staff who are not managers
+		_ = c.ShouldBindJSON(&request)
+
+		invoiceId := c.Param("invoice_id")
+		discountId := c.Param("discount_id")
+		uid := c.GetString("uid")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		approvedBy, status, msg := managerApproval(ctx, uid, request.Approval)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		invoice, status, msg := openInvoice(ctx, invoiceId)
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+
+		result, err := invoiceCollection.UpdateOne(ctx, bson.M{"invoice_id": invoiceId}, bson.D{{"$pull", bson.D{
+			{"manual_discounts", bson.D{{"discount_id", discountId}}},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove the discount"})
+			return
+		}
+
+		if result.ModifiedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the discount is not on the invoice"})
+			return
+		}
+
+		before := invoice.Total
+		invoice, err = recalculateInvoice(ctx, invoiceId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+			return
+		}
+
+		reason := fmt.Sprintf("discount %s removed", discountId)
+		recordAudit(ctx, models.AuditEntry{
+			Action:     models.AuditDiscountRemoved,
+			InvoiceID:  invoiceId,
+			Amount:     toFixed(invoice.Total-before, 2),
+			Reason:     &reason,
+			UserID:     uid,
+			ApprovedBy: &approvedBy,
+		})
+
+		c.JSON(http.StatusOK, invoice)
+	}
+}
@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCouponOnlyAppliesOnceRedeemed(t *testing.T) {
+	ctx := testDatabase(t)
+
+	menu := newMenu()
+	food := newFood(menu.MenuID, "Test special", 20)
+	fixture(t, ctx, menuCollection, menu)
+	fixture(t, ctx, foodCollection, food)
+
+	promotionName, promotionType, scope, value := "Test coupon", models.PromotionPercentage, models.PromotionScopeInvoice, 25.0
+	code, limit := strings.ToUpper("TEST"+primitive.NewObjectID().Hex()), 1
+	promotion := models.Promotion{
+		ID:         primitive.NewObjectID(),
+		Name:       &promotionName,
+		Type:       &promotionType,
+		Scope:      &scope,
+		Value:      &value,
+		FoodIDs:    []string{food.FoodID},
+		CouponCode: &code,
+		UsageLimit: &limit,
+	}
+	promotion.PromotionID = promotion.ID.Hex()
+	fixture(t, ctx, promotionCollection, promotion)
+
+	// two bills for the same food, each with an item and no payments
+	var invoiceIDs []string
+	for i := 0; i < 2; i++ {
+		order := newOrder(nil)
+		invoice := newInvoice(order.OrderID, models.InvoiceStatusPending)
+		fixture(t, ctx, orderItemsCollection, newOrderItem(order.OrderID, food.FoodID, *food.Price))
+		fixture(t, ctx, invoiceCollection, invoice)
+		invoiceIDs = append(invoiceIDs, invoice.InvoiceID)
+	}
+
+	couponDiscount := func(invoice models.Invoice) float64 {
+		for _, discount := range invoice.Discounts {
+			if discount.ID == promotion.PromotionID {
+				return discount.Amount
+			}
+		}
+		return 0
+	}
+
+	invoice, err := recalculateInvoice(ctx, invoiceIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if amount := couponDiscount(invoice); amount != 0 {
+		t.Fatalf("the coupon took %v off a bill it was never applied to", amount)
+	}
+
+	applyCoupon := func(invoiceID string) *models.Invoice {
+		recorder := serve(withParam(ApplyCoupon(), "invoice_id", invoiceID), http.MethodPost, "/invoices/"+invoiceID+"/coupon", gin.H{"code": code}, "")
+		if recorder.Code != http.StatusOK {
+			t.Logf("applying the coupon to %s: got %d: %s", invoiceID, recorder.Code, recorder.Body)
+			return nil
+		}
+
+		var applied models.Invoice
+		if err := json.Unmarshal(recorder.Body.Bytes(), &applied); err != nil {
+			t.Fatal(err)
+		}
+		return &applied
+	}
+
+	applied := applyCoupon(invoiceIDs[0])
+	if applied == nil {
+		t.Fatal("the coupon was not applied")
+	}
+	if amount := couponDiscount(*applied); amount != 5 {
+		t.Errorf("the coupon took %v off, want 5", amount)
+	}
+
+	// the only use has been taken
+	if applyCoupon(invoiceIDs[1]) != nil {
+		t.Error("a coupon limited to one use was applied twice")
+	}
+
+	var stored models.Promotion
+	if err := promotionCollection.FindOne(ctx, bson.M{"promotion_id": promotion.PromotionID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.UsageCount != 1 {
+		t.Errorf("the coupon has been used %d times, want 1", stored.UsageCount)
+	}
+}
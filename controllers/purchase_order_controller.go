@@ -0,0 +1,609 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"math"
+	"net/http"
+	"time"
+)
+
+var purchaseOrderCollection = database.Collection(database.Client, "purchaseOrders")
+
+// openPurchaseOrderStatuses are the purchase orders still expecting a delivery
+var openPurchaseOrderStatuses = []string{
+	models.PurchaseOrderStatusDraft,
+	models.PurchaseOrderStatusOrdered,
+	models.PurchaseOrderStatusPartiallyReceived,
+}
+
+// checkPurchaseOrderLines makes sure every ingredient exists and is only ordered once
+func checkPurchaseOrderLines(ctx context.Context, lines []models.PurchaseOrderLine) (int, string) {
+	var ingredientIDs []string
+	seen := make(map[string]bool)
+	for _, line := range lines {
+		if seen[*line.IngredientID] {
+			return http.StatusBadRequest, fmt.Sprintf("ingredient %s is ordered more than once", *line.IngredientID)
+		}
+		seen[*line.IngredientID] = true
+		ingredientIDs = append(ingredientIDs, *line.IngredientID)
+	}
+
+	count, err := ingredientCollection.CountDocuments(ctx, bson.M{"ingredient_id": bson.M{"$in": ingredientIDs}})
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check the ordered ingredients"
+	}
+
+	if int(count) != len(ingredientIDs) {
+		return http.StatusBadRequest, "one or more of the ingredients do not exist"
+	}
+
+	return http.StatusOK, ""
+}
+
+// movingAverageCost blends the cost of the stock on hand with the cost of a delivery
+func movingAverageCost(ingredient models.Ingredient, quantity float64, unitCost float64) float64 {
+	if ingredient.UnitCost == nil {
+		return unitCost
+	}
+
+	// stock depleted past zero by recipes has no cost left to carry
+	onHand := math.Max(*ingredient.StockLevel, 0)
+	if onHand+quantity == 0 {
+		return unitCost
+	}
+
+	return (onHand**ingredient.UnitCost + quantity*unitCost) / (onHand + quantity)
+}
+
+// errPurchaseOrderChanged is returned when a delivery is booked against a purchase
+// order that was received against or edited since it was read
+var errPurchaseOrderChanged = errors.New("the purchase order changed")
+
+// bookDelivery records a delivery on its purchase order and books the stock of every
+// line, with its moving average cost, in one transaction. Either the whole delivery
+// is booked or none of it is, and a delivery of the same ingredient at the same time
+// makes the transaction retry rather than average over a stale stock level.
+func bookDelivery(ctx context.Context, purchaseOrder models.PurchaseOrder, setObj bson.D, receipt models.PurchaseOrderReceipt) error {
+	session, err := database.Client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	book := func(sessCtx mongo.SessionContext) (interface{}, error) {
+		// the purchase order is only booked if nobody else received against it in the meantime
+		result, err := purchaseOrderCollection.UpdateOne(sessCtx, bson.M{
+			"purchase_order_id": purchaseOrder.PurchaseOrderID,
+			"version":           purchaseOrder.Version,
+		}, bson.D{
+			{"$set", setObj},
+			{"$push", bson.D{{"receipts", receipt}}},
+			{"$inc", bson.D{{"version", 1}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if result.MatchedCount == 0 {
+			return nil, errPurchaseOrderChanged
+		}
+
+		for _, received := range receipt.Lines {
+			var ingredient models.Ingredient
+			err = ingredientCollection.FindOne(sessCtx, bson.M{"ingredient_id": received.IngredientID}).Decode(&ingredient)
+			if err != nil {
+				return nil, err
+			}
+
+			unitCost := toFixed(movingAverageCost(ingredient, *received.Quantity, *received.UnitCost), 4)
+			_, err = ingredientCollection.UpdateOne(sessCtx, bson.M{"ingredient_id": received.IngredientID}, bson.D{
+				{"$inc", bson.D{{"stock_level", *received.Quantity}}},
+				{"$set", bson.D{{"unit_cost", unitCost}, {"updated_at", receipt.ReceivedAt}}},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			movement := models.StockMovement{
+				ID:              primitive.NewObjectID(),
+				IngredientID:    *received.IngredientID,
+				Quantity:        *received.Quantity,
+				Reason:          models.StockMovementReceived,
+				PurchaseOrderID: &purchaseOrder.PurchaseOrderID,
+				CreatedBy:       receipt.ReceivedBy,
+				CreatedAt:       receipt.ReceivedAt,
+			}
+			movement.StockMovementID = movement.ID.Hex()
+
+			_, err = stockMovementCollection.InsertOne(sessCtx, movement)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	}
+
+	_, err = session.WithTransaction(ctx, book)
+	return err
+}
+
+// onOrder is the quantity of each ingredient still to arrive on open purchase orders
+func onOrder(ctx context.Context) (map[string]float64, error) {
+	cursor, err := purchaseOrderCollection.Find(ctx, bson.M{"status": bson.M{"$in": openPurchaseOrderStatuses}})
+	if err != nil {
+		return nil, err
+	}
+
+	var purchaseOrders []models.PurchaseOrder
+	if err = cursor.All(ctx, &purchaseOrders); err != nil {
+		return nil, err
+	}
+
+	quantities := make(map[string]float64)
+	for _, purchaseOrder := range purchaseOrders {
+		for _, line := range purchaseOrder.Lines {
+			if outstanding := *line.OrderedQuantity - line.ReceivedQuantity; outstanding > 0 {
+				quantities[*line.IngredientID] += outstanding
+			}
+		}
+	}
+
+	return quantities, nil
+}
+
+func CreatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var purchaseOrder models.PurchaseOrder
+
+		err := c.BindJSON(&purchaseOrder)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase order JSON"})
+			return
+		}
+
+		err = validate.Struct(purchaseOrder)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the purchase order structure failed"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if !supplierExists(ctx, *purchaseOrder.SupplierID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "supplier was not found"})
+			return
+		}
+
+		code, msg := checkPurchaseOrderLines(ctx, purchaseOrder.Lines)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		status := models.PurchaseOrderStatusDraft
+		if purchaseOrder.Status != nil {
+			status = *purchaseOrder.Status
+		}
+
+		if status == models.PurchaseOrderStatusCancelled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a purchase order cannot be created cancelled"})
+			return
+		}
+
+		for i := range purchaseOrder.Lines {
+			purchaseOrder.Lines[i].ReceivedQuantity = 0
+			purchaseOrder.Lines[i].ReceivedCost = 0
+		}
+
+		purchaseOrder.Status = &status
+		purchaseOrder.Receipts = []models.PurchaseOrderReceipt{}
+		purchaseOrder.CreatedBy = c.GetString("uid")
+		purchaseOrder.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		purchaseOrder.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		if status == models.PurchaseOrderStatusOrdered {
+			purchaseOrder.OrderedAt = &purchaseOrder.CreatedAt
+		}
+		purchaseOrder.ID = primitive.NewObjectID()
+		purchaseOrder.PurchaseOrderID = purchaseOrder.ID.Hex()
+
+		_, err = purchaseOrderCollection.InsertOne(ctx, purchaseOrder)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Purchase order was not created in the database"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, purchaseOrder)
+	}
+}
+
+// SuggestPurchaseOrders drafts a purchase order per supplier for the ingredients
+// below their par level, less whatever is already on order
+func SuggestPurchaseOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter := bson.M{
+			"par_level": bson.M{"$ne": nil},
+			"$expr":     bson.M{"$lt": bson.A{"$stock_level", "$par_level"}},
+		}
+		if supplierId := c.Query("supplier_id"); supplierId != "" {
+			filter["supplier_id"] = supplierId
+		}
+
+		cursor, err := ingredientCollection.Find(ctx, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find the ingredients below par"})
+			return
+		}
+
+		var belowPar []models.Ingredient
+		if err = cursor.All(ctx, &belowPar); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the ingredients below par"})
+			return
+		}
+
+		outstanding, err := onOrder(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the open purchase orders"})
+			return
+		}
+
+		// ingredients with no supplier are handed back so they can be ordered by hand
+		linesBySupplier := make(map[string][]models.PurchaseOrderLine)
+		var supplierIDs []string
+		unassigned := []models.Ingredient{}
+		for _, ingredient := range belowPar {
+			quantity := toFixed(*ingredient.ParLevel-*ingredient.StockLevel-outstanding[ingredient.IngredientID], 3)
+			if quantity <= 0 {
+				continue
+			}
+
+			if ingredient.SupplierID == nil {
+				unassigned = append(unassigned, ingredient)
+				continue
+			}
+
+			supplierID := *ingredient.SupplierID
+			if _, ok := linesBySupplier[supplierID]; !ok {
+				supplierIDs = append(supplierIDs, supplierID)
+			}
+
+			ingredientID := ingredient.IngredientID
+			linesBySupplier[supplierID] = append(linesBySupplier[supplierID], models.PurchaseOrderLine{
+				IngredientID:    &ingredientID,
+				OrderedQuantity: &quantity,
+				UnitCost:        ingredient.UnitCost,
+			})
+		}
+
+		createdAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		purchaseOrders := []models.PurchaseOrder{}
+		for _, supplierID := range supplierIDs {
+			supplierID := supplierID
+			status := models.PurchaseOrderStatusDraft
+			purchaseOrder := models.PurchaseOrder{
+				ID:         primitive.NewObjectID(),
+				SupplierID: &supplierID,
+				Status:     &status,
+				Lines:      linesBySupplier[supplierID],
+				Receipts:   []models.PurchaseOrderReceipt{},
+				CreatedBy:  c.GetString("uid"),
+				CreatedAt:  createdAt,
+				UpdatedAt:  createdAt,
+			}
+			purchaseOrder.PurchaseOrderID = purchaseOrder.ID.Hex()
+
+			_, err = purchaseOrderCollection.InsertOne(ctx, purchaseOrder)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Suggested purchase order was not created in the database"})
+				return
+			}
+
+			purchaseOrders = append(purchaseOrders, purchaseOrder)
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"purchase_orders": purchaseOrders, "unassigned": unassigned})
+	}
+}
+
+func GetPurchaseOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at", "ordered_at", "received_at"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		if supplierId := c.Query("supplier_id"); supplierId != "" {
+			filter["supplier_id"] = supplierId
+		}
+
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		purchaseOrders := []models.PurchaseOrder{}
+		result, err := listQuery.Find(ctx, purchaseOrderCollection, filter, &purchaseOrders)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the purchase orders"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetPurchaseOrderById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		purchaseOrderId := c.Param("purchase_order_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var purchaseOrder models.PurchaseOrder
+		err := purchaseOrderCollection.FindOne(ctx, bson.M{"purchase_order_id": purchaseOrderId}).Decode(&purchaseOrder)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the purchase order in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, purchaseOrder)
+	}
+}
+
+// UpdatePurchaseOrder edits a draft, places it with the supplier or cancels it.
+// Once goods have been received the purchase order only changes through receiving.
+func UpdatePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var update models.PurchaseOrder
+
+		err := c.BindJSON(&update)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while binding the purchase order JSON from the request body"})
+			return
+		}
+
+		err = validate.StructExcept(update, "SupplierID", "Lines")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be DRAFT, ORDERED or CANCELLED"})
+			return
+		}
+
+		purchaseOrderId := c.Param("purchase_order_id")
+		filter := bson.M{"purchase_order_id": purchaseOrderId}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var purchaseOrder models.PurchaseOrder
+		err = purchaseOrderCollection.FindOne(ctx, filter).Decode(&purchaseOrder)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "purchase order was not found"})
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		var updateObj primitive.D
+
+		if update.Lines != nil || update.SupplierID != nil {
+			if *purchaseOrder.Status != models.PurchaseOrderStatusDraft {
+				c.JSON(http.StatusConflict, gin.H{"error": "only a draft purchase order can be edited"})
+				return
+			}
+
+			if update.SupplierID != nil {
+				if !supplierExists(ctx, *update.SupplierID) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "supplier was not found"})
+					return
+				}
+				updateObj = append(updateObj, bson.E{"supplier_id", update.SupplierID})
+			}
+
+			if update.Lines != nil {
+				err = validate.Var(update.Lines, "required,min=1,dive")
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "every line needs an ingredient and an ordered quantity"})
+					return
+				}
+
+				code, msg := checkPurchaseOrderLines(ctx, update.Lines)
+				if msg != "" {
+					c.JSON(code, gin.H{"error": msg})
+					return
+				}
+
+				for i := range update.Lines {
+					update.Lines[i].ReceivedQuantity = 0
+					update.Lines[i].ReceivedCost = 0
+				}
+				updateObj = append(updateObj, bson.E{"lines", update.Lines})
+			}
+		}
+
+		if update.Status != nil && *update.Status != *purchaseOrder.Status {
+			switch *update.Status {
+			case models.PurchaseOrderStatusOrdered:
+				if *purchaseOrder.Status != models.PurchaseOrderStatusDraft {
+					c.JSON(http.StatusConflict, gin.H{"error": "only a draft purchase order can be placed"})
+					return
+				}
+				updateObj = append(updateObj, bson.E{"ordered_at", updatedAt})
+			case models.PurchaseOrderStatusCancelled:
+				if *purchaseOrder.Status != models.PurchaseOrderStatusDraft && *purchaseOrder.Status != models.PurchaseOrderStatusOrdered {
+					c.JSON(http.StatusConflict, gin.H{"error": "a purchase order cannot be cancelled once goods have been received"})
+					return
+				}
+			default:
+				c.JSON(http.StatusConflict, gin.H{"error": "a placed purchase order cannot go back to draft"})
+				return
+			}
+			updateObj = append(updateObj, bson.E{"status", update.Status})
+		}
+
+		if update.Notes != nil {
+			updateObj = append(updateObj, bson.E{"notes", update.Notes})
+		}
+
+		updateObj = append(updateObj, bson.E{"updated_at", updatedAt})
+
+		// the checks above only hold if nobody else changed the purchase order in the meantime
+		result, err := purchaseOrderCollection.UpdateOne(ctx, bson.M{
+			"purchase_order_id": purchaseOrderId,
+			"version":           purchaseOrder.Version,
+		}, bson.D{
+			{"$set", updateObj},
+			{"$inc", bson.D{{"version", 1}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "purchase order failed to update"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the purchase order was changed by someone else, try again"})
+			return
+		}
+
+		err = purchaseOrderCollection.FindOne(ctx, filter).Decode(&purchaseOrder)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the purchase order in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, purchaseOrder)
+	}
+}
+
+// ReceivePurchaseOrder books a delivery against a placed purchase order. Deliveries
+// can be partial; each one adds to the ingredient stock and blends the actual cost
+// into the ingredient's moving-average cost. Sending final closes the purchase
+// order even when the supplier has shorted some lines.
+func ReceivePurchaseOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var delivery struct {
+			Lines []models.ReceivedLine `json:"lines" validate:"required,min=1,dive"`
+			Final bool                  `json:"final"`
+		}
+
+		err := c.BindJSON(&delivery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery JSON"})
+			return
+		}
+
+		err = validate.Struct(delivery)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "every delivered line needs an ingredient, a quantity and a unit cost"})
+			return
+		}
+
+		purchaseOrderId := c.Param("purchase_order_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var purchaseOrder models.PurchaseOrder
+		err = purchaseOrderCollection.FindOne(ctx, bson.M{"purchase_order_id": purchaseOrderId}).Decode(&purchaseOrder)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "purchase order was not found"})
+			return
+		}
+
+		if *purchaseOrder.Status != models.PurchaseOrderStatusOrdered && *purchaseOrder.Status != models.PurchaseOrderStatusPartiallyReceived {
+			msg := fmt.Sprintf("a %s purchase order cannot be received", *purchaseOrder.Status)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		lineIndex := make(map[string]int)
+		for i, line := range purchaseOrder.Lines {
+			lineIndex[*line.IngredientID] = i
+		}
+
+		seen := make(map[string]bool)
+		for _, received := range delivery.Lines {
+			if _, ok := lineIndex[*received.IngredientID]; !ok {
+				msg := fmt.Sprintf("ingredient %s is not on the purchase order", *received.IngredientID)
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+
+			if seen[*received.IngredientID] {
+				msg := fmt.Sprintf("ingredient %s is listed more than once", *received.IngredientID)
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			seen[*received.IngredientID] = true
+
+			i := lineIndex[*received.IngredientID]
+			purchaseOrder.Lines[i].ReceivedQuantity = toFixed(purchaseOrder.Lines[i].ReceivedQuantity+*received.Quantity, 3)
+			purchaseOrder.Lines[i].ReceivedCost = toFixed(purchaseOrder.Lines[i].ReceivedCost+*received.Quantity**received.UnitCost, 2)
+		}
+
+		receivedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		status := models.PurchaseOrderStatusReceived
+		for _, line := range purchaseOrder.Lines {
+			if line.ReceivedQuantity < *line.OrderedQuantity && !delivery.Final {
+				status = models.PurchaseOrderStatusPartiallyReceived
+			}
+		}
+
+		setObj := bson.D{
+			{"lines", purchaseOrder.Lines},
+			{"status", status},
+			{"updated_at", receivedAt},
+		}
+		if status == models.PurchaseOrderStatusReceived {
+			setObj = append(setObj, bson.E{"received_at", receivedAt})
+		}
+
+		receipt := models.PurchaseOrderReceipt{
+			Lines:      delivery.Lines,
+			ReceivedBy: c.GetString("uid"),
+			ReceivedAt: receivedAt,
+		}
+
+		err = bookDelivery(ctx, purchaseOrder, setObj, receipt)
+		if errors.Is(err, errPurchaseOrderChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the purchase order changed while the delivery was being booked, try again"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to book the delivery"})
+			return
+		}
+
+		purchaseOrder.Status = &status
+		purchaseOrder.Receipts = append(purchaseOrder.Receipts, receipt)
+		purchaseOrder.UpdatedAt = receivedAt
+		purchaseOrder.Version++
+		if status == models.PurchaseOrderStatusReceived {
+			purchaseOrder.ReceivedAt = &receivedAt
+		}
+
+		c.JSON(http.StatusOK, purchaseOrder)
+	}
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMovingAverageCost(t *testing.T) {
+	cost := func(stock float64, unitCost *float64) models.Ingredient {
+		return models.Ingredient{StockLevel: &stock, UnitCost: unitCost}
+	}
+	two := 2.0
+
+	tests := []struct {
+		name       string
+		ingredient models.Ingredient
+		want       float64
+	}{
+		{"first delivery", cost(0, nil), 4},
+		{"blended with the stock on hand", cost(10, &two), 3},
+		{"stock used past zero", cost(-5, &two), 4},
+	}
+
+	for _, test := range tests {
+		if got := movingAverageCost(test.ingredient, 10, 4); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func newIngredient(stock float64, unitCost float64) models.Ingredient {
+	now := time.Now()
+	name, unit := "Test flour", "kg"
+	ingredient := models.Ingredient{ID: primitive.NewObjectID(), Name: &name, Unit: &unit, StockLevel: &stock, UnitCost: &unitCost, CreatedAt: now, UpdatedAt: now}
+	ingredient.IngredientID = ingredient.ID.Hex()
+	return ingredient
+}
+
+func TestFailedDeliveryBooksNothing(t *testing.T) {
+	ctx := testDatabase(t)
+
+	flour := newIngredient(10, 2)
+	missingID := primitive.NewObjectID().Hex()
+	fixture(t, ctx, ingredientCollection, flour)
+
+	now := time.Now()
+	status, supplierID, ordered := models.PurchaseOrderStatusOrdered, primitive.NewObjectID().Hex(), 10.0
+	purchaseOrder := models.PurchaseOrder{
+		ID:         primitive.NewObjectID(),
+		SupplierID: &supplierID,
+		Status:     &status,
+		Lines: []models.PurchaseOrderLine{
+			{IngredientID: &flour.IngredientID, OrderedQuantity: &ordered},
+			{IngredientID: &missingID, OrderedQuantity: &ordered},
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	purchaseOrder.PurchaseOrderID = purchaseOrder.ID.Hex()
+	fixture(t, ctx, purchaseOrderCollection, purchaseOrder)
+	cleanup(t, ctx, stockMovementCollection, bson.M{"purchase_order_id": purchaseOrder.PurchaseOrderID})
+
+	// the second ingredient was deleted after it was ordered, so its line cannot be booked
+	handler := withParam(ReceivePurchaseOrder(), "purchase_order_id", purchaseOrder.PurchaseOrderID)
+	recorder := serve(handler, http.MethodPost, "/purchaseOrders/"+purchaseOrder.PurchaseOrderID+"/receive", gin.H{"final": true, "lines": []gin.H{
+		{"ingredient_id": flour.IngredientID, "quantity": 10, "unit_cost": 4},
+		{"ingredient_id": missingID, "quantity": 10, "unit_cost": 4},
+	}}, "")
+	if recorder.Code != http.StatusInternalServerError {
+		t.Fatalf("receiving a line for a deleted ingredient: got %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+
+	var stored models.PurchaseOrder
+	if err := purchaseOrderCollection.FindOne(ctx, bson.M{"purchase_order_id": purchaseOrder.PurchaseOrderID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if *stored.Status != models.PurchaseOrderStatusOrdered || len(stored.Receipts) != 0 {
+		t.Errorf("the purchase order was left %s with %d receipts", *stored.Status, len(stored.Receipts))
+	}
+
+	var ingredient models.Ingredient
+	if err := ingredientCollection.FindOne(ctx, bson.M{"ingredient_id": flour.IngredientID}).Decode(&ingredient); err != nil {
+		t.Fatal(err)
+	}
+	if *ingredient.StockLevel != 10 || *ingredient.UnitCost != 2 {
+		t.Errorf("the first line was booked anyway: stock %v at %v", *ingredient.StockLevel, *ingredient.UnitCost)
+	}
+
+	count, err := stockMovementCollection.CountDocuments(ctx, bson.M{"purchase_order_id": purchaseOrder.PurchaseOrderID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d stock movements were kept for a delivery that was not booked", count)
+	}
+}
@@ -0,0 +1,192 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+var recipeCollection = database.Collection(database.Client, "recipes")
+
+// checkRecipeIngredients makes sure every ingredient exists and is only listed once
+func checkRecipeIngredients(ctx context.Context, ingredients []models.RecipeIngredient) (int, string) {
+	var ingredientIDs []string
+	seen := make(map[string]bool)
+	for _, ingredient := range ingredients {
+		if seen[*ingredient.IngredientID] {
+			return http.StatusBadRequest, fmt.Sprintf("ingredient %s is listed more than once", *ingredient.IngredientID)
+		}
+		seen[*ingredient.IngredientID] = true
+		ingredientIDs = append(ingredientIDs, *ingredient.IngredientID)
+	}
+
+	count, err := ingredientCollection.CountDocuments(ctx, bson.M{"ingredient_id": bson.M{"$in": ingredientIDs}})
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check the recipe ingredients"
+	}
+
+	if int(count) != len(ingredientIDs) {
+		return http.StatusBadRequest, "one or more of the ingredients do not exist"
+	}
+
+	return http.StatusOK, ""
+}
+
+func CreateRecipe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var recipe models.Recipe
+
+		err := c.BindJSON(&recipe)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid recipe JSON"})
+			return
+		}
+
+		err = validate.Struct(recipe)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the recipe structure failed"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		count, err := foodCollection.CountDocuments(ctx, bson.M{"food_id": recipe.FoodID})
+		if err != nil || count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "food was not found"})
+			return
+		}
+
+		// a food has a single recipe, which is edited in place
+		count, err = recipeCollection.CountDocuments(ctx, bson.M{"food_id": recipe.FoodID})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the existing recipes"})
+			return
+		}
+
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the food already has a recipe"})
+			return
+		}
+
+		code, msg := checkRecipeIngredients(ctx, recipe.Ingredients)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		recipe.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		recipe.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		recipe.ID = primitive.NewObjectID()
+		recipe.RecipeID = recipe.ID.Hex()
+
+		_, err = recipeCollection.InsertOne(ctx, recipe)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Recipe was not created in the database"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, recipe)
+	}
+}
+
+func GetRecipes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if foodId := c.Query("food_id"); foodId != "" {
+			filter["food_id"] = foodId
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		recipes := []models.Recipe{}
+		result, err := listQuery.Find(ctx, recipeCollection, filter, &recipes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the recipes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetRecipeById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		recipeId := c.Param("recipe_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var recipe models.Recipe
+		err := recipeCollection.FindOne(ctx, bson.M{"recipe_id": recipeId}).Decode(&recipe)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the recipe in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, recipe)
+	}
+}
+
+func UpdateRecipe() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var recipe models.Recipe
+
+		err := c.BindJSON(&recipe)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while binding the recipe JSON from the request body"})
+			return
+		}
+
+		err = validate.StructExcept(recipe, "FoodID")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a recipe needs at least one ingredient with a quantity"})
+			return
+		}
+
+		recipeId := c.Param("recipe_id")
+		filter := bson.M{"recipe_id": recipeId}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		code, msg := checkRecipeIngredients(ctx, recipe.Ingredients)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		recipe.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		result, err := recipeCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+			{"ingredients", recipe.Ingredients},
+			{"updated_at", recipe.UpdatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "recipe failed to update"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "recipe was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
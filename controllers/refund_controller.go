@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/gateway"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"time"
+)
+
+var refundCollection = database.Collection(database.Client, "refunds")
+
+// CreateRefund gives back part or all of a payment; without an amount the whole
+// of what is left on the payment, tip included, is refunded. Card refunds go back
+// through the payment provider before they are recorded.
+func CreateRefund() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var refund models.Refund
+
+		err := c.BindJSON(&refund)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid refund JSON"})
+			return
+		}
+
+		err = validate.Struct(refund)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a refund needs a reason"})
+			return
+		}
+
+		paymentId := c.Param("payment_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var payment models.Payment
+		err = paymentCollection.FindOne(ctx, bson.M{"payment_id": paymentId}).Decode(&payment)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment was not found"})
+			return
+		}
+
+		var invoice models.Invoice
+		err = invoiceCollection.FindOne(ctx, bson.M{"invoice_id": payment.InvoiceID}).Decode(&invoice)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invoice was not found"})
+			return
+		}
+
+		if invoice.LockedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": invoiceLockedMsg})
+			return
+		}
+
+		if payment.Status != "" && payment.Status != models.PaymentStatusCaptured {
+			msg := fmt.Sprintf("a %s payment cannot be refunded", payment.Status)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		charged := toFixed(*payment.Amount+*payment.Tip, 2)
+		refundable := toFixed(charged-payment.Refunded, 2)
+		if refundable <= 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the payment has already been refunded in full"})
+			return
+		}
+
+		amount := refundable
+		if refund.Amount != nil {
+			amount = toFixed(*refund.Amount, 2)
+		}
+		refund.Amount = &amount
+
+		if amount > refundable {
+			msg := fmt.Sprintf("the refund of %.2f is more than the %.2f left on the payment", amount, refundable)
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		// the refund is held against the payment first so two refunds at once cannot
+		// give back more than was taken
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := paymentCollection.UpdateOne(ctx, bson.M{
+			"payment_id": payment.PaymentID,
+			"refunded":   bson.M{"$in": bson.A{payment.Refunded, nil}},
+		}, bson.D{
+			{"$inc", bson.D{{"refunded", amount}}},
+			{"$set", bson.D{{"updated_at", updatedAt}}},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund the payment"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "the payment was refunded at the same time, check what is left and try again"})
+			return
+		}
+
+		refund.Method = *payment.Method
+		if payment.ProviderTransactionID != nil {
+			provider := gateway.Default
+			name := provider.Name()
+			refund.Provider = &name
+			refund.ProviderTransactionID = payment.ProviderTransactionID
+
+			providerCtx, providerCancel := context.WithTimeout(ctx, paymentProviderTimeout)
+			_, err = provider.Refund(providerCtx, *payment.ProviderTransactionID, amount)
+			providerCancel()
+
+			if err != nil {
+				_, _ = paymentCollection.UpdateOne(ctx, bson.M{"payment_id": payment.PaymentID}, bson.D{
+					{"$inc", bson.D{{"refunded", -amount}}},
+				})
+
+				var decline *gateway.DeclineError
+				switch {
+				case errors.As(err, &decline):
+					c.JSON(http.StatusPaymentRequired, gin.H{"error": "the refund was declined: " + decline.Reason})
+				case errors.Is(err, gateway.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+					c.JSON(http.StatusGatewayTimeout, gin.H{"error": "the payment provider timed out"})
+				default:
+					c.JSON(http.StatusBadGateway, gin.H{"error": "the payment provider could not refund the payment"})
+				}
+				return
+			}
+		}
+
+		payment.Refunded = toFixed(payment.Refunded+amount, 2)
+		if payment.Refunded >= charged {
+			_, err = paymentCollection.UpdateOne(ctx, bson.M{"payment_id": payment.PaymentID}, bson.D{{"$set", bson.D{
+				{"status", models.PaymentStatusRefunded},
+			}}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark the payment as refunded"})
+				return
+			}
+			payment.Status = models.PaymentStatusRefunded
+		}
+
+		refund.PaymentID = payment.PaymentID
+		refund.InvoiceID = payment.InvoiceID
+		refund.CreatedBy = c.GetString("uid")
+		refund.CreatedAt = updatedAt
+		refund.ID = primitive.NewObjectID()
+		refund.RefundID = refund.ID.Hex()
+
+		_, err = refundCollection.InsertOne(ctx, refund)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Refund was not created in the database"})
+			return
+		}
+
+		recordAudit(ctx, models.AuditEntry{
+			Action:    models.AuditPaymentRefunded,
+			InvoiceID: refund.InvoiceID,
+			PaymentID: &refund.PaymentID,
+			RefundID:  &refund.RefundID,
+			Amount:    -amount,
+			Reason:    refund.Reason,
+			UserID:    refund.CreatedBy,
+		})
+
+		invoice, err = recalculateInvoice(ctx, payment.InvoiceID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the invoice"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"refund": refund, "payment": payment, "invoice": invoice})
+	}
+}
+
+func GetPaymentRefunds() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		paymentId := c.Param("payment_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		opt := options.Find().SetSort(bson.D{{"_id", 1}})
+		cursor, err := refundCollection.Find(ctx, bson.M{"payment_id": paymentId}, opt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the refunds"})
+			return
+		}
+
+		refunds := []models.Refund{}
+		if err = cursor.All(ctx, &refunds); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the refunds"})
+			return
+		}
+
+		c.JSON(http.StatusOK, refunds)
+	}
+}
+
+func GetRefundById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		refundId := c.Param("refund_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var refund models.Refund
+		err := refundCollection.FindOne(ctx, bson.M{"refund_id": refundId}).Decode(&refund)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the refund in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, refund)
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLockedInvoicesCannotBeRefunded(t *testing.T) {
+	ctx := testDatabase(t)
+
+	now := time.Now()
+	invoice := newInvoice(primitive.NewObjectID().Hex(), models.InvoiceStatusPaid)
+	invoice.Total, invoice.AmountPaid, invoice.PaidAt, invoice.LockedAt = 20, 20, &now, &now
+
+	method, amount, tip := models.PaymentMethodCash, 20.0, 0.0
+	payment := models.Payment{ID: primitive.NewObjectID(), InvoiceID: invoice.InvoiceID, Method: &method, Amount: &amount, Tip: &tip, Status: models.PaymentStatusCaptured, CreatedAt: now, UpdatedAt: now}
+	payment.PaymentID = payment.ID.Hex()
+
+	fixture(t, ctx, invoiceCollection, invoice)
+	fixture(t, ctx, paymentCollection, payment)
+	cleanup(t, ctx, refundCollection, bson.M{"payment_id": payment.PaymentID})
+
+	recorder := serve(withParam(CreateRefund(), "payment_id", payment.PaymentID), http.MethodPost, "/payments/"+payment.PaymentID+"/refunds", gin.H{"reason": "cold soup"}, "")
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("refunding a payment on a locked invoice: got %d, want %d", recorder.Code, http.StatusConflict)
+	}
+
+	var stored models.Payment
+	if err := paymentCollection.FindOne(ctx, bson.M{"payment_id": payment.PaymentID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Refunded != 0 {
+		t.Errorf("%v was refunded on a locked invoice", stored.Refunded)
+	}
+}
+
+func TestPartlyRefundedInvoiceStaysSettled(t *testing.T) {
+	ctx := testDatabase(t)
+
+	table := newTable()
+	order := newOrder(&table.TableID)
+	invoice := newInvoice(order.OrderID, models.InvoiceStatusPending)
+	fixture(t, ctx, tableCollection, table)
+	fixture(t, ctx, orderCollection, order)
+	fixture(t, ctx, orderItemsCollection, newOrderItem(order.OrderID, primitive.NewObjectID().Hex(), 20))
+	fixture(t, ctx, invoiceCollection, invoice)
+	cleanup(t, ctx, paymentCollection, bson.M{"invoice_id": invoice.InvoiceID})
+	cleanup(t, ctx, refundCollection, bson.M{"invoice_id": invoice.InvoiceID})
+	cleanup(t, ctx, auditCollection, bson.M{"invoice_id": invoice.InvoiceID})
+
+	pay := func() *httptest.ResponseRecorder {
+		handler := withParam(CreatePayment(), "invoice_id", invoice.InvoiceID)
+		return serve(handler, http.MethodPost, "/invoices/"+invoice.InvoiceID+"/payments", gin.H{"method": models.PaymentMethodCash, "amount": 20}, "")
+	}
+
+	recorder := pay()
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("paying the invoice: got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var paid struct {
+		Payment models.Payment `json:"payment"`
+		Invoice models.Invoice `json:"invoice"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &paid); err != nil {
+		t.Fatal(err)
+	}
+	if *paid.Invoice.PaymentStatus != models.InvoiceStatusPaid {
+		t.Fatalf("the invoice is %s after paying it in full", *paid.Invoice.PaymentStatus)
+	}
+
+	var closed models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": order.OrderID}).Decode(&closed); err != nil {
+		t.Fatal(err)
+	}
+	if *closed.Status != models.OrderStatusClosed {
+		t.Fatalf("paying the invoice left the order %s", *closed.Status)
+	}
+
+	refundPath := "/payments/" + paid.Payment.PaymentID + "/refunds"
+	recorder = serve(withParam(CreateRefund(), "payment_id", paid.Payment.PaymentID), http.MethodPost, refundPath, gin.H{"reason": "cold soup", "amount": 5}, "")
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("refunding part of the payment: got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var refunded models.Invoice
+	if err := invoiceCollection.FindOne(ctx, bson.M{"invoice_id": invoice.InvoiceID}).Decode(&refunded); err != nil {
+		t.Fatal(err)
+	}
+	if *refunded.PaymentStatus != models.InvoiceStatusPartiallyRefunded || refunded.BalanceDue != 0 || refunded.RefundTotal != 5 {
+		t.Errorf("got %s with %v due and %v refunded, want PARTIALLY_REFUNDED with nothing due and 5 refunded",
+			*refunded.PaymentStatus, refunded.BalanceDue, refunded.RefundTotal)
+	}
+
+	// the refund does not open the invoice up for another payment
+	if recorder = pay(); recorder.Code != http.StatusConflict {
+		t.Errorf("paying a partly refunded invoice: got %d, want %d", recorder.Code, http.StatusConflict)
+	}
+
+	var reclosed models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": order.OrderID}).Decode(&reclosed); err != nil {
+		t.Fatal(err)
+	}
+	if !reclosed.ClosedAt.Equal(*closed.ClosedAt) {
+		t.Errorf("the order was closed again at %v after the refund", reclosed.ClosedAt)
+	}
+}
@@ -0,0 +1,950 @@
+package controllers
+
+import (
+	"context"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	menuClassStar      = "STAR"
+	menuClassPlowhorse = "PLOWHORSE"
+	menuClassPuzzle    = "PUZZLE"
+	menuClassDog       = "DOG"
+	// menuClassUncosted marks a dish without a full recipe cost, which has no margin to rank
+	menuClassUncosted = "UNCOSTED"
+
+	// popularityFactor is the share of an even split of sales a dish needs to count as popular
+	popularityFactor = 0.7
+	// defaultReportDays is how far back a report looks when no range is given
+	defaultReportDays = 30
+)
+
+type FoodCostFormat struct {
+	FoodID           string  `json:"food_id"`
+	Name             string  `json:"name"`
+	MenuID           string  `json:"menu_id"`
+	Price            float64 `json:"price"`
+	FoodCost         float64 `json:"food_cost"`
+	FoodCostPercent  float64 `json:"food_cost_percentage"`
+	Margin           float64 `json:"margin"`
+	MarginPercentage float64 `json:"margin_percentage"`
+	Costed           bool    `json:"costed"`
+}
+
+type MenuEngineeringFormat struct {
+	FoodCostFormat
+	Sold              int     `json:"sold"`
+	Revenue           float64 `json:"revenue"`
+	TotalMargin       float64 `json:"total_margin"`
+	PopularityPercent float64 `json:"popularity_percentage"`
+	Classification    string  `json:"classification"`
+}
+
+type TaxSummaryFormat struct {
+	Category string  `json:"category"`
+	Name     string  `json:"name"`
+	Rate     float64 `json:"rate"`
+	Invoices int     `json:"invoices"`
+	Taxable  float64 `json:"taxable"`
+	Tax      float64 `json:"tax"`
+}
+
+type SalesPeriodFormat struct {
+	Period        string  `json:"period"`
+	Invoices      int     `json:"invoices"`
+	Covers        int     `json:"covers"`
+	Subtotal      float64 `json:"subtotal"`
+	Discounts     float64 `json:"discounts"`
+	Tax           float64 `json:"tax"`
+	Revenue       float64 `json:"revenue"`
+	Tips          float64 `json:"tips"`
+	AverageTicket float64 `json:"average_ticket"`
+}
+
+type FoodSalesFormat struct {
+	FoodID  string  `json:"food_id"`
+	Name    string  `json:"name"`
+	Sold    int     `json:"sold"`
+	Revenue float64 `json:"revenue"`
+}
+
+type CategorySalesFormat struct {
+	Category     string  `json:"category"`
+	Sold         int     `json:"sold"`
+	Revenue      float64 `json:"revenue"`
+	RevenueShare float64 `json:"revenue_percentage"`
+}
+
+type TableCoversFormat struct {
+	TableID         string  `json:"table_id"`
+	TableNumber     int     `json:"table_number"`
+	Invoices        int     `json:"invoices"`
+	Covers          int     `json:"covers"`
+	Revenue         float64 `json:"revenue"`
+	RevenuePerCover float64 `json:"revenue_per_cover"`
+}
+
+type ServerSalesFormat struct {
+	ServerID      string  `json:"server_id"`
+	Name          string  `json:"name"`
+	Invoices      int     `json:"invoices"`
+	Covers        int     `json:"covers"`
+	Revenue       float64 `json:"revenue"`
+	Tips          float64 `json:"tips"`
+	AverageTicket float64 `json:"average_ticket"`
+}
+
+// reportTimezone reads the IANA timezone a report's dates are in, defaulting to UTC
+func reportTimezone(c *gin.Context) (*time.Location, string) {
+	if c.Query("timezone") == "" {
+		return time.UTC, ""
+	}
+
+	location, err := time.LoadLocation(c.Query("timezone"))
+	if err != nil {
+		return time.UTC, "timezone must be an IANA timezone such as Europe/London"
+	}
+
+	return location, ""
+}
+
+// reportRange reads the from and to dates of a report, both inclusive, defaulting to
+// the last 30 days. The days run midnight to midnight in the report's timezone.
+func reportRange(c *gin.Context) (time.Time, time.Time, string) {
+	location, msg := reportTimezone(c)
+
+	today, _ := time.ParseInLocation("2006-01-02", time.Now().In(location).Format("2006-01-02"), location)
+	from := today.AddDate(0, 0, -defaultReportDays+1)
+	to := today.AddDate(0, 0, 1)
+
+	if msg != "" {
+		return from, to, msg
+	}
+
+	if c.Query("from") != "" {
+		day, err := time.ParseInLocation("2006-01-02", c.Query("from"), location)
+		if err != nil {
+			return from, to, "from must be in the format YYYY-MM-DD"
+		}
+		from = day
+	}
+
+	if c.Query("to") != "" {
+		day, err := time.ParseInLocation("2006-01-02", c.Query("to"), location)
+		if err != nil {
+			return from, to, "to must be in the format YYYY-MM-DD"
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+
+	if !to.After(from) {
+		return from, to, "to cannot be before from"
+	}
+
+	return from, to, ""
+}
+
+// foodCosts works out the theoretical cost of a portion of each food from its
+// recipe and the current ingredient costs. A food is only fully costed when it
+// has a recipe and every ingredient in it has a cost.
+func foodCosts(ctx context.Context, foods []models.Food) ([]FoodCostFormat, error) {
+	var foodIDs []string
+	for _, food := range foods {
+		foodIDs = append(foodIDs, food.FoodID)
+	}
+
+	cursor, err := recipeCollection.Find(ctx, bson.M{"food_id": bson.M{"$in": foodIDs}})
+	if err != nil {
+		return nil, err
+	}
+
+	var recipes []models.Recipe
+	if err = cursor.All(ctx, &recipes); err != nil {
+		return nil, err
+	}
+
+	cursor, err = ingredientCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var ingredients []models.Ingredient
+	if err = cursor.All(ctx, &ingredients); err != nil {
+		return nil, err
+	}
+
+	unitCosts := make(map[string]*float64)
+	for _, ingredient := range ingredients {
+		unitCosts[ingredient.IngredientID] = ingredient.UnitCost
+	}
+
+	recipeByFood := make(map[string]models.Recipe)
+	for _, recipe := range recipes {
+		recipeByFood[*recipe.FoodID] = recipe
+	}
+
+	costs := []FoodCostFormat{}
+	for _, food := range foods {
+		cost := FoodCostFormat{
+			FoodID: food.FoodID,
+			Name:   *food.Name,
+			MenuID: *food.MenuID,
+			Price:  *food.Price,
+		}
+
+		recipe, ok := recipeByFood[food.FoodID]
+		cost.Costed = ok
+		for _, recipeIngredient := range recipe.Ingredients {
+			unitCost := unitCosts[*recipeIngredient.IngredientID]
+			if unitCost == nil {
+				cost.Costed = false
+				continue
+			}
+			cost.FoodCost += *recipeIngredient.Quantity * *unitCost
+		}
+
+		// a partial cost would overstate the margin, so only the known cost is shown
+		cost.FoodCost = toFixed(cost.FoodCost, 2)
+		if !cost.Costed {
+			costs = append(costs, cost)
+			continue
+		}
+
+		cost.Margin = toFixed(cost.Price-cost.FoodCost, 2)
+		if cost.Price > 0 {
+			cost.FoodCostPercent = toFixed(cost.FoodCost/cost.Price*100, 2)
+			cost.MarginPercentage = toFixed(cost.Margin/cost.Price*100, 2)
+		}
+
+		costs = append(costs, cost)
+	}
+
+	return costs, nil
+}
+
+// reportFoods lists the foods still on the menus, optionally for a single menu
+func reportFoods(ctx context.Context, menuID string) ([]models.Food, error) {
+	filter := bson.M{"archived": bson.M{"$ne": true}}
+	if menuID != "" {
+		filter["menu_id"] = menuID
+	}
+
+	cursor, err := foodCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var foods []models.Food
+	if err = cursor.All(ctx, &foods); err != nil {
+		return nil, err
+	}
+
+	return foods, nil
+}
+
+// GetFoodCostReport lists the theoretical food cost and margin of every dish
+func GetFoodCostReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foods, err := reportFoods(ctx, c.Query("menu_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the foods from the database"})
+			return
+		}
+
+		costs, err := foodCosts(ctx, foods)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to work out the food costs"})
+			return
+		}
+
+		sort.Slice(costs, func(i, j int) bool {
+			return costs[i].FoodCostPercent > costs[j].FoodCostPercent
+		})
+
+		c.JSON(http.StatusOK, costs)
+	}
+}
+
+// classifyMenu sorts the dishes into stars, plowhorses, puzzles and dogs. Every
+// dish counts towards popularity, but only the fully costed ones set the average
+// margin; the others are classified as uncosted.
+func classifyMenu(report []MenuEngineeringFormat) (int, float64, float64) {
+	totalSold := 0
+	costedSold := 0
+	totalMargin := 0.0
+	for _, row := range report {
+		totalSold += row.Sold
+		if row.Costed {
+			costedSold += row.Sold
+			totalMargin += row.TotalMargin
+		}
+	}
+
+	popularityThreshold := 0.0
+	if len(report) > 0 && totalSold > 0 {
+		popularityThreshold = float64(totalSold) / float64(len(report)) * popularityFactor
+	}
+
+	averageMargin := 0.0
+	if costedSold > 0 {
+		averageMargin = totalMargin / float64(costedSold)
+	}
+
+	for i, row := range report {
+		if totalSold > 0 {
+			report[i].PopularityPercent = toFixed(float64(row.Sold)/float64(totalSold)*100, 2)
+		}
+
+		popular := totalSold > 0 && float64(row.Sold) >= popularityThreshold
+		profitable := row.Margin >= averageMargin
+
+		switch {
+		case !row.Costed:
+			report[i].Classification = menuClassUncosted
+		case popular && profitable:
+			report[i].Classification = menuClassStar
+		case popular:
+			report[i].Classification = menuClassPlowhorse
+		case profitable:
+			report[i].Classification = menuClassPuzzle
+		default:
+			report[i].Classification = menuClassDog
+		}
+	}
+
+	return totalSold, popularityThreshold, averageMargin
+}
+
+// GetMenuEngineeringReport classifies each dish by popularity and contribution
+// margin over the date range. A dish is popular when it sells at least 70% of an
+// even share of the items sold, and profitable when its margin is at least the
+// average margin per costed item sold.
+func GetMenuEngineeringReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, msg := reportRange(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foods, err := reportFoods(ctx, c.Query("menu_id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the foods from the database"})
+			return
+		}
+
+		costs, err := foodCosts(ctx, foods)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to work out the food costs"})
+			return
+		}
+
+		var foodIDs []string
+		for _, food := range foods {
+			foodIDs = append(foodIDs, food.FoodID)
+		}
+
+		matchStage := bson.D{{"$match", bson.D{
+			{"food_id", bson.D{{"$in", foodIDs}}},
+			{"created_at", bson.D{{"$gte", from}, {"$lt", to}}},
+		}}}
+		groupStage := bson.D{{"$group", bson.D{
+			{"_id", "$food_id"},
+			{"sold", bson.D{{"$sum", 1}}},
+			{"revenue", bson.D{{"$sum", "$unit_price"}}},
+		}}}
+
+		cursor, err := orderItemsCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count the dishes sold"})
+			return
+		}
+
+		var sales []struct {
+			FoodID  string  `bson:"_id"`
+			Sold    int     `bson:"sold"`
+			Revenue float64 `bson:"revenue"`
+		}
+		if err = cursor.All(ctx, &sales); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the dishes sold"})
+			return
+		}
+
+		sold := make(map[string]int)
+		revenue := make(map[string]float64)
+		for _, sale := range sales {
+			sold[sale.FoodID] = sale.Sold
+			revenue[sale.FoodID] = sale.Revenue
+		}
+
+		report := []MenuEngineeringFormat{}
+		for _, cost := range costs {
+			row := MenuEngineeringFormat{
+				FoodCostFormat: cost,
+				Sold:           sold[cost.FoodID],
+				Revenue:        toFixed(revenue[cost.FoodID], 2),
+			}
+			row.TotalMargin = toFixed(float64(row.Sold)*cost.Margin, 2)
+			report = append(report, row)
+		}
+
+		totalSold, popularityThreshold, averageMargin := classifyMenu(report)
+
+		sort.Slice(report, func(i, j int) bool {
+			return report[i].TotalMargin > report[j].TotalMargin
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":                 from,
+			"to":                   to.AddDate(0, 0, -1),
+			"items_sold":           totalSold,
+			"average_margin":       toFixed(averageMargin, 2),
+			"popularity_threshold": toFixed(popularityThreshold, 2),
+			"foods":                report,
+		})
+	}
+}
+
+// settledInvoices matches the invoices settled over the date range. An invoice
+// counts once it has been paid in full, on the day it was paid; voided and fully
+// refunded invoices are left out.
+func settledInvoices(from time.Time, to time.Time) bson.D {
+	return bson.D{
+		{"paid_at", bson.D{{"$gte", from}, {"$lt", to}}},
+		{"payment_status", bson.D{{"$nin", bson.A{models.InvoiceStatusVoid, models.InvoiceStatusRefunded}}}},
+	}
+}
+
+// keptShare is the part of an invoice's total the restaurant kept after refunds,
+// from 0 to 1, used to take refunds off the amounts on the invoice
+var keptShare = bson.D{{"$cond", bson.A{
+	bson.D{{"$gt", bson.A{"$total", 0}}},
+	bson.D{{"$divide", bson.A{bson.D{{"$min", bson.A{"$amount_paid", "$total"}}}, "$total"}}},
+	0,
+}}}
+
+// GetTaxReport sums the tax collected per category and rate on the invoices
+// settled over the date range, net of any refunds given on them
+func GetTaxReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, msg := reportRange(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		matchStage := bson.D{{"$match", settledInvoices(from, to)}}
+		shareStage := bson.D{{"$addFields", bson.D{{"kept_share", keptShare}}}}
+		unwindStage := bson.D{{"$unwind", "$taxes"}}
+		groupStage := bson.D{{"$group", bson.D{
+			{"_id", bson.D{{"category", "$taxes.category"}, {"rate", "$taxes.rate"}}},
+			{"name", bson.D{{"$last", "$taxes.name"}}},
+			{"invoices", bson.D{{"$sum", 1}}},
+			{"taxable", bson.D{{"$sum", bson.D{{"$multiply", bson.A{"$taxes.taxable", "$kept_share"}}}}}},
+			{"tax", bson.D{{"$sum", bson.D{{"$multiply", bson.A{"$taxes.tax", "$kept_share"}}}}}},
+		}}}
+
+		cursor, err := invoiceCollection.Aggregate(ctx, mongo.Pipeline{matchStage, shareStage, unwindStage, groupStage})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add up the tax"})
+			return
+		}
+
+		var rows []struct {
+			ID struct {
+				Category string  `bson:"category"`
+				Rate     float64 `bson:"rate"`
+			} `bson:"_id"`
+			Name     string  `bson:"name"`
+			Invoices int     `bson:"invoices"`
+			Taxable  float64 `bson:"taxable"`
+			Tax      float64 `bson:"tax"`
+		}
+		if err = cursor.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the tax"})
+			return
+		}
+
+		totalTaxable := 0.0
+		totalTax := 0.0
+		report := []TaxSummaryFormat{}
+		for _, row := range rows {
+			report = append(report, TaxSummaryFormat{
+				Category: row.ID.Category,
+				Name:     row.Name,
+				Rate:     row.ID.Rate,
+				Invoices: row.Invoices,
+				Taxable:  toFixed(row.Taxable, 2),
+				Tax:      toFixed(row.Tax, 2),
+			})
+			totalTaxable += row.Taxable
+			totalTax += row.Tax
+		}
+
+		sort.Slice(report, func(i, j int) bool {
+			if report[i].Category != report[j].Category {
+				return report[i].Category < report[j].Category
+			}
+			return report[i].Rate < report[j].Rate
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":          from,
+			"to":            to.AddDate(0, 0, -1),
+			"total_taxable": toFixed(totalTaxable, 2),
+			"total_tax":     toFixed(totalTax, 2),
+			"taxes":         report,
+		})
+	}
+}
+
+// salesMatch picks the invoices settled over the date range, the same ones the
+// tax report counts
+func salesMatch(from time.Time, to time.Time) bson.D {
+	return bson.D{{"$match", settledInvoices(from, to)}}
+}
+
+// salesGroup adds up the invoices for each value of id. Revenue is what was paid
+// on the invoices, less anything refunded since; tips are kept apart from it.
+func salesGroup(id interface{}) bson.D {
+	return bson.D{{"$group", bson.D{
+		{"_id", id},
+		{"invoices", bson.D{{"$sum", 1}}},
+		{"covers", bson.D{{"$sum", "$covers"}}},
+		{"subtotal", bson.D{{"$sum", "$subtotal"}}},
+		{"discounts", bson.D{{"$sum", "$discount_total"}}},
+		{"tax", bson.D{{"$sum", "$tax_total"}}},
+		{"revenue", bson.D{{"$sum", "$amount_paid"}}},
+		{"tips", bson.D{{"$sum", "$tip_total"}}},
+	}}}
+}
+
+type salesRow struct {
+	Invoices  int     `bson:"invoices"`
+	Covers    int     `bson:"covers"`
+	Subtotal  float64 `bson:"subtotal"`
+	Discounts float64 `bson:"discounts"`
+	Tax       float64 `bson:"tax"`
+	Revenue   float64 `bson:"revenue"`
+	Tips      float64 `bson:"tips"`
+}
+
+// averageTicket is the revenue per invoice
+func averageTicket(revenue float64, invoices int) float64 {
+	if invoices == 0 {
+		return 0
+	}
+	return toFixed(revenue/float64(invoices), 2)
+}
+
+// reportLimit reads how many rows a ranking report returns, 10 unless limit is set
+func reportLimit(c *gin.Context) (int, string) {
+	if c.Query("limit") == "" {
+		return 10, ""
+	}
+
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 || limit > 100 {
+		return 0, "limit must be a number from 1 to 100"
+	}
+
+	return limit, ""
+}
+
+// GetSalesReport adds up the sales per day, or per hour of the day with
+// group_by=hour, in the report's timezone, with the average ticket size. An
+// invoice counts in the period it was paid in.
+func GetSalesReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, msg := reportRange(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		groupBy := c.DefaultQuery("group_by", "day")
+		format, ok := map[string]string{"day": "%Y-%m-%d", "hour": "%H:00"}[groupBy]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be day or hour"})
+			return
+		}
+
+		location, _ := reportTimezone(c)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		period := bson.D{{"$dateToString", bson.D{
+			{"format", format},
+			{"date", "$paid_at"},
+			{"timezone", location.String()},
+		}}}
+		sortStage := bson.D{{"$sort", bson.D{{"_id", 1}}}}
+
+		cursor, err := invoiceCollection.Aggregate(ctx, mongo.Pipeline{salesMatch(from, to), salesGroup(period), sortStage})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add up the sales"})
+			return
+		}
+
+		var rows []struct {
+			Period string   `bson:"_id"`
+			Sales  salesRow `bson:",inline"`
+		}
+		if err = cursor.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the sales"})
+			return
+		}
+
+		var total salesRow
+		report := []SalesPeriodFormat{}
+		for _, row := range rows {
+			report = append(report, SalesPeriodFormat{
+				Period:        row.Period,
+				Invoices:      row.Sales.Invoices,
+				Covers:        row.Sales.Covers,
+				Subtotal:      toFixed(row.Sales.Subtotal, 2),
+				Discounts:     toFixed(row.Sales.Discounts, 2),
+				Tax:           toFixed(row.Sales.Tax, 2),
+				Revenue:       toFixed(row.Sales.Revenue, 2),
+				Tips:          toFixed(row.Sales.Tips, 2),
+				AverageTicket: averageTicket(row.Sales.Revenue, row.Sales.Invoices),
+			})
+			total.Invoices += row.Sales.Invoices
+			total.Covers += row.Sales.Covers
+			total.Revenue += row.Sales.Revenue
+			total.Tips += row.Sales.Tips
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":           from,
+			"to":             to.AddDate(0, 0, -1),
+			"timezone":       location.String(),
+			"group_by":       groupBy,
+			"invoices":       total.Invoices,
+			"covers":         total.Covers,
+			"revenue":        toFixed(total.Revenue, 2),
+			"tips":           toFixed(total.Tips, 2),
+			"average_ticket": averageTicket(total.Revenue, total.Invoices),
+			"periods":        report,
+		})
+	}
+}
+
+// GetTopFoodsReport ranks the foods by how many were sold over the date range,
+// at menu price as in the menu engineering report
+func GetTopFoodsReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, msg := reportRange(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		limit, msg := reportLimit(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		matchStage := bson.D{{"$match", bson.D{{"created_at", bson.D{{"$gte", from}, {"$lt", to}}}}}}
+		groupStage := bson.D{{"$group", bson.D{
+			{"_id", "$food_id"},
+			{"sold", bson.D{{"$sum", 1}}},
+			{"revenue", bson.D{{"$sum", "$unit_price"}}},
+		}}}
+		sortStage := bson.D{{"$sort", bson.D{{"sold", -1}, {"revenue", -1}}}}
+		limitStage := bson.D{{"$limit", limit}}
+		lookupStage := bson.D{{"$lookup", bson.D{
+			{"from", foodCollection.Name()},
+			{"localField", "_id"},
+			{"foreignField", "food_id"},
+			{"as", "food"},
+		}}}
+		projectStage := bson.D{{"$project", bson.D{
+			{"sold", 1},
+			{"revenue", 1},
+			{"name", bson.D{{"$arrayElemAt", bson.A{"$food.name", 0}}}},
+		}}}
+
+		cursor, err := orderItemsCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, sortStage, limitStage, lookupStage, projectStage})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count the foods sold"})
+			return
+		}
+
+		var rows []struct {
+			FoodID  string  `bson:"_id"`
+			Name    string  `bson:"name"`
+			Sold    int     `bson:"sold"`
+			Revenue float64 `bson:"revenue"`
+		}
+		if err = cursor.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the foods sold"})
+			return
+		}
+
+		report := []FoodSalesFormat{}
+		for _, row := range rows {
+			report = append(report, FoodSalesFormat{
+				FoodID:  row.FoodID,
+				Name:    row.Name,
+				Sold:    row.Sold,
+				Revenue: toFixed(row.Revenue, 2),
+			})
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":  from,
+			"to":    to.AddDate(0, 0, -1),
+			"foods": report,
+		})
+	}
+}
+
+// GetCategorySalesReport adds up the items sold over the date range by the
+// category of the menu they are on
+func GetCategorySalesReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, msg := reportRange(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		matchStage := bson.D{{"$match", bson.D{{"created_at", bson.D{{"$gte", from}, {"$lt", to}}}}}}
+		foodStage := bson.D{{"$lookup", bson.D{
+			{"from", foodCollection.Name()},
+			{"localField", "food_id"},
+			{"foreignField", "food_id"},
+			{"as", "food"},
+		}}}
+		menuStage := bson.D{{"$lookup", bson.D{
+			{"from", menuCollection.Name()},
+			{"localField", "food.menu_id"},
+			{"foreignField", "menu_id"},
+			{"as", "menu"},
+		}}}
+		groupStage := bson.D{{"$group", bson.D{
+			{"_id", bson.D{{"$ifNull", bson.A{bson.D{{"$arrayElemAt", bson.A{"$menu.category", 0}}}, "Uncategorised"}}}},
+			{"sold", bson.D{{"$sum", 1}}},
+			{"revenue", bson.D{{"$sum", "$unit_price"}}},
+		}}}
+		sortStage := bson.D{{"$sort", bson.D{{"revenue", -1}}}}
+
+		cursor, err := orderItemsCollection.Aggregate(ctx, mongo.Pipeline{matchStage, foodStage, menuStage, groupStage, sortStage})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add up the sales by category"})
+			return
+		}
+
+		var rows []struct {
+			Category string  `bson:"_id"`
+			Sold     int     `bson:"sold"`
+			Revenue  float64 `bson:"revenue"`
+		}
+		if err = cursor.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the sales by category"})
+			return
+		}
+
+		totalRevenue := 0.0
+		for _, row := range rows {
+			totalRevenue += row.Revenue
+		}
+
+		report := []CategorySalesFormat{}
+		for _, row := range rows {
+			category := CategorySalesFormat{
+				Category: row.Category,
+				Sold:     row.Sold,
+				Revenue:  toFixed(row.Revenue, 2),
+			}
+			if totalRevenue > 0 {
+				category.RevenueShare = toFixed(row.Revenue/totalRevenue*100, 2)
+			}
+			report = append(report, category)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":       from,
+			"to":         to.AddDate(0, 0, -1),
+			"revenue":    toFixed(totalRevenue, 2),
+			"categories": report,
+		})
+	}
+}
+
+// GetCoversReport adds up the guests served and the revenue per cover at each table
+func GetCoversReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, msg := reportRange(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		orderStage := bson.D{{"$lookup", bson.D{
+			{"from", orderCollection.Name()},
+			{"localField", "order_id"},
+			{"foreignField", "order_id"},
+			{"as", "order"},
+		}}}
+		unwindStage := bson.D{{"$unwind", "$order"}}
+		tableStage := bson.D{{"$lookup", bson.D{
+			{"from", tableCollection.Name()},
+			{"localField", "_id"},
+			{"foreignField", "table_id"},
+			{"as", "table"},
+		}}}
+		projectStage := bson.D{{"$addFields", bson.D{{"table_number", bson.D{{"$arrayElemAt", bson.A{"$table.table_number", 0}}}}}}}
+
+		cursor, err := invoiceCollection.Aggregate(ctx, mongo.Pipeline{
+			salesMatch(from, to), orderStage, unwindStage, salesGroup("$order.table_id"), tableStage, projectStage,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count the covers"})
+			return
+		}
+
+		var rows []struct {
+			TableID     string   `bson:"_id"`
+			TableNumber int      `bson:"table_number"`
+			Sales       salesRow `bson:",inline"`
+		}
+		if err = cursor.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the covers"})
+			return
+		}
+
+		totalCovers := 0
+		report := []TableCoversFormat{}
+		for _, row := range rows {
+			table := TableCoversFormat{
+				TableID:     row.TableID,
+				TableNumber: row.TableNumber,
+				Invoices:    row.Sales.Invoices,
+				Covers:      row.Sales.Covers,
+				Revenue:     toFixed(row.Sales.Revenue, 2),
+			}
+			if row.Sales.Covers > 0 {
+				table.RevenuePerCover = toFixed(row.Sales.Revenue/float64(row.Sales.Covers), 2)
+			}
+			totalCovers += row.Sales.Covers
+			report = append(report, table)
+		}
+
+		sort.Slice(report, func(i, j int) bool {
+			return report[i].TableNumber < report[j].TableNumber
+		})
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":   from,
+			"to":     to.AddDate(0, 0, -1),
+			"covers": totalCovers,
+			"tables": report,
+		})
+	}
+}
+
+// GetServerSalesReport adds up the sales and tips of each server over the date
+// range; an order counts for the server it was with when it was billed
+func GetServerSalesReport() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, msg := reportRange(c)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		orderStage := bson.D{{"$lookup", bson.D{
+			{"from", orderCollection.Name()},
+			{"localField", "order_id"},
+			{"foreignField", "order_id"},
+			{"as", "order"},
+		}}}
+		unwindStage := bson.D{{"$unwind", "$order"}}
+		userStage := bson.D{{"$lookup", bson.D{
+			{"from", UserCollection.Name()},
+			{"localField", "_id"},
+			{"foreignField", "user_id"},
+			{"as", "server"},
+		}}}
+		projectStage := bson.D{{"$addFields", bson.D{
+			{"first_name", bson.D{{"$arrayElemAt", bson.A{"$server.first_name", 0}}}},
+			{"last_name", bson.D{{"$arrayElemAt", bson.A{"$server.last_name", 0}}}},
+		}}}
+		sortStage := bson.D{{"$sort", bson.D{{"revenue", -1}}}}
+
+		cursor, err := invoiceCollection.Aggregate(ctx, mongo.Pipeline{
+			salesMatch(from, to), orderStage, unwindStage, salesGroup("$order.server_id"), userStage, projectStage, sortStage,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add up the sales by server"})
+			return
+		}
+
+		var rows []struct {
+			ServerID  *string  `bson:"_id"`
+			FirstName string   `bson:"first_name"`
+			LastName  string   `bson:"last_name"`
+			Sales     salesRow `bson:",inline"`
+		}
+		if err = cursor.All(ctx, &rows); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the sales by server"})
+			return
+		}
+
+		report := []ServerSalesFormat{}
+		for _, row := range rows {
+			server := ServerSalesFormat{
+				Name:          strings.TrimSpace(row.FirstName + " " + row.LastName),
+				Invoices:      row.Sales.Invoices,
+				Covers:        row.Sales.Covers,
+				Revenue:       toFixed(row.Sales.Revenue, 2),
+				Tips:          toFixed(row.Sales.Tips, 2),
+				AverageTicket: averageTicket(row.Sales.Revenue, row.Sales.Invoices),
+			}
+			// orders taken before servers were assigned have no server
+			if row.ServerID != nil {
+				server.ServerID = *row.ServerID
+			}
+			report = append(report, server)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"from":    from,
+			"to":      to.AddDate(0, 0, -1),
+			"servers": report,
+		})
+	}
+}
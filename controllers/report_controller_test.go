@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestClassifyMenuLeavesUncostedFoodsOut(t *testing.T) {
+	row := func(foodID string, costed bool, margin float64, sold int) MenuEngineeringFormat {
+		r := MenuEngineeringFormat{Sold: sold}
+		r.FoodID = foodID
+		r.Costed = costed
+		r.Margin = margin
+		r.TotalMargin = margin * float64(sold)
+		return r
+	}
+
+	// the uncosted dish has no margin; counting it as its full price would
+	// drag the average up and make every costed dish look unprofitable
+	report := []MenuEngineeringFormat{
+		row("steak", true, 12, 10),
+		row("salad", true, 4, 10),
+		row("soup", true, 10, 2),
+		row("special", false, 0, 10),
+	}
+
+	totalSold, threshold, averageMargin := classifyMenu(report)
+
+	if totalSold != 32 {
+		t.Errorf("totalSold = %d, want 32", totalSold)
+	}
+	if threshold != 32.0/4*popularityFactor {
+		t.Errorf("popularity threshold = %v, want %v", threshold, 32.0/4*popularityFactor)
+	}
+	if averageMargin != 180.0/22 {
+		t.Errorf("averageMargin = %v, want %v", averageMargin, 180.0/22)
+	}
+
+	want := map[string]string{
+		"steak":   menuClassStar,
+		"salad":   menuClassPlowhorse,
+		"soup":    menuClassPuzzle,
+		"special": menuClassUncosted,
+	}
+	for _, r := range report {
+		if r.Classification != want[r.FoodID] {
+			t.Errorf("%s classified as %s, want %s", r.FoodID, r.Classification, want[r.FoodID])
+		}
+	}
+}
+
+func TestTaxReportCountsSettledInvoicesNetOfRefunds(t *testing.T) {
+	ctx := testDatabase(t)
+
+	day := time.Date(2001, 2, 3, 12, 0, 0, 0, time.UTC)
+	paidAt := day
+	taxes := []models.InvoiceTax{{Category: "food", Name: "VAT", Rate: 20, Taxable: 50, Tax: 10}}
+
+	invoice := func(status string, total float64, amountPaid float64, paid bool) models.Invoice {
+		invoice := newInvoice(primitive.NewObjectID().Hex(), status)
+		invoice.Taxes, invoice.Total, invoice.AmountPaid, invoice.CreatedAt = taxes, total, amountPaid, day
+		if paid {
+			invoice.PaidAt = &paidAt
+		}
+		return invoice
+	}
+
+	invoices := []models.Invoice{
+		invoice(models.InvoiceStatusPaid, 60, 60, true),
+		// a quarter of this one was refunded after it was paid
+		invoice(models.InvoiceStatusPartiallyPaid, 60, 45, true),
+		invoice(models.InvoiceStatusRefunded, 60, 0, true),
+		invoice(models.InvoiceStatusVoid, 60, 0, false),
+		// raised that day but never settled
+		invoice(models.InvoiceStatusPending, 60, 0, false),
+		invoice(models.InvoiceStatusPartiallyPaid, 60, 30, false),
+	}
+
+	for _, invoice := range invoices {
+		fixture(t, ctx, invoiceCollection, invoice)
+	}
+
+	recorder := serve(GetTaxReport(), http.MethodGet, "/reports/tax?from=2001-02-03&to=2001-02-03", nil, "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var report struct {
+		TotalTaxable float64            `json:"total_taxable"`
+		TotalTax     float64            `json:"total_tax"`
+		Taxes        []TaxSummaryFormat `json:"taxes"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+
+	if report.TotalTaxable != 87.5 || report.TotalTax != 17.5 {
+		t.Errorf("got taxable %v and tax %v, want 87.5 and 17.5", report.TotalTaxable, report.TotalTax)
+	}
+	if len(report.Taxes) != 1 || report.Taxes[0].Invoices != 2 {
+		t.Errorf("got %+v, want one rate on two invoices", report.Taxes)
+	}
+}
+
+func TestSalesReportCountsPaymentsOnTheDayPaid(t *testing.T) {
+	ctx := testDatabase(t)
+
+	raised := time.Date(2001, 3, 3, 20, 0, 0, 0, time.UTC)
+	paidAt := time.Date(2001, 3, 4, 9, 0, 0, 0, time.UTC)
+
+	invoice := func(status string, total float64, amountPaid float64, paid bool) models.Invoice {
+		invoice := newInvoice(primitive.NewObjectID().Hex(), status)
+		invoice.Total, invoice.AmountPaid, invoice.CreatedAt = total, amountPaid, raised
+		if paid {
+			invoice.PaidAt = &paidAt
+		}
+		return invoice
+	}
+
+	invoices := []models.Invoice{
+		invoice(models.InvoiceStatusPaid, 60, 60, true),
+		// a quarter of this one was refunded after it was paid
+		invoice(models.InvoiceStatusPartiallyPaid, 60, 45, true),
+		invoice(models.InvoiceStatusRefunded, 60, 0, true),
+		invoice(models.InvoiceStatusVoid, 60, 0, false),
+		invoice(models.InvoiceStatusPending, 60, 0, false),
+		invoice(models.InvoiceStatusPartiallyPaid, 60, 30, false),
+	}
+
+	for _, invoice := range invoices {
+		fixture(t, ctx, invoiceCollection, invoice)
+	}
+
+	report := func(day string) (float64, []SalesPeriodFormat) {
+		recorder := serve(GetSalesReport(), http.MethodGet, "/reports/sales?from="+day+"&to="+day, nil, "")
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", recorder.Code, recorder.Body)
+		}
+
+		var sales struct {
+			Revenue float64             `json:"revenue"`
+			Periods []SalesPeriodFormat `json:"periods"`
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &sales); err != nil {
+			t.Fatal(err)
+		}
+		return sales.Revenue, sales.Periods
+	}
+
+	if revenue, periods := report("2001-03-03"); revenue != 0 || len(periods) != 0 {
+		t.Errorf("the day the invoices were raised: got revenue %v in %+v, want none", revenue, periods)
+	}
+
+	revenue, periods := report("2001-03-04")
+	if revenue != 105 {
+		t.Errorf("got revenue %v, want 105", revenue)
+	}
+	if len(periods) != 1 || periods[0].Period != "2001-03-04" || periods[0].Invoices != 2 {
+		t.Errorf("got %+v, want two invoices on 2001-03-04", periods)
+	}
+}
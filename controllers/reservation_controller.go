@@ -0,0 +1,700 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultReservationDuration is how long a table is held, in minutes, when no duration is given
+const defaultReservationDuration = 90
+
+// noShowGrace is how late, in minutes, a party can be before the booking is marked a no-show
+const noShowGrace = 30
+
+var reservationCollection = database.Collection(database.Client, "reservations")
+
+func reservationEnd(start time.Time, duration int) time.Time {
+	return start.Add(time.Duration(duration) * time.Minute)
+}
+
+// reservedTables lists every table a reservation holds, including each table of a booked group
+func reservedTables(reservation models.Reservation) []string {
+	if len(reservation.TableIDs) > 0 {
+		return reservation.TableIDs
+	}
+	if reservation.TableID != nil {
+		return []string{*reservation.TableID}
+	}
+	return []string{}
+}
+
+// conflictingReservations finds the live bookings on any of the tables that overlap the window
+func conflictingReservations(ctx context.Context, tableIDs []string, start, end time.Time, excludeID string) ([]models.Reservation, error) {
+	filter := bson.M{
+		"$or": []bson.M{
+			{"table_id": bson.M{"$in": tableIDs}},
+			{"table_ids": bson.M{"$in": tableIDs}},
+		},
+		"status":           bson.M{"$in": []string{models.ReservationStatusBooked, models.ReservationStatusSeated}},
+		"reservation_time": bson.M{"$lt": end},
+		"end_time":         bson.M{"$gt": start},
+	}
+	if excludeID != "" {
+		filter["reservation_id"] = bson.M{"$ne": excludeID}
+	}
+
+	cursor, err := reservationCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var reservations []models.Reservation
+	if err = cursor.All(ctx, &reservations); err != nil {
+		return nil, err
+	}
+
+	return reservations, nil
+}
+
+// availableTables lists the tables that can seat the party and are free for the whole window,
+// smallest suitable table first
+func availableTables(ctx context.Context, partySize int, start, end time.Time, excludeID string) ([]models.Table, error) {
+	cursor, err := tableCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []models.Table
+	if err = cursor.All(ctx, &tables); err != nil {
+		return nil, err
+	}
+
+	// tables pushed together are only offered as their group
+	groups, err := activeTableGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	grouped := make(map[string]bool)
+	for _, group := range groups {
+		for _, tableID := range group.TableIDs {
+			grouped[tableID] = true
+		}
+	}
+
+	var candidates []models.Table
+	var candidateIDs []string
+	for _, table := range tables {
+		if !grouped[table.TableID] && tableFits(table, partySize) {
+			candidates = append(candidates, table)
+			candidateIDs = append(candidateIDs, table.TableID)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return candidates, nil
+	}
+
+	booked := make(map[string]bool)
+	conflicts, err := conflictingReservations(ctx, candidateIDs, start, end, excludeID)
+	if err != nil {
+		return nil, err
+	}
+	for _, reservation := range conflicts {
+		for _, tableID := range reservedTables(reservation) {
+			booked[tableID] = true
+		}
+	}
+
+	// tables occupied right now cannot be offered for a window that has already started
+	var states map[string]tableState
+	if !start.After(time.Now()) {
+		states, err = tableStates(ctx, candidates)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	available := []models.Table{}
+	for _, table := range candidates {
+		if booked[table.TableID] {
+			continue
+		}
+		if states != nil && states[table.TableID].Status != models.TableStatusFree {
+			continue
+		}
+		available = append(available, table)
+	}
+
+	sort.SliceStable(available, func(i, j int) bool {
+		return tableCapacity(available[i]) < tableCapacity(available[j])
+	})
+
+	return available, nil
+}
+
+// availableTableGroups lists the active table groups that can seat the party
+// and have every table free for the whole window, smallest group first
+func availableTableGroups(ctx context.Context, partySize int, start, end time.Time, excludeID string) ([]models.TableGroup, error) {
+	groups, err := activeTableGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	available := []models.TableGroup{}
+	for _, group := range groups {
+		if group.Capacity < partySize {
+			continue
+		}
+
+		conflicts, err := conflictingReservations(ctx, group.TableIDs, start, end, excludeID)
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) > 0 {
+			continue
+		}
+
+		if !start.After(time.Now()) {
+			cursor, err := tableCollection.Find(ctx, bson.M{"table_id": bson.M{"$in": group.TableIDs}})
+			if err != nil {
+				return nil, err
+			}
+
+			var tables []models.Table
+			if err = cursor.All(ctx, &tables); err != nil {
+				return nil, err
+			}
+
+			states, err := tableStates(ctx, tables)
+			if err != nil {
+				return nil, err
+			}
+
+			free := true
+			for _, state := range states {
+				if state.Status != models.TableStatusFree {
+					free = false
+				}
+			}
+			if !free {
+				continue
+			}
+		}
+
+		available = append(available, group)
+	}
+
+	sort.SliceStable(available, func(i, j int) bool {
+		return available[i].Capacity < available[j].Capacity
+	})
+
+	return available, nil
+}
+
+// assignReservationTable checks the requested table, or picks the best free one, for the reservation
+func assignReservationTable(ctx context.Context, reservation *models.Reservation) (int, string) {
+	start := *reservation.ReservationTime
+
+	// a large party can book a whole table group
+	if reservation.TableGroupID != nil && *reservation.TableGroupID != "" {
+		group, err := findActiveTableGroup(ctx, *reservation.TableGroupID)
+		if err != nil {
+			return http.StatusBadRequest, "The table group is not active"
+		}
+
+		if group.Capacity < *reservation.PartySize {
+			return http.StatusBadRequest, fmt.Sprintf("the table group cannot seat a party of %d", *reservation.PartySize)
+		}
+
+		conflicts, err := conflictingReservations(ctx, group.TableIDs, start, reservation.EndTime, reservation.ReservationID)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to check the table bookings"
+		}
+
+		if len(conflicts) > 0 {
+			return http.StatusConflict, "a table in the group is already booked at that time"
+		}
+
+		reservation.TableID = &group.TableIDs[0]
+		reservation.TableIDs = group.TableIDs
+		return http.StatusOK, ""
+	}
+	reservation.TableIDs = nil
+
+	if reservation.TableID == nil || *reservation.TableID == "" {
+		tables, err := availableTables(ctx, *reservation.PartySize, start, reservation.EndTime, reservation.ReservationID)
+		if err != nil {
+			return http.StatusInternalServerError, "Failed to search for an available table"
+		}
+
+		if len(tables) == 0 {
+			return http.StatusConflict, "no table is available for the party at that time"
+		}
+
+		reservation.TableID = &tables[0].TableID
+		return http.StatusOK, ""
+	}
+
+	var table models.Table
+	err := tableCollection.FindOne(ctx, bson.M{"table_id": *reservation.TableID}).Decode(&table)
+	if err != nil {
+		return http.StatusBadRequest, "The table Id is not available"
+	}
+
+	if !tableFits(table, *reservation.PartySize) {
+		return http.StatusBadRequest, fmt.Sprintf("table %d cannot seat a party of %d", *table.TableNumber, *reservation.PartySize)
+	}
+
+	conflicts, err := conflictingReservations(ctx, []string{table.TableID}, start, reservation.EndTime, reservation.ReservationID)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to check the table bookings"
+	}
+
+	if len(conflicts) > 0 {
+		return http.StatusConflict, fmt.Sprintf("table %d is already booked at that time", *table.TableNumber)
+	}
+
+	return http.StatusOK, ""
+}
+
+func CreateReservation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var reservation models.Reservation
+
+		err := c.BindJSON(&reservation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reservation JSON"})
+			return
+		}
+
+		err = validate.Struct(reservation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the reservation structure failed"})
+			return
+		}
+
+		if reservation.ReservationTime.Before(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the reservation time has already passed"})
+			return
+		}
+
+		if reservation.Duration == nil {
+			duration := defaultReservationDuration
+			reservation.Duration = &duration
+		}
+		reservation.EndTime = reservationEnd(*reservation.ReservationTime, *reservation.Duration)
+
+		status := models.ReservationStatusBooked
+		reservation.Status = &status
+		reservation.ID = primitive.NewObjectID()
+		reservation.ReservationID = reservation.ID.Hex()
+		reservation.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		reservation.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		code, msg := assignReservationTable(ctx, &reservation)
+		if msg != "" {
+			c.JSON(code, gin.H{"error": msg})
+			return
+		}
+
+		_, err = reservationCollection.InsertOne(ctx, reservation)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Reservation was not created in the database"})
+			return
+		}
+
+		// two bookings made at the same moment can both pass the check above,
+		// so the one inserted first keeps the table and the later one backs out
+		conflicts, err := conflictingReservations(ctx, reservedTables(reservation), *reservation.ReservationTime, reservation.EndTime, reservation.ReservationID)
+		if err != nil {
+			// a booking that could not be checked is not kept
+			_, err = reservationCollection.DeleteOne(ctx, bson.M{"reservation_id": reservation.ReservationID})
+			if err != nil {
+				log.Println("Error removing an unchecked reservation: ", err)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the reservation against the other bookings"})
+			return
+		}
+
+		for _, conflict := range conflicts {
+			if conflict.ID.Hex() < reservation.ID.Hex() {
+				_, err = reservationCollection.DeleteOne(ctx, bson.M{"reservation_id": reservation.ReservationID})
+				if err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "the table was booked by another reservation and this one could not be removed"})
+					return
+				}
+				c.JSON(http.StatusConflict, gin.H{"error": "the table was booked by another reservation, please try again"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusCreated, reservation)
+	}
+}
+
+func GetReservations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"reservation_time", "created_at"}, "reservation_time", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if date := c.Query("date"); date != "" {
+			day, err := time.Parse("2006-01-02", date)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "date must be in the format YYYY-MM-DD"})
+				return
+			}
+			filter["reservation_time"] = bson.M{"$gte": day, "$lt": day.AddDate(0, 0, 1)}
+		}
+
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		if tableId := c.Query("table_id"); tableId != "" {
+			filter["table_id"] = tableId
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		reservations := []models.Reservation{}
+		result, err := listQuery.Find(ctx, reservationCollection, filter, &reservations)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the reservations"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetReservationById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reservationId := c.Param("reservation_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var reservation models.Reservation
+		err := reservationCollection.FindOne(ctx, bson.M{"reservation_id": reservationId}).Decode(&reservation)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the reservation in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, reservation)
+	}
+}
+
+func UpdateReservation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var update models.Reservation
+
+		err := c.BindJSON(&update)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while binding the reservation JSON from the request body"})
+			return
+		}
+
+		reservationId := c.Param("reservation_id")
+		filter := bson.M{"reservation_id": reservationId}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var reservation models.Reservation
+		err = reservationCollection.FindOne(ctx, filter).Decode(&reservation)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "reservation was not found"})
+			return
+		}
+
+		if *reservation.Status != models.ReservationStatusBooked {
+			msg := fmt.Sprintf("a %s reservation cannot be modified", *reservation.Status)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		// merge the changes into the stored reservation
+		var updateObj primitive.D
+		rebook := false
+
+		if update.GuestName != nil {
+			reservation.GuestName = update.GuestName
+			updateObj = append(updateObj, bson.E{Key: "guest_name", Value: *update.GuestName})
+		}
+
+		if update.Phone != nil {
+			reservation.Phone = update.Phone
+			updateObj = append(updateObj, bson.E{Key: "phone_number", Value: *update.Phone})
+		}
+
+		if update.Notes != nil {
+			reservation.Notes = update.Notes
+			updateObj = append(updateObj, bson.E{Key: "notes", Value: *update.Notes})
+		}
+
+		if update.PartySize != nil {
+			reservation.PartySize = update.PartySize
+			rebook = true
+		}
+
+		if update.ReservationTime != nil {
+			if update.ReservationTime.Before(time.Now()) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the reservation time has already passed"})
+				return
+			}
+			reservation.ReservationTime = update.ReservationTime
+			rebook = true
+		}
+
+		if update.Duration != nil {
+			reservation.Duration = update.Duration
+			rebook = true
+		}
+
+		if update.TableID != nil {
+			reservation.TableID = update.TableID
+			reservation.TableGroupID = nil
+			rebook = true
+		}
+
+		if update.TableGroupID != nil {
+			reservation.TableGroupID = update.TableGroupID
+			rebook = true
+		}
+
+		err = validate.Struct(reservation)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the reservation structure failed"})
+			return
+		}
+
+		if rebook {
+			reservation.EndTime = reservationEnd(*reservation.ReservationTime, *reservation.Duration)
+
+			code, msg := assignReservationTable(ctx, &reservation)
+			if msg != "" && update.TableID == nil && update.TableGroupID == nil {
+				// the current table no longer works, so look for another one
+				reservation.TableID = nil
+				reservation.TableGroupID = nil
+				code, msg = assignReservationTable(ctx, &reservation)
+			}
+			if msg != "" {
+				c.JSON(code, gin.H{"error": msg})
+				return
+			}
+
+			updateObj = append(updateObj, bson.E{Key: "party_size", Value: *reservation.PartySize})
+			updateObj = append(updateObj, bson.E{Key: "reservation_time", Value: *reservation.ReservationTime})
+			updateObj = append(updateObj, bson.E{Key: "duration", Value: *reservation.Duration})
+			updateObj = append(updateObj, bson.E{Key: "end_time", Value: reservation.EndTime})
+			updateObj = append(updateObj, bson.E{Key: "table_id", Value: *reservation.TableID})
+			updateObj = append(updateObj, bson.E{Key: "table_group_id", Value: reservation.TableGroupID})
+			updateObj = append(updateObj, bson.E{Key: "table_ids", Value: reservation.TableIDs})
+		}
+
+		reservation.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: reservation.UpdatedAt})
+
+		_, err = reservationCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "reservation failed to update"})
+			return
+		}
+
+		c.JSON(http.StatusOK, reservation)
+	}
+}
+
+func CancelReservation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reservationId := c.Param("reservation_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cancelledAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		filter := bson.M{"reservation_id": reservationId, "status": models.ReservationStatusBooked}
+		result, err := reservationCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+			{"status", models.ReservationStatusCancelled},
+			{"cancelled_at", cancelledAt},
+			{"updated_at", cancelledAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "reservation failed to cancel"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no booked reservation was found to cancel"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// SeatReservation records that a booked party has arrived and been seated, so the
+// booking keeps its table and is never swept up as a no-show
+func SeatReservation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reservationId := c.Param("reservation_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		seatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		filter := bson.M{"reservation_id": reservationId, "status": models.ReservationStatusBooked}
+		result, err := reservationCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+			{"status", models.ReservationStatusSeated},
+			{"seated_at", seatedAt},
+			{"updated_at", seatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "reservation failed to update"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no booked reservation was found to seat"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// MarkNoShow lets the host mark a booking as a no-show once its time has passed
+func MarkNoShow() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reservationId := c.Param("reservation_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		filter := bson.M{
+			"reservation_id":   reservationId,
+			"status":           models.ReservationStatusBooked,
+			"reservation_time": bson.M{"$lte": now},
+		}
+		result, err := reservationCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+			{"status", models.ReservationStatusNoShow},
+			{"updated_at", now},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "reservation failed to update"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no booked reservation past its time was found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// markNoShows releases the tables of the bookings whose party never arrived. Only
+// bookings still BOOKED are swept; a party that arrived has been seated.
+func markNoShows(ctx context.Context) (int64, error) {
+	now, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	filter := bson.M{
+		"status":           models.ReservationStatusBooked,
+		"reservation_time": bson.M{"$lt": now.Add(-noShowGrace * time.Minute)},
+	}
+
+	result, err := reservationCollection.UpdateMany(ctx, filter, bson.D{{"$set", bson.D{
+		{"status", models.ReservationStatusNoShow},
+		{"updated_at", now},
+	}}})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+// SweepNoShows marks late bookings as no-shows every interval, for as long as the server runs
+func SweepNoShows(interval time.Duration) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		marked, err := markNoShows(ctx)
+		cancel()
+
+		if err != nil {
+			log.Println("Error marking no-show reservations:", err)
+		} else if marked > 0 {
+			log.Printf("Marked %d reservations as no-shows", marked)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func GetAvailability() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		partySize, err := strconv.Atoi(c.Query("party_size"))
+		if err != nil || partySize < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "party_size must be a positive number"})
+			return
+		}
+
+		start, err := time.Parse(time.RFC3339, c.Query("time"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "time must be an RFC3339 timestamp"})
+			return
+		}
+
+		duration, err := strconv.Atoi(c.Query("duration"))
+		if err != nil || duration < 1 {
+			duration = defaultReservationDuration
+		}
+		end := reservationEnd(start, duration)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		tables, err := availableTables(ctx, partySize, start, end, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search for available tables"})
+			return
+		}
+
+		groups, err := availableTableGroups(ctx, partySize, start, end, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search for available table groups"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"party_size":   partySize,
+			"start_time":   start,
+			"end_time":     end,
+			"tables":       tables,
+			"table_groups": groups,
+		})
+	}
+}
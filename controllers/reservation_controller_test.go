@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newReservation(tableID string, at time.Time) models.Reservation {
+	name, phone, partySize, duration := "Test guest", "0123456789", 2, defaultReservationDuration
+	status := models.ReservationStatusBooked
+	reservation := models.Reservation{
+		ID:              primitive.NewObjectID(),
+		GuestName:       &name,
+		Phone:           &phone,
+		PartySize:       &partySize,
+		ReservationTime: &at,
+		Duration:        &duration,
+		EndTime:         reservationEnd(at, duration),
+		TableID:         &tableID,
+		Status:          &status,
+		CreatedAt:       time.Now(),
+	}
+	reservation.ReservationID = reservation.ID.Hex()
+	return reservation
+}
+
+func TestSeatedPartiesAreNotNoShows(t *testing.T) {
+	ctx := testDatabase(t)
+
+	// both bookings are past their grace period
+	late := time.Now().Add(-2 * noShowGrace * time.Minute)
+	arrived := newReservation(primitive.NewObjectID().Hex(), late)
+	missing := newReservation(primitive.NewObjectID().Hex(), late)
+	fixture(t, ctx, reservationCollection, arrived, missing)
+
+	seat := func(reservationID string) int {
+		return serve(withParam(SeatReservation(), "reservation_id", reservationID), http.MethodPatch, "/reservations/"+reservationID+"/seat", nil, "").Code
+	}
+
+	if code := seat(arrived.ReservationID); code != http.StatusOK {
+		t.Fatalf("seating a booked party: got %d, want %d", code, http.StatusOK)
+	}
+	if code := seat(arrived.ReservationID); code != http.StatusNotFound {
+		t.Errorf("seating a party twice: got %d, want %d", code, http.StatusNotFound)
+	}
+
+	if _, err := markNoShows(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	status := func(reservationID string) string {
+		var stored models.Reservation
+		if err := reservationCollection.FindOne(ctx, bson.M{"reservation_id": reservationID}).Decode(&stored); err != nil {
+			t.Fatal(err)
+		}
+		return *stored.Status
+	}
+
+	if got := status(arrived.ReservationID); got != models.ReservationStatusSeated {
+		t.Errorf("the seated party is %s, want %s", got, models.ReservationStatusSeated)
+	}
+	if got := status(missing.ReservationID); got != models.ReservationStatusNoShow {
+		t.Errorf("the party that never came is %s, want %s", got, models.ReservationStatusNoShow)
+	}
+}
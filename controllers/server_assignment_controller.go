@@ -0,0 +1,342 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"net/http"
+	"time"
+)
+
+var assignmentCollection = database.Collection(database.Client, "assignments")
+
+// currentAssignments lists the server assignments whose shift covers the given time
+func currentAssignments(ctx context.Context, at time.Time) ([]models.ServerAssignment, error) {
+	cursor, err := assignmentCollection.Find(ctx, bson.M{
+		"shift_start": bson.M{"$lte": at},
+		"shift_end":   bson.M{"$gt": at},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var assignments []models.ServerAssignment
+	if err = cursor.All(ctx, &assignments); err != nil {
+		return nil, err
+	}
+
+	return assignments, nil
+}
+
+// serverForTable finds the server covering a table, either directly or through its section
+func serverForTable(assignments []models.ServerAssignment, table models.Table) string {
+	for _, assignment := range assignments {
+		for _, tableID := range assignment.TableIDs {
+			if tableID == table.TableID {
+				return *assignment.ServerID
+			}
+		}
+	}
+
+	if table.Section == nil {
+		return ""
+	}
+
+	for _, assignment := range assignments {
+		for _, section := range assignment.Sections {
+			if section == *table.Section {
+				return *assignment.ServerID
+			}
+		}
+	}
+
+	return ""
+}
+
+// defaultServer is the server that owns a new order on the table: whoever is
+// assigned to it for the current shift, otherwise the staff member taking the order
+func defaultServer(ctx context.Context, tableID string, createdBy string) string {
+	var table models.Table
+	err := tableCollection.FindOne(ctx, bson.M{"table_id": tableID}).Decode(&table)
+	if err != nil {
+		return createdBy
+	}
+
+	assignments, err := currentAssignments(ctx, time.Now())
+	if err != nil {
+		return createdBy
+	}
+
+	if serverID := serverForTable(assignments, table); serverID != "" {
+		return serverID
+	}
+
+	return createdBy
+}
+
+func staffExists(ctx context.Context, userID string) bool {
+	count, err := UserCollection.CountDocuments(ctx, bson.M{"user_id": userID})
+	return err == nil && count > 0
+}
+
+// assignmentClash finds another server's assignment that covers one of the
+// same tables or sections during an overlapping shift
+func assignmentClash(ctx context.Context, assignment models.ServerAssignment) (string, error) {
+	tableIDs := assignment.TableIDs
+	if tableIDs == nil {
+		tableIDs = []string{}
+	}
+
+	sections := assignment.Sections
+	if sections == nil {
+		sections = []string{}
+	}
+
+	filter := bson.M{
+		"server_id":   bson.M{"$ne": *assignment.ServerID},
+		"shift_start": bson.M{"$lt": *assignment.ShiftEnd},
+		"shift_end":   bson.M{"$gt": *assignment.ShiftStart},
+		"$or": []bson.M{
+			{"table_ids": bson.M{"$in": tableIDs}},
+			{"sections": bson.M{"$in": sections}},
+		},
+	}
+	if assignment.AssignmentID != "" {
+		filter["assignment_id"] = bson.M{"$ne": assignment.AssignmentID}
+	}
+
+	var clash models.ServerAssignment
+	err := assignmentCollection.FindOne(ctx, filter).Decode(&clash)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return clash.AssignmentID, nil
+}
+
+func validAssignment(assignment models.ServerAssignment) string {
+	if len(assignment.TableIDs) == 0 && len(assignment.Sections) == 0 {
+		return "an assignment needs at least one table or section"
+	}
+
+	if !assignment.ShiftEnd.After(*assignment.ShiftStart) {
+		return "shift_end must be after shift_start"
+	}
+
+	return ""
+}
+
+func CreateAssignment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var assignment models.ServerAssignment
+
+		err := c.BindJSON(&assignment)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid assignment JSON"})
+			return
+		}
+
+		err = validate.Struct(assignment)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the assignment structure failed"})
+			return
+		}
+
+		if msg := validAssignment(assignment); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if !userIsManager(ctx, c.GetString("uid")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only a manager can assign servers"})
+			return
+		}
+
+		if !staffExists(ctx, *assignment.ServerID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the server is not a registered user"})
+			return
+		}
+
+		clashID, err := assignmentClash(ctx, assignment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the existing assignments"})
+			return
+		}
+
+		if clashID != "" {
+			msg := fmt.Sprintf("the tables or sections are already assigned to another server (assignment %s)", clashID)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		assignment.CreatedBy = c.GetString("uid")
+		assignment.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		assignment.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		assignment.ID = primitive.NewObjectID()
+		assignment.AssignmentID = assignment.ID.Hex()
+
+		_, err = assignmentCollection.InsertOne(ctx, assignment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Assignment was not created in the database"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, assignment)
+	}
+}
+
+func GetAssignments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"shift_start", "created_at"}, "shift_start", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if serverId := c.Query("server_id"); serverId != "" {
+			if serverId == "me" {
+				serverId = c.GetString("uid")
+			}
+			filter["server_id"] = serverId
+		}
+
+		if c.Query("active") == "true" {
+			now := time.Now()
+			filter["shift_start"] = bson.M{"$lte": now}
+			filter["shift_end"] = bson.M{"$gt": now}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		assignments := []models.ServerAssignment{}
+		result, err := listQuery.Find(ctx, assignmentCollection, filter, &assignments)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the assignments"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetAssignmentById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		assignmentId := c.Param("assignment_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var assignment models.ServerAssignment
+		err := assignmentCollection.FindOne(ctx, bson.M{"assignment_id": assignmentId}).Decode(&assignment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the assignment in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, assignment)
+	}
+}
+
+func UpdateAssignment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var update models.ServerAssignment
+
+		err := c.BindJSON(&update)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while binding the assignment JSON from the request body"})
+			return
+		}
+
+		assignmentId := c.Param("assignment_id")
+		filter := bson.M{"assignment_id": assignmentId}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if !userIsManager(ctx, c.GetString("uid")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only a manager can assign servers"})
+			return
+		}
+
+		var assignment models.ServerAssignment
+		err = assignmentCollection.FindOne(ctx, filter).Decode(&assignment)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "assignment was not found"})
+			return
+		}
+
+		// merge the changes into the stored assignment
+		if update.ServerID != nil {
+			if !staffExists(ctx, *update.ServerID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the server is not a registered user"})
+				return
+			}
+			assignment.ServerID = update.ServerID
+		}
+
+		if update.TableIDs != nil {
+			assignment.TableIDs = update.TableIDs
+		}
+
+		if update.Sections != nil {
+			assignment.Sections = update.Sections
+		}
+
+		if update.ShiftStart != nil {
+			assignment.ShiftStart = update.ShiftStart
+		}
+
+		if update.ShiftEnd != nil {
+			assignment.ShiftEnd = update.ShiftEnd
+		}
+
+		if msg := validAssignment(assignment); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		clashID, err := assignmentClash(ctx, assignment)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the existing assignments"})
+			return
+		}
+
+		if clashID != "" {
+			msg := fmt.Sprintf("the tables or sections are already assigned to another server (assignment %s)", clashID)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		assignment.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		_, err = assignmentCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+			{"server_id", assignment.ServerID},
+			{"table_ids", assignment.TableIDs},
+			{"sections", assignment.Sections},
+			{"shift_start", assignment.ShiftStart},
+			{"shift_end", assignment.ShiftEnd},
+			{"updated_at", assignment.UpdatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "assignment failed to update"})
+			return
+		}
+
+		c.JSON(http.StatusOK, assignment)
+	}
+}
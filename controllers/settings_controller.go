@@ -0,0 +1,259 @@
+package controllers
+
+import (
+	"context"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+var settingsCollection = database.Collection(database.Client, "settings")
+
+// restaurantSettingsID is the settings_id of the one settings document
+const restaurantSettingsID = "restaurant"
+
+// restaurantSettings returns the restaurant's settings, filling in the defaults
+// for anything that has not been set: no tax, tax added on top of menu prices,
+// tax rounded on each line and a fiscal year that follows the calendar year
+func restaurantSettings(ctx context.Context) (models.Settings, error) {
+	var settings models.Settings
+	err := settingsCollection.FindOne(ctx, bson.M{"settings_id": restaurantSettingsID}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return settings, err
+	}
+
+	settings.SettingsID = restaurantSettingsID
+	if settings.PricesIncludeTax == nil {
+		pricesIncludeTax := false
+		settings.PricesIncludeTax = &pricesIncludeTax
+	}
+	if settings.TaxRounding == nil {
+		rounding := models.TaxRoundingLine
+		settings.TaxRounding = &rounding
+	}
+	if settings.TaxRates == nil {
+		settings.TaxRates = []models.TaxRate{}
+	}
+	if settings.ServiceChargeRules == nil {
+		settings.ServiceChargeRules = []models.ServiceChargeRule{}
+	}
+	if settings.FiscalYearStart == nil {
+		january := int(time.January)
+		settings.FiscalYearStart = &january
+	}
+
+	return settings, nil
+}
+
+// taxRate finds the rate for a tax category, falling back to the default category
+func taxRate(settings models.Settings, category *string) (models.TaxRate, bool) {
+	for _, candidate := range []*string{category, settings.DefaultTaxCategory} {
+		if candidate == nil {
+			continue
+		}
+
+		for _, rate := range settings.TaxRates {
+			if *rate.Category == *candidate {
+				return rate, true
+			}
+		}
+	}
+
+	return models.TaxRate{}, false
+}
+
+// checkTaxCategory makes sure a food's tax category is one the restaurant has a rate for
+func checkTaxCategory(ctx context.Context, category *string) (int, string) {
+	if category == nil {
+		return http.StatusOK, ""
+	}
+
+	settings, err := restaurantSettings(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to get the tax rates"
+	}
+
+	for _, rate := range settings.TaxRates {
+		if *rate.Category == *category {
+			return http.StatusOK, ""
+		}
+	}
+
+	return http.StatusBadRequest, "there is no tax rate for the tax category " + *category
+}
+
+func GetSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		settings, err := restaurantSettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the settings in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, settings)
+	}
+}
+
+// UpdateSettings changes the restaurant's settings. Only managers can change
+// them, and new tax rates only apply to invoices that have not been paid yet.
+func UpdateSettings() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var settings models.Settings
+
+		err := c.BindJSON(&settings)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid settings JSON"})
+			return
+		}
+
+		err = validate.Struct(settings)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the settings failed, check the tax rates, service charge rules and receipt details"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if !userIsManager(ctx, c.GetString("uid")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only a manager can change the settings"})
+			return
+		}
+
+		current, err := restaurantSettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the settings in the database"})
+			return
+		}
+
+		var updateObj primitive.D
+
+		rates := current.TaxRates
+		if settings.TaxRates != nil {
+			seen := make(map[string]bool)
+			for _, rate := range settings.TaxRates {
+				if seen[*rate.Category] {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "a tax category can only have one rate"})
+					return
+				}
+				seen[*rate.Category] = true
+			}
+
+			rates = settings.TaxRates
+			updateObj = append(updateObj, bson.E{"tax_rates", settings.TaxRates})
+		}
+
+		defaultCategory := current.DefaultTaxCategory
+		if settings.DefaultTaxCategory != nil {
+			defaultCategory = settings.DefaultTaxCategory
+			updateObj = append(updateObj, bson.E{"default_tax_category", settings.DefaultTaxCategory})
+		}
+
+		if defaultCategory != nil {
+			if _, ok := taxRate(models.Settings{TaxRates: rates}, defaultCategory); !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the default tax category needs a tax rate"})
+				return
+			}
+		}
+
+		if settings.PricesIncludeTax != nil {
+			updateObj = append(updateObj, bson.E{"prices_include_tax", settings.PricesIncludeTax})
+		}
+
+		if settings.ServiceChargeRules != nil {
+			for _, rule := range settings.ServiceChargeRules {
+				if *rule.Type == models.PromotionPercentage && *rule.Value > 100 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "a percentage service charge cannot be more than 100"})
+					return
+				}
+
+				if rule.Taxable && rule.TaxCategory != nil {
+					if _, ok := taxRate(models.Settings{TaxRates: rates}, rule.TaxCategory); !ok {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "there is no tax rate for the service charge's tax category"})
+						return
+					}
+				}
+			}
+
+			updateObj = append(updateObj, bson.E{"service_charge_rules", settings.ServiceChargeRules})
+		}
+
+		if settings.TaxRounding != nil {
+			updateObj = append(updateObj, bson.E{"tax_rounding", settings.TaxRounding})
+		}
+
+		if settings.RestaurantName != nil {
+			updateObj = append(updateObj, bson.E{"restaurant_name", settings.RestaurantName})
+		}
+
+		if settings.Address != nil {
+			updateObj = append(updateObj, bson.E{"address", settings.Address})
+		}
+
+		if settings.Phone != nil {
+			updateObj = append(updateObj, bson.E{"phone", settings.Phone})
+		}
+
+		if settings.TaxNumber != nil {
+			updateObj = append(updateObj, bson.E{"tax_number", settings.TaxNumber})
+		}
+
+		if settings.ReceiptFooter != nil {
+			updateObj = append(updateObj, bson.E{"receipt_footer", settings.ReceiptFooter})
+		}
+
+		if settings.FiscalYearStart != nil {
+			updateObj = append(updateObj, bson.E{"fiscal_year_start", settings.FiscalYearStart})
+		}
+
+		// a template that cannot print a receipt is turned away now rather than at the till
+		if settings.ReceiptTemplate != nil {
+			if err = helpers.CheckReceiptTemplate(*settings.ReceiptTemplate); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "the receipt template does not work: " + err.Error()})
+				return
+			}
+			updateObj = append(updateObj, bson.E{"receipt_template", settings.ReceiptTemplate})
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{"updated_by", c.GetString("uid")})
+		updateObj = append(updateObj, bson.E{"updated_at", updatedAt})
+
+		upsert := true
+		opt := options.UpdateOptions{Upsert: &upsert}
+
+		_, err = settingsCollection.UpdateOne(ctx, bson.M{"settings_id": restaurantSettingsID}, bson.D{
+			{"$set", updateObj},
+			{"$setOnInsert", bson.D{{"_id", primitive.NewObjectID()}}},
+		}, &opt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the settings"})
+			return
+		}
+
+		current, err = restaurantSettings(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the settings in the database"})
+			return
+		}
+
+		// open bills pick up the new tax rates and service charges
+		err = recalculateOpenInvoices(ctx, bson.M{})
+		if err != nil {
+			log.Println("Error recalculating the open invoices: ", err)
+		}
+
+		c.JSON(http.StatusOK, current)
+	}
+}
@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"net/http"
+	"time"
+)
+
+var stockTakeCollection = database.Collection(database.Client, "stockTakes")
+
+// stockPeriodStart is when the ingredient was last counted, or when it was added if it never has been
+func stockPeriodStart(ctx context.Context, ingredient models.Ingredient) (time.Time, error) {
+	var movement models.StockMovement
+	opt := options.FindOne().SetSort(bson.D{{"created_at", -1}})
+	err := stockMovementCollection.FindOne(ctx, bson.M{
+		"ingredient_id": ingredient.IngredientID,
+		"reason":        models.StockMovementStockTake,
+	}, opt).Decode(&movement)
+	if err == mongo.ErrNoDocuments {
+		return ingredient.CreatedAt, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return movement.CreatedAt, nil
+}
+
+// theoreticalUsage is how much of the ingredient the recipes say was served since the given time
+func theoreticalUsage(ctx context.Context, ingredientID string, since time.Time) (float64, error) {
+	matchStage := bson.D{{"$match", bson.D{
+		{"ingredient_id", ingredientID},
+		{"reason", models.StockMovementServed},
+		{"created_at", bson.D{{"$gte", since}}},
+	}}}
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", nil},
+		{"used", bson.D{{"$sum", "$quantity"}}},
+	}}}
+
+	cursor, err := stockMovementCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return 0, err
+	}
+
+	var result []bson.M
+	if err = cursor.All(ctx, &result); err != nil {
+		return 0, err
+	}
+
+	if len(result) == 0 {
+		return 0, nil
+	}
+
+	used, _ := result[0]["used"].(float64)
+	return -used, nil
+}
+
+// CreateStockTake records a physical count, works out the variance against the
+// expected stock and theoretical usage, and sets the stock levels to what was counted
+func CreateStockTake() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var stockTake models.StockTake
+
+		err := c.BindJSON(&stockTake)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stock take JSON"})
+			return
+		}
+
+		err = validate.Struct(stockTake)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "every count needs an ingredient and a counted quantity"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		seen := make(map[string]bool)
+		for i, count := range stockTake.Counts {
+			if seen[*count.IngredientID] {
+				msg := fmt.Sprintf("ingredient %s is counted more than once", *count.IngredientID)
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+			seen[*count.IngredientID] = true
+
+			var ingredient models.Ingredient
+			err = ingredientCollection.FindOne(ctx, bson.M{"ingredient_id": count.IngredientID}).Decode(&ingredient)
+			if err != nil {
+				msg := fmt.Sprintf("ingredient %s was not found", *count.IngredientID)
+				c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+				return
+			}
+
+			periodStart, err := stockPeriodStart(ctx, ingredient)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find the last stock take"})
+				return
+			}
+
+			usage, err := theoreticalUsage(ctx, ingredient.IngredientID, periodStart)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to work out the theoretical usage"})
+				return
+			}
+
+			stockTake.Counts[i].PeriodStart = &periodStart
+			stockTake.Counts[i].TheoreticalUsage = toFixed(usage, 3)
+			stockTake.Counts[i].ExpectedQuantity = toFixed(*ingredient.StockLevel, 3)
+			stockTake.Counts[i].Variance = toFixed(*count.CountedQuantity-*ingredient.StockLevel, 3)
+		}
+
+		stockTake.CountedBy = c.GetString("uid")
+		stockTake.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		stockTake.ID = primitive.NewObjectID()
+		stockTake.StockTakeID = stockTake.ID.Hex()
+
+		_, err = stockTakeCollection.InsertOne(ctx, stockTake)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Stock take was not created in the database"})
+			return
+		}
+
+		// apply the variance rather than the count so portions served since the count was read are kept
+		for _, count := range stockTake.Counts {
+			_, err = ingredientCollection.UpdateOne(ctx, bson.M{"ingredient_id": count.IngredientID}, bson.D{
+				{"$inc", bson.D{{"stock_level", count.Variance}}},
+				{"$set", bson.D{{"updated_at", stockTake.CreatedAt}}},
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the stock levels"})
+				return
+			}
+
+			movement := models.StockMovement{
+				ID:           primitive.NewObjectID(),
+				IngredientID: *count.IngredientID,
+				Quantity:     count.Variance,
+				Reason:       models.StockMovementStockTake,
+				StockTakeID:  &stockTake.StockTakeID,
+				CreatedBy:    stockTake.CountedBy,
+				CreatedAt:    stockTake.CreatedAt,
+			}
+			movement.StockMovementID = movement.ID.Hex()
+
+			_, err = stockMovementCollection.InsertOne(ctx, movement)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the stock movements"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusCreated, stockTake)
+	}
+}
+
+func GetStockTakes() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		stockTakes := []models.StockTake{}
+		result, err := listQuery.Find(ctx, stockTakeCollection, filter, &stockTakes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the stock takes"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetStockTakeById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		stockTakeId := c.Param("stock_take_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var stockTake models.StockTake
+		err := stockTakeCollection.FindOne(ctx, bson.M{"stock_take_id": stockTakeId}).Decode(&stockTake)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the stock take in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, stockTake)
+	}
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"context"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+var supplierCollection = database.Collection(database.Client, "suppliers")
+
+func supplierExists(ctx context.Context, supplierID string) bool {
+	count, err := supplierCollection.CountDocuments(ctx, bson.M{"supplier_id": supplierID})
+	return err == nil && count > 0
+}
+
+func CreateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var supplier models.Supplier
+
+		err := c.BindJSON(&supplier)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid supplier JSON"})
+			return
+		}
+
+		err = validate.Struct(supplier)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the supplier structure failed"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		supplier.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		supplier.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		supplier.ID = primitive.NewObjectID()
+		supplier.SupplierID = supplier.ID.Hex()
+
+		_, err = supplierCollection.InsertOne(ctx, supplier)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Supplier was not created in the database"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, supplier)
+	}
+}
+
+func GetSuppliers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"name", "created_at"}, "name", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		suppliers := []models.Supplier{}
+		result, err := listQuery.Find(ctx, supplierCollection, filter, &suppliers)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the suppliers"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetSupplierById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		supplierId := c.Param("supplier_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var supplier models.Supplier
+		err := supplierCollection.FindOne(ctx, bson.M{"supplier_id": supplierId}).Decode(&supplier)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the supplier in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, supplier)
+	}
+}
+
+func UpdateSupplier() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var supplier models.Supplier
+
+		err := c.BindJSON(&supplier)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Error while binding the supplier JSON from the request body"})
+			return
+		}
+
+		err = validate.StructExcept(supplier, "Name")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the supplier structure failed"})
+			return
+		}
+
+		supplierId := c.Param("supplier_id")
+		filter := bson.M{"supplier_id": supplierId}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var updateObj primitive.D
+
+		if supplier.Name != nil {
+			updateObj = append(updateObj, bson.E{"name", supplier.Name})
+		}
+
+		if supplier.ContactName != nil {
+			updateObj = append(updateObj, bson.E{"contact_name", supplier.ContactName})
+		}
+
+		if supplier.Phone != nil {
+			updateObj = append(updateObj, bson.E{"phone", supplier.Phone})
+		}
+
+		if supplier.Email != nil {
+			updateObj = append(updateObj, bson.E{"email", supplier.Email})
+		}
+
+		if supplier.Notes != nil {
+			updateObj = append(updateObj, bson.E{"notes", supplier.Notes})
+		}
+
+		supplier.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		updateObj = append(updateObj, bson.E{"updated_at", supplier.UpdatedAt})
+
+		result, err := supplierCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "supplier failed to update"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "supplier was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
@@ -2,18 +2,211 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
 	"github.com/dastardlyjockey/restaurant-management-backend/models"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"net/http"
 	"time"
 )
 
+type TableFloorFormat struct {
+	TableID        string
+	TableNumber    *int
+	Section        *string
+	PositionX      *float64
+	PositionY      *float64
+	MinCapacity    *int
+	MaxCapacity    *int
+	NumberOfGuests *int
+	Status         string
+	OrderID        string
+	SeatedSince    *time.Time
+	ServerID       string
+	TableGroupID   string
+}
+
+// tableState is the live state of a table derived from its open order
+type tableState struct {
+	Status       string
+	OrderID      string
+	SeatedSince  *time.Time
+	TableGroupID string
+}
+
 var tableCollection = database.Collection(database.Client, "table")
 
+func validTableCapacity(table models.Table) bool {
+	if table.MinCapacity != nil && table.MaxCapacity != nil {
+		return *table.MinCapacity <= *table.MaxCapacity
+	}
+	return true
+}
+
+// tableCapacity is the most guests a table can seat, falling back to the
+// number of guests for tables created before capacities were recorded
+func tableCapacity(table models.Table) int {
+	if table.MaxCapacity != nil {
+		return *table.MaxCapacity
+	}
+	if table.NumberOfGuests != nil {
+		return *table.NumberOfGuests
+	}
+	return 0
+}
+
+func tableFits(table models.Table, partySize int) bool {
+	if table.MinCapacity != nil && partySize < *table.MinCapacity {
+		return false
+	}
+	return partySize <= tableCapacity(table)
+}
+
+// tableNumberTaken reports whether another table already uses the table number.
+// It gives a friendly error up front; the unique index on table_number is what
+// stops two requests racing for the same number.
+func tableNumberTaken(ctx context.Context, tableNumber int, excludeTableID string) (bool, error) {
+	filter := bson.M{"table_number": tableNumber}
+	if excludeTableID != "" {
+		filter["table_id"] = bson.M{"$ne": excludeTableID}
+	}
+
+	count, err := tableCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// tableStates works out the live status of every table from the open orders,
+// their order items and any unpaid invoice raised against them
+func tableStates(ctx context.Context, tables []models.Table) (map[string]tableState, error) {
+	states := make(map[string]tableState)
+
+	tableIDs := make([]string, 0, len(tables))
+	for _, table := range tables {
+		tableIDs = append(tableIDs, table.TableID)
+
+		status := models.TableStatusFree
+		if table.NeedsCleaning != nil && *table.NeedsCleaning {
+			status = models.TableStatusDirty
+		}
+		states[table.TableID] = tableState{Status: status}
+	}
+
+	// tables pushed together share the state of the group's order
+	groups, err := activeTableGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groupMembers := make(map[string][]string)
+	groupIDs := []string{}
+	for _, group := range groups {
+		groupMembers[group.TableGroupID] = group.TableIDs
+		groupIDs = append(groupIDs, group.TableGroupID)
+
+		for _, tableID := range group.TableIDs {
+			if state, ok := states[tableID]; ok {
+				state.TableGroupID = group.TableGroupID
+				states[tableID] = state
+			}
+		}
+	}
+
+	// open orders, oldest first so the first order seated on a table wins
+	opt := options.Find().SetSort(bson.D{{"created_at", 1}})
+	cursor, err := orderCollection.Find(ctx, bson.M{
+		"$or": []bson.M{
+			{"table_id": bson.M{"$in": tableIDs}},
+			{"table_group_id": bson.M{"$in": groupIDs}},
+		},
+		"status": bson.M{"$ne": models.OrderStatusClosed},
+	}, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	var openOrders []models.Order
+	if err = cursor.All(ctx, &openOrders); err != nil {
+		return nil, err
+	}
+
+	if len(openOrders) == 0 {
+		return states, nil
+	}
+
+	orderIDs := make([]string, 0, len(openOrders))
+	for _, order := range openOrders {
+		orderIDs = append(orderIDs, order.OrderID)
+	}
+
+	orderedIDs, err := orderItemsCollection.Distinct(ctx, "order_id", bson.M{"order_id": bson.M{"$in": orderIDs}})
+	if err != nil {
+		return nil, err
+	}
+
+	billedIDs, err := invoiceCollection.Distinct(ctx, "order_id", bson.M{
+		"order_id":       bson.M{"$in": orderIDs},
+		"payment_status": bson.M{"$ne": "PAID"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make(map[string]bool)
+	for _, id := range orderedIDs {
+		if orderID, ok := id.(string); ok {
+			ordered[orderID] = true
+		}
+	}
+
+	billed := make(map[string]bool)
+	for _, id := range billedIDs {
+		if orderID, ok := id.(string); ok {
+			billed[orderID] = true
+		}
+	}
+
+	for _, order := range openOrders {
+		var orderTables []string
+		if order.TableGroupID != nil && groupMembers[*order.TableGroupID] != nil {
+			orderTables = groupMembers[*order.TableGroupID]
+		} else if order.TableID != nil {
+			orderTables = []string{*order.TableID}
+		}
+
+		status := models.TableStatusSeated
+		switch {
+		case billed[order.OrderID]:
+			status = models.TableStatusAwaitingBill
+		case ordered[order.OrderID]:
+			status = models.TableStatusOrdering
+		}
+
+		createdAt := order.CreatedAt
+		for _, tableID := range orderTables {
+			state, ok := states[tableID]
+			if !ok || state.OrderID != "" {
+				continue
+			}
+
+			state.Status = status
+			state.OrderID = order.OrderID
+			state.SeatedSince = &createdAt
+			states[tableID] = state
+		}
+	}
+
+	return states, nil
+}
+
 func CreateTable() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var table models.Table
@@ -30,7 +223,30 @@ func CreateTable() gin.HandlerFunc {
 			return
 		}
 
+		if !validTableCapacity(table) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "min_capacity cannot be greater than max_capacity"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// table numbers must be unique within the restaurant
+		taken, err := tableNumberTaken(ctx, *table.TableNumber, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the table number"})
+			return
+		}
+
+		if taken {
+			msg := fmt.Sprintf("table number %d already exists", *table.TableNumber)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
 		// populate the table and add it to the database
+		needsCleaning := false
+		table.NeedsCleaning = &needsCleaning
 		table.ID = primitive.NewObjectID()
 		table.TableID = table.ID.Hex()
 		table.CreatedAt, err = time.Parse(time.RFC3339, time.Now().UTC().Format(time.RFC3339))
@@ -40,9 +256,12 @@ func CreateTable() gin.HandlerFunc {
 			return
 		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
 		result, err := tableCollection.InsertOne(ctx, table)
+		if mongo.IsDuplicateKeyError(err) {
+			msg := fmt.Sprintf("table number %d already exists", *table.TableNumber)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
 
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert the table into the database"})
@@ -56,23 +275,110 @@ func CreateTable() gin.HandlerFunc {
 
 func GetTables() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"table_number", "created_at"}, "table_number", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{}
+
+		if section := c.Query("section"); section != "" {
+			filter["section"] = section
+		}
+
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		cursor, err := tableCollection.Find(ctx, bson.D{})
+		tables := []models.Table{}
+		result, err := listQuery.Find(ctx, tableCollection, filter, &tables)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the tables from the database"})
 			return
 		}
 
-		var allResults []bson.M
-		err = cursor.All(ctx, &allResults)
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetFloor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		opt := options.Find().SetSort(bson.D{{"section", 1}, {"table_number", 1}})
+		cursor, err := tableCollection.Find(ctx, bson.D{}, opt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the tables from the database"})
+			return
+		}
+
+		var tables []models.Table
+		err = cursor.All(ctx, &tables)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the tables from the database"})
 			return
 		}
 
-		c.JSON(http.StatusOK, allResults)
+		// work out the current state of each table
+		states, err := tableStates(ctx, tables)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the table states"})
+			return
+		}
+
+		assignments, err := currentAssignments(ctx, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the server assignments"})
+			return
+		}
+
+		// a server can view just their own tables with server_id=me
+		serverId := c.Query("server_id")
+		if serverId == "me" {
+			serverId = c.GetString("uid")
+		}
+
+		floor := make([]TableFloorFormat, 0, len(tables))
+		statusCount := make(map[string]int)
+		for _, table := range tables {
+			tableServerID := serverForTable(assignments, table)
+			if serverId != "" && tableServerID != serverId {
+				continue
+			}
+
+			state := states[table.TableID]
+			statusCount[state.Status]++
+
+			floor = append(floor, TableFloorFormat{
+				TableID:        table.TableID,
+				TableNumber:    table.TableNumber,
+				Section:        table.Section,
+				PositionX:      table.PositionX,
+				PositionY:      table.PositionY,
+				MinCapacity:    table.MinCapacity,
+				MaxCapacity:    table.MaxCapacity,
+				NumberOfGuests: table.NumberOfGuests,
+				Status:         state.Status,
+				OrderID:        state.OrderID,
+				SeatedSince:    state.SeatedSince,
+				ServerID:       tableServerID,
+				TableGroupID:   state.TableGroupID,
+			})
+		}
+
+		groups, err := activeTableGroups(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the table groups"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"tables": floor, "table_groups": groups, "status_count": statusCount})
 	}
 }
 
@@ -106,11 +412,29 @@ func UpdateTable() gin.HandlerFunc {
 			return
 		}
 
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		tableId := c.Param("table_id")
+		filter := bson.D{{"table_id", tableId}}
+
 		// creating and populate the update object
 
 		var updateObj primitive.D
 
 		if table.TableNumber != nil {
+			taken, err := tableNumberTaken(ctx, *table.TableNumber, tableId)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the table number"})
+				return
+			}
+
+			if taken {
+				msg := fmt.Sprintf("table number %d already exists", *table.TableNumber)
+				c.JSON(http.StatusConflict, gin.H{"error": msg})
+				return
+			}
+
 			updateObj = append(updateObj, bson.E{Key: "table_number", Value: table.TableNumber})
 		}
 
@@ -118,6 +442,45 @@ func UpdateTable() gin.HandlerFunc {
 			updateObj = append(updateObj, bson.E{Key: "number_of_guests", Value: table.NumberOfGuests})
 		}
 
+		if table.MinCapacity != nil || table.MaxCapacity != nil {
+			// check the new capacity against what is already stored
+			var current models.Table
+			err = tableCollection.FindOne(ctx, filter).Decode(&current)
+			if err == nil {
+				if table.MinCapacity == nil {
+					table.MinCapacity = current.MinCapacity
+				}
+				if table.MaxCapacity == nil {
+					table.MaxCapacity = current.MaxCapacity
+				}
+			}
+
+			if !validTableCapacity(table) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "min_capacity cannot be greater than max_capacity"})
+				return
+			}
+
+			updateObj = append(updateObj, bson.E{Key: "min_capacity", Value: table.MinCapacity})
+			updateObj = append(updateObj, bson.E{Key: "max_capacity", Value: table.MaxCapacity})
+		}
+
+		if table.Section != nil {
+			updateObj = append(updateObj, bson.E{Key: "section", Value: table.Section})
+		}
+
+		if table.PositionX != nil {
+			updateObj = append(updateObj, bson.E{Key: "position_x", Value: table.PositionX})
+		}
+
+		if table.PositionY != nil {
+			updateObj = append(updateObj, bson.E{Key: "position_y", Value: table.PositionY})
+		}
+
+		// setting needs_cleaning to false marks a dirty table as cleaned
+		if table.NeedsCleaning != nil {
+			updateObj = append(updateObj, bson.E{Key: "needs_cleaning", Value: table.NeedsCleaning})
+		}
+
 		table.UpdatedAt, err = time.Parse(time.RFC3339, time.Now().UTC().Format(time.RFC3339))
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create the time"})
@@ -127,21 +490,23 @@ func UpdateTable() gin.HandlerFunc {
 		updateObj = append(updateObj, bson.E{Key: "updated_at", Value: table.UpdatedAt})
 
 		// update the table in the database
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		tableId := c.Param("table_id")
-		filter := bson.D{{"table_id", tableId}}
-
-		upsert := true
-		updateOptions := options.UpdateOptions{Upsert: &upsert}
+		updateResult, err := tableCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}})
+		if mongo.IsDuplicateKeyError(err) {
+			msg := fmt.Sprintf("table number %d already exists", *table.TableNumber)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
 
-		updateResult, err := tableCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &updateOptions)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the table in the database"})
 			return
 		}
 
+		if updateResult.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "table was not found"})
+			return
+		}
+
 		// success response
 		c.JSON(http.StatusOK, updateResult)
 	}
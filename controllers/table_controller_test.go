@@ -0,0 +1,43 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestUpdateTable(t *testing.T) {
+	ctx := testDatabase(t)
+
+	table, other := newTable(), newTable()
+	fixture(t, ctx, tableCollection, table, other)
+
+	update := func(tableID string, body gin.H) int {
+		return serve(withParam(UpdateTable(), "table_id", tableID), http.MethodPatch, "/tables/"+tableID, body, "").Code
+	}
+
+	section := "Terrace"
+	if code := update(table.TableID, gin.H{"section": section}); code != http.StatusOK {
+		t.Errorf("moving a table to another section: got %d, want %d", code, http.StatusOK)
+	}
+
+	if code := update(table.TableID, gin.H{"table_number": *other.TableNumber}); code != http.StatusConflict {
+		t.Errorf("taking another table's number: got %d, want %d", code, http.StatusConflict)
+	}
+
+	unknown := primitive.NewObjectID().Hex()
+	if code := update(unknown, gin.H{"section": section}); code != http.StatusNotFound {
+		t.Errorf("updating a table that does not exist: got %d, want %d", code, http.StatusNotFound)
+	}
+
+	count, err := tableCollection.CountDocuments(ctx, bson.M{"table_id": unknown})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("updating a table that does not exist created it")
+	}
+}
@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"net/http"
+	"time"
+)
+
+var tableGroupCollection = database.Collection(database.Client, "tableGroups")
+
+func activeTableGroups(ctx context.Context) ([]models.TableGroup, error) {
+	cursor, err := tableGroupCollection.Find(ctx, bson.M{"status": models.TableGroupStatusActive})
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []models.TableGroup
+	if err = cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func findActiveTableGroup(ctx context.Context, tableGroupID string) (models.TableGroup, error) {
+	var group models.TableGroup
+	err := tableGroupCollection.FindOne(ctx, bson.M{
+		"table_group_id": tableGroupID,
+		"status":         models.TableGroupStatusActive,
+	}).Decode(&group)
+	return group, err
+}
+
+// resolveOrderTableGroup checks the group an order is opened against and points
+// the order at the group's first table when no table was given
+func resolveOrderTableGroup(ctx context.Context, order *models.Order) (int, string) {
+	if order.TableGroupID == nil {
+		return http.StatusOK, ""
+	}
+
+	group, err := findActiveTableGroup(ctx, *order.TableGroupID)
+	if err != nil {
+		return http.StatusBadRequest, "The table group is not active"
+	}
+
+	if order.TableID == nil {
+		order.TableID = &group.TableIDs[0]
+	}
+
+	inGroup := false
+	for _, tableID := range group.TableIDs {
+		if tableID == *order.TableID {
+			inGroup = true
+		}
+	}
+
+	if !inGroup {
+		return http.StatusBadRequest, "the table is not part of the table group"
+	}
+
+	return http.StatusOK, ""
+}
+
+// openGroupOrder finds the single open order for a table group, if there is one
+func openGroupOrder(ctx context.Context, tableGroupID string) (models.Order, error) {
+	var order models.Order
+	err := orderCollection.FindOne(ctx, bson.M{
+		"table_group_id": tableGroupID,
+		"status":         bson.M{"$ne": models.OrderStatusClosed},
+	}).Decode(&order)
+	return order, err
+}
+
+// dissolveTableGroup splits the tables back up once the party has left
+func dissolveTableGroup(ctx context.Context, tableGroupID string) error {
+	dissolvedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	_, err := tableGroupCollection.UpdateOne(ctx, bson.M{
+		"table_group_id": tableGroupID,
+		"status":         models.TableGroupStatusActive,
+	}, bson.D{{"$set", bson.D{
+		{"status", models.TableGroupStatusDissolved},
+		{"dissolved_at", dissolvedAt},
+		{"updated_at", dissolvedAt},
+	}}})
+	return err
+}
+
+func CreateTableGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var group models.TableGroup
+
+		err := c.BindJSON(&group)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid table group JSON"})
+			return
+		}
+
+		err = validate.Struct(group)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "a table group needs at least two tables"})
+			return
+		}
+
+		seen := make(map[string]bool)
+		for _, tableID := range group.TableIDs {
+			if seen[tableID] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "a table can only be listed once in a group"})
+				return
+			}
+			seen[tableID] = true
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		cursor, err := tableCollection.Find(ctx, bson.M{"table_id": bson.M{"$in": group.TableIDs}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get the tables from the database"})
+			return
+		}
+
+		var tables []models.Table
+		if err = cursor.All(ctx, &tables); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to iterate the tables from the database"})
+			return
+		}
+
+		if len(tables) != len(group.TableIDs) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "one or more of the tables do not exist"})
+			return
+		}
+
+		// a table can only belong to one group at a time
+		count, err := tableGroupCollection.CountDocuments(ctx, bson.M{
+			"status":    models.TableGroupStatusActive,
+			"table_ids": bson.M{"$in": group.TableIDs},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the existing table groups"})
+			return
+		}
+
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "one or more of the tables are already in a group"})
+			return
+		}
+
+		// a party already seated at one of the tables carries its order over to the group
+		var openOrders []models.Order
+		cursor, err = orderCollection.Find(ctx, bson.M{
+			"table_id": bson.M{"$in": group.TableIDs},
+			"status":   bson.M{"$ne": models.OrderStatusClosed},
+		})
+		if err == nil {
+			err = cursor.All(ctx, &openOrders)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the open orders on the tables"})
+			return
+		}
+
+		if len(openOrders) > 1 {
+			c.JSON(http.StatusConflict, gin.H{"error": "more than one of the tables has an open order"})
+			return
+		}
+
+		group.Capacity = 0
+		for _, table := range tables {
+			group.Capacity += tableCapacity(table)
+		}
+
+		status := models.TableGroupStatusActive
+		group.Status = &status
+		group.CreatedBy = c.GetString("uid")
+		group.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		group.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		group.ID = primitive.NewObjectID()
+		group.TableGroupID = group.ID.Hex()
+
+		_, err = tableGroupCollection.InsertOne(ctx, group)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Table group was not created in the database"})
+			return
+		}
+
+		if len(openOrders) == 1 {
+			_, err = orderCollection.UpdateOne(ctx, bson.M{"order_id": openOrders[0].OrderID}, bson.D{{"$set", bson.D{
+				{"table_group_id", group.TableGroupID},
+				{"updated_at", group.UpdatedAt},
+			}}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move the open order to the table group"})
+				return
+			}
+		}
+
+		c.JSON(http.StatusCreated, group)
+	}
+}
+
+func GetTableGroups() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at", "name"}, "created_at", -1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		status := c.Query("status")
+		if status == "" {
+			status = models.TableGroupStatusActive
+		}
+		filter := bson.M{"status": status}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		groups := []models.TableGroup{}
+		result, err := listQuery.Find(ctx, tableGroupCollection, filter, &groups)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the table groups"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func GetTableGroupById() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tableGroupId := c.Param("table_group_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var group models.TableGroup
+		err := tableGroupCollection.FindOne(ctx, bson.M{"table_group_id": tableGroupId}).Decode(&group)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error while fetching the table group in the database"})
+			return
+		}
+
+		c.JSON(http.StatusOK, group)
+	}
+}
+
+func DissolveTableGroup() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tableGroupId := c.Param("table_group_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		group, err := findActiveTableGroup(ctx, tableGroupId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no active table group was found"})
+			return
+		}
+
+		// the party has to settle up before the tables are split
+		_, err = openGroupOrder(ctx, group.TableGroupID)
+		if err == nil {
+			msg := fmt.Sprintf("table group %s still has an open order", group.TableGroupID)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		err = dissolveTableGroup(ctx, group.TableGroupID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to dissolve the table group"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"table_group_id": group.TableGroupID, "status": models.TableGroupStatusDissolved})
+	}
+}
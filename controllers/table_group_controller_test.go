@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestTableGroupCarriesTheOpenOrder(t *testing.T) {
+	ctx := testDatabase(t)
+
+	first, second, third := newTable(), newTable(), newTable()
+	order := newOrder(&first.TableID)
+	fixture(t, ctx, tableCollection, first, second, third)
+	fixture(t, ctx, orderCollection, order)
+	cleanup(t, ctx, tableGroupCollection, bson.M{"table_ids": bson.M{"$in": bson.A{first.TableID, second.TableID, third.TableID}}})
+
+	create := func(tableIDs ...string) *httptest.ResponseRecorder {
+		return serve(CreateTableGroup(), http.MethodPost, "/tableGroups", gin.H{"table_ids": tableIDs}, "")
+	}
+
+	recorder := create(first.TableID, second.TableID)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("grouping two tables: got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var group models.TableGroup
+	if err := json.Unmarshal(recorder.Body.Bytes(), &group); err != nil {
+		t.Fatal(err)
+	}
+	if group.Capacity != 8 {
+		t.Errorf("the group seats %d, want 8", group.Capacity)
+	}
+
+	var moved models.Order
+	if err := orderCollection.FindOne(ctx, bson.M{"order_id": order.OrderID}).Decode(&moved); err != nil {
+		t.Fatal(err)
+	}
+	if moved.TableGroupID == nil || *moved.TableGroupID != group.TableGroupID {
+		t.Errorf("the open order on the first table was not moved to the group")
+	}
+
+	if code := create(second.TableID, third.TableID).Code; code != http.StatusConflict {
+		t.Errorf("grouping a table that is already in a group: got %d, want %d", code, http.StatusConflict)
+	}
+
+	if code := create(third.TableID, third.TableID).Code; code != http.StatusBadRequest {
+		t.Errorf("grouping a table with itself: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestOrderOnATableGroup(t *testing.T) {
+	ctx := testDatabase(t)
+
+	first, second, outside := newTable(), newTable(), newTable()
+	fixture(t, ctx, tableCollection, first, second, outside)
+	cleanup(t, ctx, tableGroupCollection, bson.M{"table_ids": first.TableID})
+
+	recorder := serve(CreateTableGroup(), http.MethodPost, "/tableGroups", gin.H{"table_ids": []string{first.TableID, second.TableID}}, "")
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("grouping two tables: got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var group models.TableGroup
+	if err := json.Unmarshal(recorder.Body.Bytes(), &group); err != nil {
+		t.Fatal(err)
+	}
+
+	order := newOrder(nil)
+	order.TableGroupID = &group.TableGroupID
+	if code, msg := resolveOrderTableGroup(ctx, &order); code != http.StatusOK {
+		t.Fatalf("opening an order on the group: got %d: %s", code, msg)
+	}
+	if order.TableID == nil || *order.TableID != first.TableID {
+		t.Errorf("the order was not put on the group's first table")
+	}
+
+	order.TableID = &outside.TableID
+	if code, _ := resolveOrderTableGroup(ctx, &order); code != http.StatusBadRequest {
+		t.Errorf("opening an order on a table outside the group: got %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestTableGroupIsDissolvedOnceSettled(t *testing.T) {
+	ctx := testDatabase(t)
+
+	first, second := newTable(), newTable()
+	fixture(t, ctx, tableCollection, first, second)
+	cleanup(t, ctx, tableGroupCollection, bson.M{"table_ids": first.TableID})
+
+	recorder := serve(CreateTableGroup(), http.MethodPost, "/tableGroups", gin.H{"table_ids": []string{first.TableID, second.TableID}}, "")
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("grouping two tables: got %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var group models.TableGroup
+	if err := json.Unmarshal(recorder.Body.Bytes(), &group); err != nil {
+		t.Fatal(err)
+	}
+
+	order := newOrder(&first.TableID)
+	order.TableGroupID = &group.TableGroupID
+	fixture(t, ctx, orderCollection, order)
+
+	dissolve := func() int {
+		handler := withParam(DissolveTableGroup(), "table_group_id", group.TableGroupID)
+		return serve(handler, http.MethodPatch, "/tableGroups/"+group.TableGroupID+"/dissolve", nil, "").Code
+	}
+
+	if code := dissolve(); code != http.StatusConflict {
+		t.Errorf("dissolving a group with an open order: got %d, want %d", code, http.StatusConflict)
+	}
+
+	if err := closeOrder(ctx, order.OrderID); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := dissolve(); code != http.StatusOK {
+		t.Fatalf("dissolving a settled group: got %d", code)
+	}
+
+	var stored models.TableGroup
+	if err := tableGroupCollection.FindOne(ctx, bson.M{"table_group_id": group.TableGroupID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if *stored.Status != models.TableGroupStatusDissolved || stored.DissolvedAt == nil {
+		t.Errorf("the group was left %s", *stored.Status)
+	}
+
+	if code := dissolve(); code != http.StatusNotFound {
+		t.Errorf("dissolving the group twice: got %d, want %d", code, http.StatusNotFound)
+	}
+}
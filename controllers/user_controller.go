@@ -2,60 +2,46 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
 	"github.com/dastardlyjockey/restaurant-management-backend/models"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"log"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"net/http"
-	"strconv"
 	"time"
 )
 
+// userCredentials are the stored fields that never leave the server
+var userCredentials = bson.M{"password": 0, "token": 0, "refresh_token": 0}
+
 func GetUsers() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		recordPerPage, err := strconv.Atoi(c.Query("recordPerPage"))
-		if err != nil || recordPerPage < 1 {
-			recordPerPage = 10
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at", "first_name", "last_name", "email"}, "created_at", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
 		}
 
-		page, err := strconv.Atoi(c.Query("page"))
-		if err != nil || page < 1 {
-			page = 1
-		}
+		filter := bson.M{}
 
-		startIndex := (page - 1) * recordPerPage
-		startIndex, _ = strconv.Atoi(c.Query("startIndex"))
+		if msg = helpers.DateRangeFilter(c, filter, "created_at"); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
 
-		matchStage := bson.D{{"$match", bson.D{{}}}}
-		ProjectStage := bson.D{{"$project", bson.D{
-			{"_id", 0},
-			{"total_count", 1},
-			{"user_items", bson.D{
-				{"$slice", []interface{}{"$data", startIndex, recordPerPage}},
-			}},
-		}}}
+		listQuery.Projection = userCredentials
 
-		// create a database aggregation
-		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		cursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{matchStage, ProjectStage})
+		users := []models.User{}
+		result, err := listQuery.Find(ctx, UserCollection, filter, &users)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("error occurred while aggregating the database: %v", err.Error())})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the users"})
 			return
 		}
 
-		var allUsers []bson.M
-		err = cursor.All(ctx, &allUsers)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		//response
-		c.JSON(http.StatusOK, allUsers)
-
+		c.JSON(http.StatusOK, result)
 	}
 }
 
@@ -67,7 +53,8 @@ func GetUserById() gin.HandlerFunc {
 		defer cancel()
 
 		var user models.User
-		err := UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+		opt := options.FindOne().SetProjection(userCredentials)
+		err := UserCollection.FindOne(ctx, bson.M{"user_id": userID}, opt).Decode(&user)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve the user"})
 			return
@@ -76,3 +63,64 @@ func GetUserById() gin.HandlerFunc {
 		c.JSON(http.StatusOK, user)
 	}
 }
+
+// UpdateUserRole lets a manager promote or demote staff. The first manager is
+// set up with INITIAL_MANAGER_EMAIL rather than through this endpoint.
+func UpdateUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var request struct {
+			Role *string `json:"role" validate:"required,eq=STAFF|eq=MANAGER"`
+		}
+
+		err := c.BindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role JSON"})
+			return
+		}
+
+		err = validate.Struct(request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "role must be STAFF or MANAGER"})
+			return
+		}
+
+		userID := c.Param("user_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if !userIsManager(ctx, c.GetString("uid")) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "only a manager can change a user's role"})
+			return
+		}
+
+		managers, err := UserCollection.CountDocuments(ctx, bson.M{"role": models.UserRoleManager})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the managers"})
+			return
+		}
+
+		// there always has to be someone left who can approve voids
+		if managers == 1 && *request.Role == models.UserRoleStaff && userIsManager(ctx, userID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "the last manager cannot be demoted"})
+			return
+		}
+
+		updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		result, err := UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, bson.D{{"$set", bson.D{
+			{"role", request.Role},
+			{"updated_at", updatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the user's role"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user was not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "role": request.Role})
+	}
+}
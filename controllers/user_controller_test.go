@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+)
+
+func TestUsersAreListedWithoutCredentials(t *testing.T) {
+	ctx := testDatabase(t)
+
+	user := newUser(models.UserRoleStaff)
+	password, token, refreshToken := "hashed-password", "access-token", "refresh-token"
+	user.Password, user.Token, user.RefreshToken = &password, &token, &refreshToken
+	fixture(t, ctx, UserCollection, user)
+	email := *user.Email
+
+	responses := map[string]string{
+		"list": serve(GetUsers(), http.MethodGet, "/users?limit=100&sort=created_at&order=desc", nil, "").Body.String(),
+		"one":  serve(withParam(GetUserById(), "user_id", user.UserID), http.MethodGet, "/users/"+user.UserID, nil, "").Body.String(),
+	}
+
+	for name, body := range responses {
+		if !strings.Contains(body, email) {
+			t.Errorf("%s: the user is missing from %s", name, body)
+		}
+		for _, secret := range []string{password, token, refreshToken} {
+			if strings.Contains(body, secret) {
+				t.Errorf("%s: the response leaks %q", name, secret)
+			}
+		}
+	}
+}
+
+func TestOnlyManagersChangeRoles(t *testing.T) {
+	ctx := testDatabase(t)
+
+	staff := newUser(models.UserRoleStaff)
+	colleague := newUser(models.UserRoleStaff)
+	manager := newUser(models.UserRoleManager)
+	fixture(t, ctx, UserCollection, staff, colleague, manager)
+
+	promote := func(userID string, by string) int {
+		return serve(withParam(UpdateUserRole(), "user_id", userID), http.MethodPatch, "/users/"+userID+"/role", gin.H{"role": models.UserRoleManager}, by).Code
+	}
+
+	if code := promote(staff.UserID, staff.UserID); code != http.StatusForbidden {
+		t.Errorf("staff promoting themselves: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := promote(colleague.UserID, staff.UserID); code != http.StatusForbidden {
+		t.Errorf("staff promoting a colleague: got %d, want %d", code, http.StatusForbidden)
+	}
+	if code := promote(colleague.UserID, manager.UserID); code != http.StatusOK {
+		t.Errorf("a manager promoting staff: got %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestInitialManagerNeedsTheConfiguredEmail(t *testing.T) {
+	t.Setenv("INITIAL_MANAGER_EMAIL", "")
+	if isInitialManager(context.Background(), "owner@example.com") {
+		t.Error("a user became the first manager without INITIAL_MANAGER_EMAIL set")
+	}
+
+	t.Setenv("INITIAL_MANAGER_EMAIL", "owner@example.com")
+	if isInitialManager(context.Background(), "someone@example.com") {
+		t.Error("a user with another email became the first manager")
+	}
+}
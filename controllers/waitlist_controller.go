@@ -0,0 +1,452 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
+	"github.com/dastardlyjockey/restaurant-management-backend/helpers"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultTurnover is the minutes a party is assumed to stay when there is no order history
+	defaultTurnover = 60
+	// cleaningTime is the minutes allowed to reset a dirty table
+	cleaningTime = 5
+)
+
+var waitlistCollection = database.Collection(database.Client, "waitlist")
+
+// averageTurnover is the average minutes between an order opening and closing over the last week
+func averageTurnover(ctx context.Context) float64 {
+	since := time.Now().AddDate(0, 0, -7)
+
+	matchStage := bson.D{{"$match", bson.D{
+		{"status", models.OrderStatusClosed},
+		{"closed_at", bson.D{{"$gte", since}}},
+	}}}
+	groupStage := bson.D{{"$group", bson.D{
+		{"_id", nil},
+		{"average_turnover", bson.D{{"$avg", bson.D{{"$subtract", []interface{}{"$closed_at", "$created_at"}}}}}},
+	}}}
+
+	cursor, err := orderCollection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage})
+	if err != nil {
+		return defaultTurnover
+	}
+
+	var result []bson.M
+	if err = cursor.All(ctx, &result); err != nil || len(result) == 0 {
+		return defaultTurnover
+	}
+
+	average, ok := result[0]["average_turnover"].(float64)
+	if !ok || average <= 0 {
+		return defaultTurnover
+	}
+
+	// the difference comes back in milliseconds
+	return average / float64(time.Minute/time.Millisecond)
+}
+
+// estimateWait quotes the minutes until a table fits the party, given how many
+// waiting parties that need the same tables are ahead of it in the queue
+func estimateWait(ctx context.Context, partySize int, ahead []models.WaitlistEntry) (int, error) {
+	cursor, err := tableCollection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+
+	var tables []models.Table
+	if err = cursor.All(ctx, &tables); err != nil {
+		return 0, err
+	}
+
+	var fitting []models.Table
+	for _, table := range tables {
+		if tableFits(table, partySize) {
+			fitting = append(fitting, table)
+		}
+	}
+
+	if len(fitting) == 0 {
+		return 0, fmt.Errorf("no table can seat a party of %d", partySize)
+	}
+
+	states, err := tableStates(ctx, fitting)
+	if err != nil {
+		return 0, err
+	}
+
+	turnover := averageTurnover(ctx)
+	now := time.Now()
+
+	// minutes until each fitting table is expected to be ready
+	var readyIn []float64
+	for _, table := range fitting {
+		state := states[table.TableID]
+
+		switch state.Status {
+		case models.TableStatusFree:
+			readyIn = append(readyIn, 0)
+		case models.TableStatusDirty:
+			readyIn = append(readyIn, cleaningTime)
+		default:
+			remaining := turnover
+			if state.SeatedSince != nil {
+				remaining = turnover - now.Sub(*state.SeatedSince).Minutes()
+			}
+			readyIn = append(readyIn, math.Max(remaining, cleaningTime)+cleaningTime)
+		}
+	}
+	sort.Float64s(readyIn)
+
+	// only the parties ahead that compete for the same tables hold this one up
+	position := 0
+	for _, entry := range ahead {
+		for _, table := range fitting {
+			if tableFits(table, *entry.PartySize) {
+				position++
+				break
+			}
+		}
+	}
+
+	rounds := position / len(readyIn)
+	wait := readyIn[position%len(readyIn)] + float64(rounds)*turnover
+
+	return int(math.Ceil(wait)), nil
+}
+
+// partiesAhead lists the waiting parties that joined the queue before the given time
+func partiesAhead(ctx context.Context, before time.Time) ([]models.WaitlistEntry, error) {
+	cursor, err := waitlistCollection.Find(ctx, bson.M{
+		"status":     bson.M{"$in": []string{models.WaitlistStatusWaiting, models.WaitlistStatusNotified}},
+		"created_at": bson.M{"$lt": before},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.WaitlistEntry
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+func AddToWaitlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var entry models.WaitlistEntry
+
+		err := c.BindJSON(&entry)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid waitlist JSON"})
+			return
+		}
+
+		err = validate.Struct(entry)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the validation of the waitlist structure failed"})
+			return
+		}
+
+		entry.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		entry.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// quote the party a wait time
+		ahead, err := partiesAhead(ctx, time.Now())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read the waitlist"})
+			return
+		}
+
+		entry.QuotedWait, err = estimateWait(ctx, *entry.PartySize, ahead)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		entry.EstimatedWait = entry.QuotedWait
+
+		status := models.WaitlistStatusWaiting
+		entry.Status = &status
+		entry.ID = primitive.NewObjectID()
+		entry.WaitlistID = entry.ID.Hex()
+
+		_, err = waitlistCollection.InsertOne(ctx, entry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "The party was not added to the waitlist"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, entry)
+	}
+}
+
+func GetWaitlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		listQuery, msg := helpers.ParseListQuery(c, []string{"created_at"}, "created_at", 1)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+
+		filter := bson.M{"status": bson.M{"$in": []string{models.WaitlistStatusWaiting, models.WaitlistStatusNotified}}}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		entries := []models.WaitlistEntry{}
+		result, err := listQuery.Find(ctx, waitlistCollection, filter, &entries)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "error occurred while listing the waitlist"})
+			return
+		}
+
+		// refresh the estimate for parties still waiting
+		for i := range entries {
+			entry := &entries[i]
+			if *entry.Status != models.WaitlistStatusWaiting && *entry.Status != models.WaitlistStatusNotified {
+				continue
+			}
+
+			ahead, err := partiesAhead(ctx, entry.CreatedAt)
+			if err != nil {
+				continue
+			}
+
+			wait, err := estimateWait(ctx, *entry.PartySize, ahead)
+			if err == nil {
+				entry.EstimatedWait = wait
+			}
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func NotifyWaitlistParty() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		waitlistId := c.Param("waitlist_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		notifiedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		filter := bson.M{"waitlist_id": waitlistId, "status": bson.M{"$in": []string{models.WaitlistStatusWaiting, models.WaitlistStatusNotified}}}
+		result, err := waitlistCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+			{"status", models.WaitlistStatusNotified},
+			{"notified_at", notifiedAt},
+			{"updated_at", notifiedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to notify the party"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the party is not waiting"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func SeatWaitlistParty() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// the host may choose the table, otherwise one is assigned
+		var request struct {
+			TableID *string `json:"table_id"`
+		}
+
+		if c.Request.ContentLength > 0 {
+			err := c.BindJSON(&request)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seating JSON"})
+				return
+			}
+		}
+
+		waitlistId := c.Param("waitlist_id")
+		filter := bson.M{"waitlist_id": waitlistId}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// claim the party first, so two hosts cannot seat it at two tables and a
+		// party that was removed in the meantime is not seated
+		now := time.Now()
+		seatedAt, _ := time.Parse(time.RFC3339, now.Format(time.RFC3339))
+
+		var entry models.WaitlistEntry
+		err := waitlistCollection.FindOneAndUpdate(ctx, bson.M{
+			"waitlist_id": waitlistId,
+			"status":      bson.M{"$in": []string{models.WaitlistStatusWaiting, models.WaitlistStatusNotified}},
+		}, bson.D{{"$set", bson.D{
+			{"status", models.WaitlistStatusSeated},
+			{"updated_at", seatedAt},
+		}}}).Decode(&entry)
+		if err == mongo.ErrNoDocuments {
+			var current models.WaitlistEntry
+			if waitlistCollection.FindOne(ctx, filter).Decode(&current) != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": "waitlist entry was not found"})
+				return
+			}
+			msg := fmt.Sprintf("a %s party cannot be seated", *current.Status)
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the waitlist entry"})
+			return
+		}
+
+		// put the party back in the queue when it cannot be seated after all
+		release := func() {
+			_, err := waitlistCollection.UpdateOne(ctx, bson.M{"waitlist_id": waitlistId, "status": models.WaitlistStatusSeated}, bson.D{{"$set", bson.D{
+				{"status", *entry.Status},
+				{"updated_at", entry.UpdatedAt},
+			}}})
+			if err != nil {
+				log.Println("Error putting the party back on the waitlist:", err)
+			}
+		}
+
+		// the table must fit the party, be free now and not be booked for the next sitting
+		end := now.Add(time.Duration(averageTurnover(ctx)) * time.Minute)
+
+		tables, err := availableTables(ctx, *entry.PartySize, now, end, "")
+		if err != nil {
+			release()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search for a free table"})
+			return
+		}
+
+		var table *models.Table
+		for i := range tables {
+			if request.TableID == nil || tables[i].TableID == *request.TableID {
+				table = &tables[i]
+				break
+			}
+		}
+
+		if table == nil {
+			msg := "no free table can seat the party right now"
+			if request.TableID != nil {
+				msg = "the requested table is not free for the party right now"
+			}
+			release()
+			c.JSON(http.StatusConflict, gin.H{"error": msg})
+			return
+		}
+
+		// claim the table before opening the order, so two hosts seating parties at
+		// the same moment cannot both take it
+		var order models.Order
+		order.ID = primitive.NewObjectID()
+		orderID := order.ID.Hex()
+
+		claim, err := tableCollection.UpdateOne(ctx, bson.M{
+			"table_id":        table.TableID,
+			"seated_order_id": nil,
+			"needs_cleaning":  bson.M{"$ne": true},
+		}, bson.D{{"$set", bson.D{
+			{"seated_order_id", orderID},
+			{"number_of_guests", *entry.PartySize},
+			{"updated_at", seatedAt},
+		}}})
+		if err != nil {
+			release()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the table"})
+			return
+		}
+
+		if claim.MatchedCount == 0 {
+			release()
+			c.JSON(http.StatusConflict, gin.H{"error": "the table was taken by another party, please try again"})
+			return
+		}
+
+		// open an order on the table for the party
+		order.TableID = &table.TableID
+		order.CreatedBy = c.GetString("uid")
+		order.OrderDate, _ = time.Parse(time.RFC3339, now.Format(time.RFC3339))
+		_, err = OrderItemOrderCreator(order)
+		if err != nil {
+			_, releaseErr := tableCollection.UpdateOne(ctx, bson.M{"table_id": table.TableID, "seated_order_id": orderID}, bson.D{{"$set", bson.D{
+				{"seated_order_id", nil},
+			}}})
+			if releaseErr != nil {
+				log.Println("Error releasing the table after the order failed:", releaseErr)
+			}
+			release()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open an order for the party"})
+			return
+		}
+
+		status := models.WaitlistStatusSeated
+		entry.Status = &status
+		entry.TableID = &table.TableID
+		entry.OrderID = &orderID
+		entry.SeatedAt = &seatedAt
+		entry.UpdatedAt = seatedAt
+
+		_, err = waitlistCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+			{"status", status},
+			{"table_id", table.TableID},
+			{"order_id", orderID},
+			{"seated_at", seatedAt},
+			{"updated_at", seatedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the waitlist entry"})
+			return
+		}
+
+		c.JSON(http.StatusOK, entry)
+	}
+}
+
+func RemoveFromWaitlist() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		waitlistId := c.Param("waitlist_id")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		removedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		filter := bson.M{"waitlist_id": waitlistId, "status": bson.M{"$in": []string{models.WaitlistStatusWaiting, models.WaitlistStatusNotified}}}
+		result, err := waitlistCollection.UpdateOne(ctx, filter, bson.D{{"$set", bson.D{
+			{"status", models.WaitlistStatusRemoved},
+			{"updated_at", removedAt},
+		}}})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove the party from the waitlist"})
+			return
+		}
+
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "the party is not waiting"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
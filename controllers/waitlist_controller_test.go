@@ -0,0 +1,80 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newWaitlistEntry(partySize int) models.WaitlistEntry {
+	now := time.Now()
+	name, phone, status := "Test guest", "0123456789", models.WaitlistStatusWaiting
+	entry := models.WaitlistEntry{ID: primitive.NewObjectID(), GuestName: &name, Phone: &phone, PartySize: &partySize, Status: &status, CreatedAt: now, UpdatedAt: now}
+	entry.WaitlistID = entry.ID.Hex()
+	return entry
+}
+
+func TestWaitlistPartyIsSeatedOnce(t *testing.T) {
+	ctx := testDatabase(t)
+
+	first, second := newTable(), newTable()
+	entry := newWaitlistEntry(2)
+	fixture(t, ctx, tableCollection, first, second)
+	fixture(t, ctx, waitlistCollection, entry)
+	cleanup(t, ctx, orderCollection, bson.M{"table_id": bson.M{"$in": bson.A{first.TableID, second.TableID}}})
+
+	seat := func(tableID string) int {
+		handler := withParam(SeatWaitlistParty(), "waitlist_id", entry.WaitlistID)
+		return serve(handler, http.MethodPatch, "/waitlist/"+entry.WaitlistID+"/seat", gin.H{"table_id": tableID}, "").Code
+	}
+
+	if code := seat(first.TableID); code != http.StatusOK {
+		t.Fatalf("seating the party: got %d", code)
+	}
+
+	if code := seat(second.TableID); code != http.StatusConflict {
+		t.Errorf("seating the party a second time: got %d, want %d", code, http.StatusConflict)
+	}
+
+	var table models.Table
+	if err := tableCollection.FindOne(ctx, bson.M{"table_id": second.TableID}).Decode(&table); err != nil {
+		t.Fatal(err)
+	}
+	if table.SeatedOrderID != nil {
+		t.Errorf("the second table was taken by a party that was already seated")
+	}
+
+	// the seated table already carries the party's order
+	order := newOrder(&first.TableID)
+	recorder := serve(CreateOrder(), http.MethodPost, "/orders", order, "")
+	if recorder.Code != http.StatusConflict {
+		t.Errorf("opening a second order on a seated table: got %d, want %d", recorder.Code, http.StatusConflict)
+	}
+}
+
+func TestWaitlistPartyGoesBackWhenNoTableIsFree(t *testing.T) {
+	ctx := testDatabase(t)
+
+	entry := newWaitlistEntry(2)
+	fixture(t, ctx, waitlistCollection, entry)
+
+	// no table is registered under this id, so the party cannot be seated
+	handler := withParam(SeatWaitlistParty(), "waitlist_id", entry.WaitlistID)
+	recorder := serve(handler, http.MethodPatch, "/waitlist/"+entry.WaitlistID+"/seat", gin.H{"table_id": primitive.NewObjectID().Hex()}, "")
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("seating the party at an unknown table: got %d, want %d", recorder.Code, http.StatusConflict)
+	}
+
+	var stored models.WaitlistEntry
+	if err := waitlistCollection.FindOne(ctx, bson.M{"waitlist_id": entry.WaitlistID}).Decode(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if *stored.Status != models.WaitlistStatusWaiting {
+		t.Errorf("the party was left %s, want it back in the queue", *stored.Status)
+	}
+}
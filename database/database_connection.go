@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"log"
@@ -11,13 +13,22 @@ import (
 	"time"
 )
 
+// defaultURL is a MongoDB on this machine running as the one member replica set rs0
+const defaultURL = "mongodb://localhost:27017/?replicaSet=rs0"
+
+// DBInstance connects to DB_URL. Invoices are numbered in a transaction, so the
+// server has to be a replica set, e.g. mongodb://localhost:27017/?replicaSet=rs0
 func DBInstance() *mongo.Client {
+	// the .env file is optional when DB_URL is set in the environment
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("error loading database environment variable")
+		log.Println("No .env file, reading the database settings from the environment")
 	}
 
 	db := os.Getenv("DB_URL")
+	if db == "" {
+		db = defaultURL
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -40,8 +51,49 @@ func DBInstance() *mongo.Client {
 
 var Client = DBInstance()
 
+// RequireReplicaSet makes sure the server can run transactions, which only replica
+// sets and sharded clusters can, so a standalone server is caught when the
+// application starts rather than on the first invoice
+func RequireReplicaSet(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var hello bson.M
+	err := client.Database("admin").RunCommand(ctx, bson.D{{"hello", 1}}).Decode(&hello)
+	if err != nil {
+		return fmt.Errorf("checking the MongoDB server: %w", err)
+	}
+
+	return checkReplicaSet(hello)
+}
+
+// checkReplicaSet reads the reply to the hello command: a replica set member names
+// its set and a mongos router of a sharded cluster says it is one
+func checkReplicaSet(hello bson.M) error {
+	if name, ok := hello["setName"].(string); ok && name != "" {
+		return nil
+	}
+
+	if hello["msg"] == "isdbgrid" {
+		return nil
+	}
+
+	return errors.New("MongoDB is running as a standalone server, but invoices are numbered in " +
+		"transactions, which need a replica set: start mongod with --replSet rs0, run rs.initiate() " +
+		"once in mongosh and point DB_URL at it with ?replicaSet=rs0")
+}
+
+// databaseName is read from DB_NAME so the tests can run against their own database
+func databaseName() string {
+	name := os.Getenv("DB_NAME")
+	if name == "" {
+		name = "Restaurant Collection"
+	}
+	return name
+}
+
 func Collection(client *mongo.Client, collectionName string) *mongo.Collection {
-	collection := client.Database("Restaurant Collection").Collection(collectionName)
+	collection := client.Database(databaseName()).Collection(collectionName)
 	return collection
 }
 
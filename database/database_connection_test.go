@@ -0,0 +1,25 @@
+package database
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCheckReplicaSet(t *testing.T) {
+	tests := []struct {
+		name  string
+		hello bson.M
+		ok    bool
+	}{
+		{"replica set member", bson.M{"isWritablePrimary": true, "setName": "rs0"}, true},
+		{"sharded cluster", bson.M{"isWritablePrimary": true, "msg": "isdbgrid"}, true},
+		{"standalone server", bson.M{"isWritablePrimary": true}, false},
+	}
+
+	for _, test := range tests {
+		if err := checkReplicaSet(test.hello); (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+		}
+	}
+}
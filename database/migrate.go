@@ -0,0 +1,333 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// fieldNamesMigration moves documents written before the models had bson tags,
+// when the driver stored every field under its lowercased Go name (tablenumber),
+// onto the snake_case names the queries use (table_number)
+const fieldNamesMigration = "snake_case-field-names"
+
+// strayMenusMigration moves the menus CreateMenu used to insert into the food
+// collection over to the menu collection, where the menu queries look for them
+const strayMenusMigration = "menus-out-of-food"
+
+// migratedCollections lists each collection with the model its documents hold.
+// Counters are fields that were only ever changed with $inc: an update to one of
+// them went to the snake_case name as a change from zero, so it is added to the
+// value stored under the old name rather than replacing it.
+var migratedCollections = []struct {
+	name     string
+	model    interface{}
+	counters []string
+}{
+	{"food", models.Food{}, []string{"remaining_portions"}},
+	{"menu", models.Menu{}, nil},
+	{"table", models.Table{}, nil},
+	{"tableGroups", models.TableGroup{}, nil},
+	{"orders", models.Order{}, nil},
+	{"orderItems", models.OrderItem{}, nil},
+	{"invoice", models.Invoice{}, nil},
+	{"payments", models.Payment{}, []string{"refunded"}},
+	{"refunds", models.Refund{}, nil},
+	{"auditLog", models.AuditEntry{}, nil},
+	{"users", models.User{}, nil},
+	{"images", models.Image{}, nil},
+	{"reservations", models.Reservation{}, nil},
+	{"waitlist", models.WaitlistEntry{}, nil},
+	{"assignments", models.ServerAssignment{}, nil},
+	{"ingredients", models.Ingredient{}, []string{"stock_level"}},
+	{"recipes", models.Recipe{}, nil},
+	{"stockMovements", models.StockMovement{}, nil},
+	{"stockTakes", models.StockTake{}, nil},
+	{"suppliers", models.Supplier{}, nil},
+	{"purchaseOrders", models.PurchaseOrder{}, nil},
+	{"settings", models.Settings{}, nil},
+	{"promotions", models.Promotion{}, []string{"usage_count"}},
+	{"cashSessions", models.CashSession{}, nil},
+}
+
+// Migrate brings the database up to date when the application starts: it moves
+// the menus stored in food and renames the fields of documents stored under the
+// old names, once each, and makes sure the indexes the controllers rely on exist
+func Migrate(client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	migrations := Collection(client, "migrations")
+
+	count, err := migrations.CountDocuments(ctx, bson.M{"_id": strayMenusMigration})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		moved, err := moveStrayMenus(ctx, Collection(client, "food"), Collection(client, "menu"))
+		if err != nil {
+			return fmt.Errorf("moving the menus out of food: %w", err)
+		}
+		if moved > 0 {
+			log.Printf("Moved %d menus out of food", moved)
+		}
+
+		appliedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = migrations.InsertOne(ctx, bson.D{{"_id", strayMenusMigration}, {"applied_at", appliedAt}})
+		if err != nil {
+			return err
+		}
+	}
+
+	count, err = migrations.CountDocuments(ctx, bson.M{"_id": fieldNamesMigration})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		for _, migrated := range migratedCollections {
+			renamed, err := renameLegacyFields(ctx, Collection(client, migrated.name), reflect.TypeOf(migrated.model), migrated.counters)
+			if err != nil {
+				return fmt.Errorf("renaming the fields of %s: %w", migrated.name, err)
+			}
+			if renamed > 0 {
+				log.Printf("Renamed the fields of %d documents in %s", renamed, migrated.name)
+			}
+		}
+
+		appliedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+		_, err = migrations.InsertOne(ctx, bson.D{{"_id", fieldNamesMigration}, {"applied_at", appliedAt}})
+		if err != nil {
+			return err
+		}
+	}
+
+	// purchase orders are locked on their version, which older ones were stored without
+	_, err = Collection(client, "purchaseOrders").UpdateMany(ctx,
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.D{{"$set", bson.D{{"version", 0}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("versioning the purchase orders: %w", err)
+	}
+
+	// table numbers are unique within the restaurant
+	_, err = Collection(client, "table").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"table_number", 1}},
+		Options: options.Index().SetUnique(true).SetName("table_number_unique"),
+	})
+	if err != nil {
+		return fmt.Errorf("indexing the table numbers, check for tables sharing a number: %w", err)
+	}
+
+	return nil
+}
+
+func renameLegacyFields(ctx context.Context, collection *mongo.Collection, model reflect.Type, counters []string) (int, error) {
+	isCounter := make(map[string]bool)
+	for _, counter := range counters {
+		isCounter[counter] = true
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	renamed := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err = cursor.Decode(&doc); err != nil {
+			return renamed, err
+		}
+
+		if !renameDocument(doc, model, isCounter) {
+			continue
+		}
+
+		_, err = collection.ReplaceOne(ctx, bson.M{"_id": doc["_id"]}, doc)
+		if err != nil {
+			return renamed, err
+		}
+		renamed++
+	}
+
+	return renamed, cursor.Err()
+}
+
+// moveStrayMenus copies every menu found in the food collection into the menu
+// collection, under the menu's field names, and then deletes it from food
+func moveStrayMenus(ctx context.Context, food *mongo.Collection, menu *mongo.Collection) (int, error) {
+	cursor, err := food.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	moved := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err = cursor.Decode(&doc); err != nil {
+			return moved, err
+		}
+
+		if !isMenuDocument(doc) {
+			continue
+		}
+
+		renameDocument(doc, reflect.TypeOf(models.Menu{}), nil)
+
+		// replacing on _id lets a run that stopped half way be picked up again
+		_, err = menu.ReplaceOne(ctx, bson.M{"_id": doc["_id"]}, doc, options.Replace().SetUpsert(true))
+		if err != nil {
+			return moved, err
+		}
+
+		_, err = food.DeleteOne(ctx, bson.M{"_id": doc["_id"]})
+		if err != nil {
+			return moved, err
+		}
+		moved++
+	}
+
+	return moved, cursor.Err()
+}
+
+// isMenuDocument tells a menu apart from a food: every food has a price, and only
+// menus have a category
+func isMenuDocument(doc bson.M) bool {
+	_, hasCategory := doc["category"]
+	_, hasPrice := doc["price"]
+	return hasCategory && !hasPrice
+}
+
+// renameDocument moves the fields of one document, and of the documents nested in
+// it, from their lowercased Go names to their bson names
+func renameDocument(doc bson.M, model reflect.Type, isCounter map[string]bool) bool {
+	names := make(map[string]bool)
+	for i := 0; i < model.NumField(); i++ {
+		names[bsonName(model.Field(i))] = true
+	}
+
+	changed := false
+	for i := 0; i < model.NumField(); i++ {
+		field := model.Field(i)
+		name := bsonName(field)
+		legacy := strings.ToLower(field.Name)
+
+		// a legacy name that is also a current name is left where it is
+		if legacy != name && !names[legacy] {
+			if old, ok := doc[legacy]; ok {
+				current, exists := doc[name]
+				switch {
+				case !exists:
+					doc[name] = old
+				case isCounter[name]:
+					doc[name] = addNumbers(old, current)
+				}
+				delete(doc, legacy)
+				changed = true
+			}
+		}
+
+		if value, ok := doc[name]; ok && renameNested(value, field.Type) {
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+func renameNested(value interface{}, fieldType reflect.Type) bool {
+	for fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	switch fieldType.Kind() {
+	case reflect.Struct:
+		doc, ok := value.(bson.M)
+		if !ok || fieldType == reflect.TypeOf(time.Time{}) {
+			return false
+		}
+		return renameDocument(doc, fieldType, nil)
+	case reflect.Map:
+		entries, ok := value.(bson.M)
+		if !ok {
+			return false
+		}
+
+		changed := false
+		for _, entry := range entries {
+			if renameNested(entry, fieldType.Elem()) {
+				changed = true
+			}
+		}
+		return changed
+	case reflect.Slice:
+		items, ok := value.(bson.A)
+		if !ok {
+			return false
+		}
+
+		changed := false
+		for _, item := range items {
+			if renameNested(item, fieldType.Elem()) {
+				changed = true
+			}
+		}
+		return changed
+	}
+
+	return false
+}
+
+func bsonName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("bson"), ",")[0]
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func addNumbers(a interface{}, b interface{}) interface{} {
+	toInt := func(value interface{}) (int64, bool) {
+		switch number := value.(type) {
+		case int32:
+			return int64(number), true
+		case int64:
+			return number, true
+		}
+		return 0, false
+	}
+
+	toFloat := func(value interface{}) (float64, bool) {
+		if number, ok := toInt(value); ok {
+			return float64(number), true
+		}
+		number, ok := value.(float64)
+		return number, ok
+	}
+
+	x, okA := toInt(a)
+	y, okB := toInt(b)
+	if okA && okB {
+		return x + y
+	}
+
+	f, okA := toFloat(a)
+	g, okB := toFloat(b)
+	if !okA || !okB {
+		return b
+	}
+
+	return f + g
+}
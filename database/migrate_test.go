@@ -0,0 +1,121 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func decodeDocument(t *testing.T, doc bson.M) bson.M {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded bson.M
+	if err = bson.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestRenameDocument(t *testing.T) {
+	doc := decodeDocument(t, bson.M{
+		"_id":            "1",
+		"tableid":        "abc",
+		"table_id":       "abc",
+		"tablenumber":    4,
+		"needs_cleaning": true,
+	})
+
+	if !renameDocument(doc, reflect.TypeOf(models.Table{}), nil) {
+		t.Fatal("expected the table to be renamed")
+	}
+
+	if doc["table_number"] != int32(4) || doc["table_id"] != "abc" || doc["needs_cleaning"] != true {
+		t.Errorf("unexpected fields %v", doc)
+	}
+	if _, ok := doc["tablenumber"]; ok {
+		t.Errorf("legacy field was kept: %v", doc)
+	}
+	if renameDocument(doc, reflect.TypeOf(models.Table{}), nil) {
+		t.Error("a migrated document should be left alone")
+	}
+}
+
+func TestRenameDocumentAddsCounters(t *testing.T) {
+	doc := decodeDocument(t, bson.M{
+		"remainingportions":  int32(10),
+		"remaining_portions": int32(-3),
+	})
+
+	renameDocument(doc, reflect.TypeOf(models.Food{}), map[string]bool{"remaining_portions": true})
+
+	if doc["remaining_portions"] != int64(7) {
+		t.Errorf("remaining_portions = %v, want 7", doc["remaining_portions"])
+	}
+}
+
+func TestRenameDocumentNested(t *testing.T) {
+	doc := decodeDocument(t, bson.M{
+		"lines": bson.A{bson.M{"ingredientid": "i1", "orderedquantity": 2.5}},
+	})
+
+	renameDocument(doc, reflect.TypeOf(models.PurchaseOrder{}), nil)
+
+	lines, ok := doc["lines"].(bson.A)
+	if !ok || len(lines) != 1 {
+		t.Fatalf("lines = %v", doc["lines"])
+	}
+	if line := lines[0].(bson.M); line["ingredient_id"] != "i1" || line["ordered_quantity"] != 2.5 {
+		t.Errorf("nested line not renamed: %v", line)
+	}
+}
+
+func TestRenameDocumentMapValues(t *testing.T) {
+	doc := decodeDocument(t, bson.M{
+		"imageid":  "i1",
+		"variants": bson.M{"thumbnail": bson.M{"key": "images/i1/thumbnail.jpg", "contenttype": "image/jpeg"}},
+	})
+
+	renameDocument(doc, reflect.TypeOf(models.Image{}), nil)
+
+	variant := doc["variants"].(bson.M)["thumbnail"].(bson.M)
+	if doc["image_id"] != "i1" || variant["content_type"] != "image/jpeg" {
+		t.Errorf("image not renamed: %v", doc)
+	}
+}
+
+func TestIsMenuDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  bson.M
+		want bool
+	}{
+		{"menu", bson.M{"name": "Lunch", "category": "Main", "menu_id": "m1"}, true},
+		{"legacy menu", bson.M{"name": "Lunch", "category": "Main", "menuid": "m1", "startdate": nil}, true},
+		{"food", bson.M{"name": "Soup", "price": 4.5, "food_id": "f1", "menu_id": "m1"}, false},
+		{"legacy food", bson.M{"name": "Soup", "price": 4.5, "foodid": "f1", "menuid": "m1"}, false},
+	}
+
+	for _, test := range tests {
+		if got := isMenuDocument(test.doc); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStrayMenuIsRenamed(t *testing.T) {
+	doc := decodeDocument(t, bson.M{"name": "Lunch", "category": "Main", "menuid": "m1", "startdate": nil})
+
+	renameDocument(doc, reflect.TypeOf(models.Menu{}), nil)
+
+	if doc["menu_id"] != "m1" {
+		t.Errorf("menu_id = %v, want m1", doc["menu_id"])
+	}
+	if _, ok := doc["start_date"]; !ok {
+		t.Errorf("start_date was not renamed: %v", doc)
+	}
+}
@@ -0,0 +1,156 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Card tokens the fake provider treats specially so declines and timeouts can be tried out
+const (
+	FakeTokenDeclined          = "tok_declined"
+	FakeTokenInsufficientFunds = "tok_insufficient_funds"
+	FakeTokenTimeout           = "tok_timeout"
+)
+
+// FakeProvider is an in-process provider for development and testing. Every
+// card is approved apart from the tokens above, and nothing leaves the server.
+type FakeProvider struct {
+	mu           sync.Mutex
+	transactions map[string]*Transaction
+	idempotency  map[string]string
+	next         int
+	// Delay is how long the provider takes to answer a timed out request
+	Delay time.Duration
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		transactions: make(map[string]*Transaction),
+		idempotency:  make(map[string]string),
+		Delay:        5 * time.Second,
+	}
+}
+
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *FakeProvider) Authorize(ctx context.Context, request AuthorizeRequest) (Transaction, error) {
+	switch request.CardToken {
+	case FakeTokenDeclined:
+		return Transaction{}, &DeclineError{Reason: "card declined"}
+	case FakeTokenInsufficientFunds:
+		return Transaction{}, &DeclineError{Reason: "insufficient funds"}
+	case FakeTokenTimeout:
+		select {
+		case <-time.After(p.Delay):
+		case <-ctx.Done():
+		}
+		return Transaction{}, ErrTimeout
+	}
+
+	if request.Amount <= 0 {
+		return Transaction{}, &DeclineError{Reason: "invalid amount"}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if request.IdempotencyKey != "" {
+		if id, ok := p.idempotency[request.IdempotencyKey]; ok {
+			return *p.transactions[id], nil
+		}
+	}
+
+	p.next++
+	transaction := &Transaction{
+		ID:     fmt.Sprintf("fake_txn_%d_%d", time.Now().Unix(), p.next),
+		Status: StatusAuthorized,
+		Amount: request.Amount,
+	}
+	p.transactions[transaction.ID] = transaction
+
+	if request.IdempotencyKey != "" {
+		p.idempotency[request.IdempotencyKey] = transaction.ID
+	}
+
+	return *transaction, nil
+}
+
+func (p *FakeProvider) Capture(ctx context.Context, transactionID string, amount float64) (Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	transaction, ok := p.transactions[transactionID]
+	if !ok {
+		return Transaction{}, ErrNotFound
+	}
+
+	// capturing twice is harmless, which keeps retries safe
+	if transaction.Status == StatusCaptured {
+		return *transaction, nil
+	}
+
+	if transaction.Status != StatusAuthorized {
+		return Transaction{}, &DeclineError{Reason: "only an authorized transaction can be captured"}
+	}
+
+	if amount > transaction.Amount {
+		return Transaction{}, &DeclineError{Reason: "cannot capture more than was authorized"}
+	}
+
+	transaction.Status = StatusCaptured
+	transaction.Captured = amount
+
+	return *transaction, nil
+}
+
+func (p *FakeProvider) Void(ctx context.Context, transactionID string) (Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	transaction, ok := p.transactions[transactionID]
+	if !ok {
+		return Transaction{}, ErrNotFound
+	}
+
+	if transaction.Status == StatusVoided {
+		return *transaction, nil
+	}
+
+	if transaction.Status != StatusAuthorized {
+		return Transaction{}, &DeclineError{Reason: "a captured transaction has to be refunded"}
+	}
+
+	transaction.Status = StatusVoided
+
+	return *transaction, nil
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, transactionID string, amount float64) (Transaction, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	transaction, ok := p.transactions[transactionID]
+	if !ok {
+		return Transaction{}, ErrNotFound
+	}
+
+	if transaction.Status != StatusCaptured && transaction.Status != StatusRefunded {
+		return Transaction{}, &DeclineError{Reason: "only a captured transaction can be refunded"}
+	}
+
+	if amount <= 0 || math.Round((transaction.Refunded+amount)*100) > math.Round(transaction.Captured*100) {
+		return Transaction{}, &DeclineError{Reason: "cannot refund more than was captured"}
+	}
+
+	transaction.Refunded += amount
+	if math.Round(transaction.Refunded*100) == math.Round(transaction.Captured*100) {
+		transaction.Status = StatusRefunded
+	}
+
+	return *transaction, nil
+}
@@ -0,0 +1,233 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFakeAuthorize(t *testing.T) {
+	tests := []struct {
+		name    string
+		request AuthorizeRequest
+		decline string
+		timeout bool
+	}{
+		{"approved", AuthorizeRequest{Amount: 25.5, CardToken: "tok_visa"}, "", false},
+		{"declined", AuthorizeRequest{Amount: 25.5, CardToken: FakeTokenDeclined}, "card declined", false},
+		{"insufficient funds", AuthorizeRequest{Amount: 25.5, CardToken: FakeTokenInsufficientFunds}, "insufficient funds", false},
+		{"zero amount", AuthorizeRequest{Amount: 0, CardToken: "tok_visa"}, "invalid amount", false},
+		{"timeout", AuthorizeRequest{Amount: 25.5, CardToken: FakeTokenTimeout}, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := NewFakeProvider()
+			provider.Delay = time.Millisecond
+
+			transaction, err := provider.Authorize(context.Background(), test.request)
+
+			var decline *DeclineError
+			switch {
+			case test.timeout:
+				if !errors.Is(err, ErrTimeout) {
+					t.Fatalf("got %v, want a timeout", err)
+				}
+			case test.decline != "":
+				if !errors.As(err, &decline) || decline.Reason != test.decline {
+					t.Fatalf("got %v, want a decline for %q", err, test.decline)
+				}
+			default:
+				if err != nil {
+					t.Fatal(err)
+				}
+				if transaction.Status != StatusAuthorized || transaction.Amount != test.request.Amount {
+					t.Fatalf("got %+v, want an authorization for %v", transaction, test.request.Amount)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeAuthorizeTimeoutStopsWithTheContext(t *testing.T) {
+	provider := NewFakeProvider()
+	provider.Delay = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := provider.Authorize(ctx, AuthorizeRequest{Amount: 10, CardToken: FakeTokenTimeout})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("the timeout ignored the context deadline")
+	}
+}
+
+func TestFakeAuthorizeIsIdempotent(t *testing.T) {
+	provider := NewFakeProvider()
+	ctx := context.Background()
+
+	first, err := provider.Authorize(ctx, AuthorizeRequest{Amount: 40, CardToken: "tok_visa", IdempotencyKey: "payment-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	retry, err := provider.Authorize(ctx, AuthorizeRequest{Amount: 40, CardToken: "tok_visa", IdempotencyKey: "payment-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.ID != first.ID {
+		t.Errorf("a retry with the same key authorized again: %s and %s", first.ID, retry.ID)
+	}
+
+	other, err := provider.Authorize(ctx, AuthorizeRequest{Amount: 40, CardToken: "tok_visa", IdempotencyKey: "payment-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.ID == first.ID {
+		t.Error("a different key returned the same transaction")
+	}
+}
+
+func TestFakeCapture(t *testing.T) {
+	tests := []struct {
+		name     string
+		captures []float64
+		captured float64
+		decline  bool
+	}{
+		{"full", []float64{50}, 50, false},
+		{"partial", []float64{30}, 30, false},
+		{"more than authorized", []float64{60}, 0, true},
+		{"twice", []float64{50, 50}, 50, false},
+		{"twice with another amount", []float64{50, 20}, 50, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := NewFakeProvider()
+			ctx := context.Background()
+
+			authorized, err := provider.Authorize(ctx, AuthorizeRequest{Amount: 50, CardToken: "tok_visa"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var transaction Transaction
+			for _, amount := range test.captures {
+				transaction, err = provider.Capture(ctx, authorized.ID, amount)
+			}
+
+			var decline *DeclineError
+			if test.decline {
+				if !errors.As(err, &decline) {
+					t.Fatalf("got %v, want a decline", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if transaction.Status != StatusCaptured || transaction.Captured != test.captured {
+				t.Errorf("got %+v, want %v captured", transaction, test.captured)
+			}
+		})
+	}
+}
+
+func TestFakeCaptureUnknownOrVoided(t *testing.T) {
+	provider := NewFakeProvider()
+	ctx := context.Background()
+
+	if _, err := provider.Capture(ctx, "fake_txn_missing", 10); !errors.Is(err, ErrNotFound) {
+		t.Errorf("capturing an unknown transaction: got %v, want not found", err)
+	}
+
+	authorized, _ := provider.Authorize(ctx, AuthorizeRequest{Amount: 10, CardToken: "tok_visa"})
+	if _, err := provider.Void(ctx, authorized.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var decline *DeclineError
+	if _, err := provider.Capture(ctx, authorized.ID, 10); !errors.As(err, &decline) {
+		t.Errorf("capturing a voided transaction: got %v, want a decline", err)
+	}
+}
+
+func TestFakeRefund(t *testing.T) {
+	tests := []struct {
+		name     string
+		refunds  []float64
+		refunded float64
+		status   string
+		decline  bool
+	}{
+		{"partial", []float64{10}, 10, StatusCaptured, false},
+		{"in parts up to the total", []float64{10, 15.25, 4.75}, 30, StatusRefunded, false},
+		{"full", []float64{30}, 30, StatusRefunded, false},
+		{"over the captured amount", []float64{30.01}, 0, "", true},
+		{"over in the last part", []float64{20, 10.01}, 20, "", true},
+		{"zero", []float64{0}, 0, "", true},
+		{"after a full refund", []float64{30, 1}, 30, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := NewFakeProvider()
+			ctx := context.Background()
+
+			authorized, err := provider.Authorize(ctx, AuthorizeRequest{Amount: 30, CardToken: "tok_visa"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = provider.Capture(ctx, authorized.ID, 30); err != nil {
+				t.Fatal(err)
+			}
+
+			var transaction Transaction
+			for _, amount := range test.refunds {
+				transaction, err = provider.Refund(ctx, authorized.ID, amount)
+				if err != nil {
+					break
+				}
+			}
+
+			var decline *DeclineError
+			if test.decline {
+				if !errors.As(err, &decline) {
+					t.Fatalf("got %v, want a decline", err)
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if transaction.Status != test.status {
+					t.Errorf("status %s, want %s", transaction.Status, test.status)
+				}
+			}
+
+			// a refused refund leaves the amount already refunded as it was
+			stored := provider.transactions[authorized.ID]
+			if stored.Refunded != test.refunded {
+				t.Errorf("refunded %v, want %v", stored.Refunded, test.refunded)
+			}
+		})
+	}
+}
+
+func TestFakeRefundBeforeCapture(t *testing.T) {
+	provider := NewFakeProvider()
+	ctx := context.Background()
+
+	authorized, _ := provider.Authorize(ctx, AuthorizeRequest{Amount: 30, CardToken: "tok_visa"})
+
+	var decline *DeclineError
+	if _, err := provider.Refund(ctx, authorized.ID, 10); !errors.As(err, &decline) {
+		t.Errorf("refunding an uncaptured transaction: got %v, want a decline", err)
+	}
+}
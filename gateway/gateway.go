@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/joho/godotenv"
+	"log"
+	"os"
+)
+
+const (
+	StatusAuthorized = "AUTHORIZED"
+	StatusCaptured   = "CAPTURED"
+	StatusVoided     = "VOIDED"
+	StatusRefunded   = "REFUNDED"
+)
+
+// ErrTimeout is returned when the provider did not answer in time; the charge may or may not have gone through
+var ErrTimeout = errors.New("gateway: the payment provider timed out")
+
+// ErrNotFound is returned for a transaction the provider does not know about
+var ErrNotFound = errors.New("gateway: transaction not found")
+
+// DeclineError is returned when the card issuer or provider refuses a transaction
+type DeclineError struct {
+	Reason string
+}
+
+func (e *DeclineError) Error() string {
+	return fmt.Sprintf("gateway: declined: %s", e.Reason)
+}
+
+// AuthorizeRequest asks the provider to hold an amount on a card. Retrying with
+// the same IdempotencyKey returns the original transaction instead of charging twice.
+type AuthorizeRequest struct {
+	Amount         float64
+	CardToken      string
+	Reference      string
+	IdempotencyKey string
+}
+
+// Transaction is the provider's record of a card payment
+type Transaction struct {
+	ID       string
+	Status   string
+	Amount   float64
+	Captured float64
+	Refunded float64
+}
+
+// Provider is a card payment processor
+type Provider interface {
+	Name() string
+	Authorize(ctx context.Context, request AuthorizeRequest) (Transaction, error)
+	Capture(ctx context.Context, transactionID string, amount float64) (Transaction, error)
+	Void(ctx context.Context, transactionID string) (Transaction, error)
+	Refund(ctx context.Context, transactionID string, amount float64) (Transaction, error)
+}
+
+// Default is the provider card payments go through, picked with PAYMENT_PROVIDER
+var Default = defaultProvider()
+
+func defaultProvider() Provider {
+	_ = godotenv.Load()
+
+	switch os.Getenv("PAYMENT_PROVIDER") {
+	case "", "fake":
+		return NewFakeProvider()
+	default:
+		log.Fatal("unknown PAYMENT_PROVIDER: ", os.Getenv("PAYMENT_PROVIDER"))
+		return nil
+	}
+}
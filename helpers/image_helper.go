@@ -0,0 +1,91 @@
+package helpers
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	// register the decoders for the formats accepted on upload
+	_ "image/gif"
+)
+
+const (
+	ImageSizeOriginal  = "original"
+	ImageSizeMedium    = "medium"
+	ImageSizeThumbnail = "thumbnail"
+)
+
+// ImageSizes is the longest side in pixels of each resized copy of an upload
+var ImageSizes = map[string]int{
+	ImageSizeMedium:    600,
+	ImageSizeThumbnail: 150,
+}
+
+// ResizeImage scales an image down so its longest side is at most maxSide,
+// averaging the source pixels that fall under each destination pixel.
+// Images that are already small enough are returned as they are.
+func ResizeImage(src image.Image, maxSide int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxSide && height <= maxSide {
+		return src
+	}
+
+	dstWidth, dstHeight := maxSide, height*maxSide/width
+	if height > width {
+		dstWidth, dstHeight = width*maxSide/height, maxSide
+	}
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	rgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := y*height/dstHeight, (y+1)*height/dstHeight
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := x*width/dstWidth, (x+1)*width/dstWidth
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					a += int(row[sx*4+3])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// EncodeImage writes the image as a JPEG when the upload was one, otherwise as a PNG,
+// and returns the encoded bytes with their content type
+func EncodeImage(img image.Image, uploadedType string) ([]byte, string, error) {
+	var buf bytes.Buffer
+
+	if uploadedType == "image/jpeg" {
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+		return buf.Bytes(), "image/jpeg", err
+	}
+
+	err := png.Encode(&buf, img)
+	return buf.Bytes(), "image/png", err
+}
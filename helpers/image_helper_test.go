@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestResizeImage(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		maxSide       int
+		wantW, wantH  int
+	}{
+		{"already small", 100, 50, 150, 100, 50},
+		{"landscape", 1200, 600, 600, 600, 300},
+		{"portrait", 300, 900, 150, 50, 150},
+		{"very thin", 3000, 2, 150, 150, 1},
+	}
+
+	for _, test := range tests {
+		src := image.NewNRGBA(image.Rect(0, 0, test.width, test.height))
+		bounds := ResizeImage(src, test.maxSide).Bounds()
+		if bounds.Dx() != test.wantW || bounds.Dy() != test.wantH {
+			t.Errorf("%s: got %dx%d, want %dx%d", test.name, bounds.Dx(), bounds.Dy(), test.wantW, test.wantH)
+		}
+	}
+}
+
+func TestResizeImageAveragesPixels(t *testing.T) {
+	// black and white columns blend into grey
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if x%2 == 1 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	got := color.NRGBAModel.Convert(ResizeImage(src, 2).At(0, 0)).(color.NRGBA)
+	if got != (color.NRGBA{R: 127, G: 127, B: 127, A: 255}) {
+		t.Errorf("got %v, want a grey pixel", got)
+	}
+}
+
+func TestEncodeImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+
+	tests := []struct {
+		uploadedType string
+		wantType     string
+		magic        []byte
+	}{
+		{"image/jpeg", "image/jpeg", []byte{0xff, 0xd8}},
+		{"image/png", "image/png", []byte("\x89PNG")},
+		{"image/gif", "image/png", []byte("\x89PNG")},
+	}
+
+	for _, test := range tests {
+		data, contentType, err := EncodeImage(img, test.uploadedType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != test.wantType || !bytes.HasPrefix(data, test.magic) {
+			t.Errorf("%s: got %s, want %s", test.uploadedType, contentType, test.wantType)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package helpers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 10
+	MaxPageLimit     = 100
+)
+
+// ListQuery is the paging, sorting and date range read from a list request.
+// Pages are walked either with the opaque cursor from the previous response or,
+// for older clients, with page/offset.
+type ListQuery struct {
+	Limit      int64
+	Offset     int64
+	Cursor     *pageCursor
+	SortField  string
+	SortOrder  int
+	Projection bson.M
+}
+
+// ListResult is the envelope every list endpoint responds with. Items holds the
+// page as the slice of models Find decoded it into.
+type ListResult struct {
+	Items      interface{} `json:"items"`
+	TotalCount int64       `json:"total_count"`
+	Limit      int64       `json:"limit"`
+	Page       int64       `json:"page,omitempty"`
+	Pages      int64       `json:"pages"`
+	NextCursor string      `json:"next_cursor"`
+}
+
+// pageCursor is where the previous page stopped: the sort value and _id of its last item
+type pageCursor struct {
+	SortField string      `bson:"s"`
+	SortOrder int         `bson:"o"`
+	Value     interface{} `bson:"v"`
+	ID        interface{} `bson:"id"`
+}
+
+func intQuery(c *gin.Context, keys ...string) (int64, bool, string) {
+	for _, key := range keys {
+		if c.Query(key) == "" {
+			continue
+		}
+
+		value, err := strconv.ParseInt(c.Query(key), 10, 64)
+		if err != nil || value < 0 {
+			return 0, true, fmt.Sprintf("%s must be a positive number", key)
+		}
+		return value, true, ""
+	}
+
+	return 0, false, ""
+}
+
+// ParseListQuery reads limit (or recordPerPage), cursor, page or offset (or startIndex),
+// sort and order from the query string. Sorting is only allowed on sortFields.
+func ParseListQuery(c *gin.Context, sortFields []string, defaultSort string, defaultOrder int) (ListQuery, string) {
+	query := ListQuery{Limit: DefaultPageLimit, SortField: defaultSort, SortOrder: defaultOrder}
+
+	limit, ok, msg := intQuery(c, "limit", "recordPerPage")
+	if msg != "" {
+		return query, msg
+	}
+	if ok {
+		if limit < 1 || limit > MaxPageLimit {
+			return query, fmt.Sprintf("limit must be between 1 and %d", MaxPageLimit)
+		}
+		query.Limit = limit
+	}
+
+	if sort := c.Query("sort"); sort != "" {
+		allowed := false
+		for _, field := range sortFields {
+			if field == sort {
+				allowed = true
+			}
+		}
+
+		if !allowed {
+			return query, "sort must be one of " + strings.Join(sortFields, ", ")
+		}
+		query.SortField = sort
+	}
+
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		query.SortOrder = 1
+	case "desc":
+		query.SortOrder = -1
+	default:
+		return query, "order must be asc or desc"
+	}
+
+	page, hasPage, msg := intQuery(c, "page")
+	if msg != "" {
+		return query, msg
+	}
+
+	offset, hasOffset, msg := intQuery(c, "offset", "startIndex")
+	if msg != "" {
+		return query, msg
+	}
+
+	if c.Query("cursor") != "" {
+		if hasPage || hasOffset {
+			return query, "cursor cannot be combined with page or offset"
+		}
+
+		raw, err := base64.RawURLEncoding.DecodeString(c.Query("cursor"))
+		if err != nil {
+			return query, "cursor is not valid"
+		}
+
+		var cursor pageCursor
+		if err = bson.Unmarshal(raw, &cursor); err != nil {
+			return query, "cursor is not valid"
+		}
+
+		// a cursor only makes sense for the ordering it was taken from
+		if cursor.SortField != query.SortField || cursor.SortOrder != query.SortOrder {
+			return query, "cursor does not match the sort order"
+		}
+		query.Cursor = &cursor
+		return query, ""
+	}
+
+	switch {
+	case hasOffset:
+		query.Offset = offset
+	case hasPage:
+		if page < 1 {
+			return query, "page must be 1 or more"
+		}
+		query.Offset = (page - 1) * query.Limit
+	}
+
+	return query, ""
+}
+
+// DateRangeFilter adds a created_from/created_to (YYYY-MM-DD, both inclusive) range on the given field
+func DateRangeFilter(c *gin.Context, filter bson.M, field string) string {
+	dateRange := bson.M{}
+
+	if from := c.Query("created_from"); from != "" {
+		day, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			return "created_from must be in the format YYYY-MM-DD"
+		}
+		dateRange["$gte"] = day
+	}
+
+	if to := c.Query("created_to"); to != "" {
+		day, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return "created_to must be in the format YYYY-MM-DD"
+		}
+		dateRange["$lt"] = day.AddDate(0, 0, 1)
+	}
+
+	if len(dateRange) > 0 {
+		filter[field] = dateRange
+	}
+
+	return ""
+}
+
+// afterCursor matches the documents that sort after the cursor, using _id to break ties
+func (query ListQuery) afterCursor() bson.M {
+	operator := "$gt"
+	if query.SortOrder < 0 {
+		operator = "$lt"
+	}
+
+	if query.SortField == "_id" {
+		return bson.M{"_id": bson.M{operator: query.Cursor.ID}}
+	}
+
+	return bson.M{"$or": bson.A{
+		bson.M{query.SortField: bson.M{operator: query.Cursor.Value}},
+		bson.M{query.SortField: query.Cursor.Value, "_id": bson.M{operator: query.Cursor.ID}},
+	}}
+}
+
+// Find runs the list query against the collection and wraps one page of results in a
+// ListResult. Like cursor.All, results must be a pointer to a slice of the model the
+// collection holds; the page is decoded into it.
+func (query ListQuery) Find(ctx context.Context, collection *mongo.Collection, filter bson.M, results interface{}) (ListResult, error) {
+	result := ListResult{Limit: query.Limit}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return result, err
+	}
+	result.TotalCount = total
+	result.Pages = (total + query.Limit - 1) / query.Limit
+
+	pageFilter := filter
+	if query.Cursor != nil {
+		pageFilter = bson.M{"$and": bson.A{filter, query.afterCursor()}}
+	} else {
+		result.Page = query.Offset/query.Limit + 1
+	}
+
+	// one extra document tells us whether there is another page
+	opt := options.Find().
+		SetSort(bson.D{{query.SortField, query.SortOrder}, {"_id", query.SortOrder}}).
+		SetSkip(query.Offset).
+		SetLimit(query.Limit + 1)
+	if query.SortField == "_id" {
+		opt.SetSort(bson.D{{"_id", query.SortOrder}})
+	}
+	if query.Projection != nil {
+		opt.SetProjection(query.Projection)
+	}
+
+	cursor, err := collection.Find(ctx, pageFilter, opt)
+	if err != nil {
+		return result, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []bson.Raw
+	for cursor.Next(ctx) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err = cursor.Err(); err != nil {
+		return result, err
+	}
+
+	result.NextCursor, err = query.page(docs, results)
+	if err != nil {
+		return result, err
+	}
+	result.Items = reflect.ValueOf(results).Elem().Interface()
+
+	return result, nil
+}
+
+// page decodes up to a page of documents into results and returns the cursor to
+// the next page, or an empty string when docs holds the last one
+func (query ListQuery) page(docs []bson.Raw, results interface{}) (string, error) {
+	slice := reflect.ValueOf(results)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return "", fmt.Errorf("results must be a pointer to a slice, not %T", results)
+	}
+	slice = slice.Elem()
+
+	next := ""
+	if int64(len(docs)) > query.Limit {
+		docs = docs[:query.Limit]
+
+		var last bson.M
+		if err := bson.Unmarshal(docs[len(docs)-1], &last); err != nil {
+			return "", err
+		}
+
+		encoded, err := bson.Marshal(pageCursor{
+			SortField: query.SortField,
+			SortOrder: query.SortOrder,
+			Value:     last[query.SortField],
+			ID:        last["_id"],
+		})
+		if err != nil {
+			return "", err
+		}
+		next = base64.RawURLEncoding.EncodeToString(encoded)
+	}
+
+	// an empty page is still a list, never null
+	items := reflect.MakeSlice(slice.Type(), 0, len(docs))
+	for _, doc := range docs {
+		item := reflect.New(slice.Type().Elem())
+		if err := bson.Unmarshal(doc, item.Interface()); err != nil {
+			return "", err
+		}
+		items = reflect.Append(items, item.Elem())
+	}
+	slice.Set(items)
+
+	return next, nil
+}
@@ -0,0 +1,139 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func listContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/items?"+query, nil)
+	return c
+}
+
+func TestParseListQuery(t *testing.T) {
+	cursor, _ := bson.Marshal(pageCursor{SortField: "name", SortOrder: 1, Value: "Soup", ID: "1"})
+	encoded := base64.RawURLEncoding.EncodeToString(cursor)
+
+	tests := []struct {
+		query  string
+		limit  int64
+		offset int64
+		sort   string
+		order  int
+		msg    string
+	}{
+		{"", DefaultPageLimit, 0, "name", 1, ""},
+		{"limit=25&page=3", 25, 50, "name", 1, ""},
+		{"recordPerPage=5&startIndex=15", 5, 15, "name", 1, ""},
+		{"sort=price&order=desc", DefaultPageLimit, 0, "price", -1, ""},
+		{"cursor=" + encoded, DefaultPageLimit, 0, "name", 1, ""},
+		{"limit=0", 0, 0, "", 0, "limit must be between 1 and 100"},
+		{"limit=101", 0, 0, "", 0, "limit must be between 1 and 100"},
+		{"limit=ten", 0, 0, "", 0, "limit must be a positive number"},
+		{"page=0", 0, 0, "", 0, "page must be 1 or more"},
+		{"sort=password", 0, 0, "", 0, "sort must be one of name, price"},
+		{"order=up", 0, 0, "", 0, "order must be asc or desc"},
+		{"cursor=" + encoded + "&page=2", 0, 0, "", 0, "cursor cannot be combined with page or offset"},
+		{"cursor=" + encoded + "&order=desc", 0, 0, "", 0, "cursor does not match the sort order"},
+		{"cursor=not-a-cursor", 0, 0, "", 0, "cursor is not valid"},
+	}
+
+	for _, test := range tests {
+		query, msg := ParseListQuery(listContext(test.query), []string{"name", "price"}, "name", 1)
+		if msg != test.msg {
+			t.Errorf("%q: got message %q, want %q", test.query, msg, test.msg)
+			continue
+		}
+		if msg != "" {
+			continue
+		}
+
+		if query.Limit != test.limit || query.Offset != test.offset || query.SortField != test.sort || query.SortOrder != test.order {
+			t.Errorf("%q: got limit %d offset %d sort %s %d, want limit %d offset %d sort %s %d",
+				test.query, query.Limit, query.Offset, query.SortField, query.SortOrder,
+				test.limit, test.offset, test.sort, test.order)
+		}
+	}
+}
+
+func TestDateRangeFilter(t *testing.T) {
+	filter := bson.M{}
+	msg := DateRangeFilter(listContext("created_from=2026-03-01&created_to=2026-03-31"), filter, "created_at")
+	if msg != "" {
+		t.Fatal(msg)
+	}
+
+	dateRange, ok := filter["created_at"].(bson.M)
+	if !ok {
+		t.Fatalf("no range on created_at: %v", filter)
+	}
+
+	// both days are inclusive, so the range runs to the start of the next day
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+	if dateRange["$gte"] != from || dateRange["$lt"] != to {
+		t.Errorf("got range %v, want %v to %v", dateRange, from, to)
+	}
+
+	if msg = DateRangeFilter(listContext("created_to=31/03/2026"), bson.M{}, "created_at"); msg == "" {
+		t.Error("a date in the wrong format was accepted")
+	}
+}
+
+func TestPageDecodesIntoTheModel(t *testing.T) {
+	type item struct {
+		ID    string  `bson:"_id" json:"id"`
+		Name  string  `bson:"name" json:"name"`
+		Price float64 `bson:"price" json:"price"`
+	}
+
+	var docs []bson.Raw
+	for i, name := range []string{"Bread", "Soup", "Stew"} {
+		raw, err := bson.Marshal(item{ID: string(rune('a' + i)), Name: name, Price: float64(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, raw)
+	}
+
+	query := ListQuery{Limit: 2, SortField: "name", SortOrder: 1}
+
+	var items []item
+	next, err := query.page(docs, &items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Name != "Bread" || items[1].Price != 1 {
+		t.Fatalf("got %+v, want Bread and Soup", items)
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cursor pageCursor
+	if err = bson.Unmarshal(raw, &cursor); err != nil {
+		t.Fatal(err)
+	}
+	if cursor.Value != "Soup" || cursor.ID != "b" {
+		t.Errorf("the next page starts after %v/%v, want Soup/b", cursor.Value, cursor.ID)
+	}
+
+	// the last page has no next cursor and an empty page is an empty list
+	if next, err = query.page(docs[2:], &items); err != nil || next != "" || len(items) != 1 {
+		t.Errorf("last page: got %+v, cursor %q, error %v", items, next, err)
+	}
+	if _, err = query.page(nil, &items); err != nil || items == nil || len(items) != 0 {
+		t.Errorf("empty page: got %#v, error %v", items, err)
+	}
+
+	if _, err = query.page(docs, items); err == nil {
+		t.Error("results that are not a pointer to a slice were accepted")
+	}
+}
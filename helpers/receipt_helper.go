@@ -0,0 +1,250 @@
+package helpers
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/models"
+	"github.com/go-pdf/fpdf"
+	"github.com/skip2/go-qrcode"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// ReceiptWidth is how many characters fit on a line of an 80mm thermal receipt
+const ReceiptWidth = 42
+
+// receiptQRMarker is the line the qr template function leaves for the QR code
+const receiptQRMarker = "\x00qr\x00"
+
+// DefaultReceiptTemplate is the receipt layout used until the restaurant sets its own
+const DefaultReceiptTemplate = `{{center .RestaurantName}}
+{{if .Address}}{{center .Address}}
+{{end}}{{if .Phone}}{{center .Phone}}
+{{end}}{{if .TaxNumber}}{{center (printf "Tax no. %s" .TaxNumber)}}
+{{end}}{{rule}}
+{{if .Invoice.InvoiceNumber}}{{columns "Invoice no." .Invoice.InvoiceNumber}}
+{{end}}{{columns "Reference" .Invoice.InvoiceID}}
+{{columns "Date" (date .Invoice.CreatedAt)}}
+{{if .TableNumber}}{{columns "Table" (printf "%d" .TableNumber)}}
+{{end}}{{rule}}
+{{range .Lines}}{{columns (printf "%s (%s)" .Name .Quantity) (money .Price)}}
+{{end}}{{rule}}
+{{columns "Subtotal" (money .Invoice.Subtotal)}}
+{{range .Invoice.Discounts}}{{columns .Name (money (neg .Amount))}}
+{{end}}{{with .Invoice.ServiceCharge}}{{columns .Name (money .Amount)}}
+{{end}}{{range .Invoice.Taxes}}{{columns (printf "%s %g%%" .Name .Rate) (money .Tax)}}
+{{end}}{{if .Invoice.PricesIncludeTax}}{{center "Prices include tax"}}
+{{end}}{{columns "TOTAL" (money .Invoice.Total)}}
+{{rule}}
+{{range .Payments}}{{columns .Method (money .Amount)}}
+{{if .Tip}}{{columns "  Tip" (money .Tip)}}
+{{end}}{{if .Change}}{{columns "  Change" (money .Change)}}
+{{end}}{{if .Refunded}}{{columns "  Refunded" (money (neg .Refunded))}}
+{{end}}{{end}}{{columns "Balance due" (money .Invoice.BalanceDue)}}
+{{rule}}
+{{qr}}
+{{if .Footer}}{{center .Footer}}
+{{end}}{{center (date .PrintedAt)}}
+`
+
+var receiptFuncs = template.FuncMap{
+	"center": func(text string) string {
+		text = clip(text, ReceiptWidth)
+		return strings.Repeat(" ", (ReceiptWidth-utf8.RuneCountInString(text))/2) + text
+	},
+	"columns": func(left string, right string) string {
+		right = clip(right, ReceiptWidth)
+		left = clip(left, ReceiptWidth-utf8.RuneCountInString(right)-1)
+		gap := ReceiptWidth - utf8.RuneCountInString(left) - utf8.RuneCountInString(right)
+		return left + strings.Repeat(" ", gap) + right
+	},
+	"money": func(amount float64) string {
+		return fmt.Sprintf("%.2f", amount)
+	},
+	"neg": func(amount float64) float64 {
+		return -amount
+	},
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02 15:04")
+	},
+	"rule": func() string {
+		return strings.Repeat("-", ReceiptWidth)
+	},
+	"qr": func() string {
+		return receiptQRMarker
+	},
+}
+
+func clip(text string, width int) string {
+	if width < 1 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	return string([]rune(text)[:width])
+}
+
+// ParseReceiptTemplate checks a receipt layout, falling back to the default when none is set
+func ParseReceiptTemplate(layout string) (*template.Template, error) {
+	if layout == "" {
+		layout = DefaultReceiptTemplate
+	}
+	return template.New("receipt").Funcs(receiptFuncs).Parse(layout)
+}
+
+// CheckReceiptTemplate makes sure a receipt layout parses and can print a typical receipt
+func CheckReceiptTemplate(layout string) error {
+	status := models.InvoiceStatusPaid
+	now := time.Now()
+	sample := models.Receipt{
+		RestaurantName: "Restaurant",
+		Address:        "1 High Street",
+		Phone:          "000 000 0000",
+		TaxNumber:      "TAX-1",
+		Footer:         "Thank you",
+		Invoice: models.Invoice{
+			InvoiceID:     "sample",
+			InvoiceNumber: "2000-000001",
+			PaymentStatus: &status,
+			Subtotal:      20,
+			Discounts:     []models.InvoiceDiscount{{Name: "Discount", Amount: 2}},
+			ServiceCharge: &models.InvoiceServiceCharge{Name: "Service", Amount: 1.8},
+			Taxes:         []models.InvoiceTax{{Name: "Tax", Rate: 10, Taxable: 19.8, Tax: 1.98}},
+			Total:         21.78,
+			AmountPaid:    21.78,
+			CreatedAt:     now,
+		},
+		TableNumber: 1,
+		Lines:       []models.ReceiptLine{{Name: "Food", Quantity: "M", Price: 20}},
+		Payments:    []models.ReceiptPayment{{Method: models.PaymentMethodCash, Amount: 21.78, Tendered: 25, Change: 3.22}},
+		PrintedAt:   now,
+	}
+
+	_, err := RenderReceipt(layout, sample)
+	return err
+}
+
+// RenderReceipt lays the receipt out as lines of text with the receipt template.
+// A line holding only the QR marker is where the QR code goes.
+func RenderReceipt(layout string, receipt models.Receipt) ([]string, error) {
+	tmpl, err := ParseReceiptTemplate(layout)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err = tmpl.Execute(&out, receipt); err != nil {
+		return nil, err
+	}
+
+	return strings.Split(strings.TrimRight(out.String(), "\n"), "\n"), nil
+}
+
+// ReceiptText is the receipt as plain text, with the QR code's content in place of the code
+func ReceiptText(lines []string, qrContent string) []byte {
+	var out bytes.Buffer
+	for _, line := range lines {
+		if line == receiptQRMarker {
+			line = qrContent
+		}
+		out.WriteString(line + "\n")
+	}
+	return out.Bytes()
+}
+
+// ReceiptESCPOS is the receipt as ESC/POS commands for a thermal printer. The QR
+// code is printed by the printer itself, and the paper is cut at the end.
+func ReceiptESCPOS(lines []string, qrContent string) []byte {
+	var out bytes.Buffer
+	out.Write([]byte{0x1b, 0x40}) // initialise
+
+	for _, line := range lines {
+		if line != receiptQRMarker {
+			out.WriteString(asciiOnly(line) + "\n")
+			continue
+		}
+
+		data := []byte(qrContent)
+		size := len(data) + 3
+		out.Write([]byte{0x1b, 0x61, 0x01})                                     // centre
+		out.Write([]byte{0x1d, 0x28, 0x6b, 0x04, 0x00, 0x31, 0x41, 0x32, 0x00}) // model 2
+		out.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x43, 0x06})       // module size
+		out.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x45, 0x31})       // error correction M
+		out.Write([]byte{0x1d, 0x28, 0x6b, byte(size % 256), byte(size / 256), 0x31, 0x50, 0x30})
+		out.Write(data)
+		out.Write([]byte{0x1d, 0x28, 0x6b, 0x03, 0x00, 0x31, 0x51, 0x30}) // print
+		out.Write([]byte{0x0a, 0x1b, 0x61, 0x00})                         // back to the left
+	}
+
+	out.Write([]byte{0x1b, 0x64, 0x04})       // feed
+	out.Write([]byte{0x1d, 0x56, 0x42, 0x00}) // cut
+	return out.Bytes()
+}
+
+// asciiOnly swaps the characters a basic thermal printer code page cannot print for '?'
+func asciiOnly(line string) string {
+	return strings.Map(func(r rune) rune {
+		if r > 126 {
+			return '?'
+		}
+		return r
+	}, line)
+}
+
+// ReceiptPDF is the receipt as a PDF on an 80mm wide roll, in a fixed width font
+// so it looks like the printed receipt
+func ReceiptPDF(lines []string, qrContent string) ([]byte, error) {
+	const (
+		pageWidth  = 80.0
+		margin     = 4.0
+		lineHeight = 3.6
+		qrSize     = 30.0
+	)
+
+	qr, err := qrcode.Encode(qrContent, qrcode.Medium, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	height := 2*margin + float64(len(lines))*lineHeight
+	for _, line := range lines {
+		if line == receiptQRMarker {
+			height += qrSize
+		}
+	}
+
+	pdf := fpdf.NewCustom(&fpdf.InitType{
+		UnitStr: "mm",
+		Size:    fpdf.SizeType{Wd: pageWidth, Ht: height},
+	})
+	pdf.SetMargins(margin, margin, margin)
+	pdf.SetAutoPageBreak(false, margin)
+	pdf.AddPage()
+	pdf.SetFont("Courier", "", 8.5)
+	translate := pdf.UnicodeTranslatorFromDescriptor("")
+
+	pdf.RegisterImageOptionsReader("qr", fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(qr))
+
+	y := margin
+	for _, line := range lines {
+		if line == receiptQRMarker {
+			pdf.ImageOptions("qr", (pageWidth-qrSize)/2, y, qrSize, qrSize, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+			y += qrSize + lineHeight
+			continue
+		}
+
+		pdf.SetXY(margin, y)
+		pdf.CellFormat(pageWidth-2*margin, lineHeight, translate(line), "", 0, "L", false, 0, "")
+		y += lineHeight
+	}
+
+	var out bytes.Buffer
+	if err = pdf.Output(&out); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
@@ -0,0 +1,20 @@
+package helpers
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReceiptPDF(t *testing.T) {
+	pdf, err := ReceiptPDF([]string{"Café Test", "Soup            4.50", receiptQRMarker, "Thank you"}, "https://example.com/receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Error("the receipt is not a PDF")
+	}
+	if !bytes.Contains(pdf, []byte("/Subtype /Image")) {
+		t.Error("the receipt has no QR code image")
+	}
+}
@@ -3,7 +3,7 @@ package helpers
 import (
 	"context"
 	"fmt"
-	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/dastardlyjockey/restaurant-management-backend/database"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -23,6 +23,8 @@ type SignedDetails struct {
 
 var secretKey = os.Getenv("SECRET_KEY")
 
+var userCollection = database.Collection(database.Client, "users")
+
 func GenerateAllTokens(email string, firstName string, lastName string, uid string) (signedToken string, signedRefreshToken string, err error) {
 	claims := &SignedDetails{
 		Email:     email,
@@ -56,7 +58,7 @@ func UpdateAlTokens(signedToken string, signedRefreshToken string, userID string
 	updateObj = append(updateObj, bson.E{"refresh_token", signedRefreshToken})
 
 	updateAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updateObj = append(updateObj, bson.E{"update_at", updateAt})
+	updateObj = append(updateObj, bson.E{"updated_at", updateAt})
 
 	upsert := true
 	filter := bson.M{"user_id": userID}
@@ -66,7 +68,7 @@ func UpdateAlTokens(signedToken string, signedRefreshToken string, userID string
 	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Second)
 	defer cancel()
 
-	_, err := controllers.UserCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &opt)
+	_, err := userCollection.UpdateOne(ctx, filter, bson.D{{"$set", updateObj}}, &opt)
 	if err != nil {
 		log.Printf("Error updating the token: %v", err)
 		return
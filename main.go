@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
 	"github.com/dastardlyjockey/restaurant-management-backend/database"
 	"github.com/dastardlyjockey/restaurant-management-backend/middleware"
 	"github.com/dastardlyjockey/restaurant-management-backend/routes"
@@ -9,16 +10,36 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"time"
 )
 
 func main() {
 	defer database.CloseMongoDB(database.Client)
 
+	// the .env file is optional, the settings can all come from the environment
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("error loading .env file: ", err)
+		log.Println("No .env file, reading the settings from the environment")
 	}
 
+	err = database.RequireReplicaSet(database.Client)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = database.Migrate(database.Client)
+	if err != nil {
+		log.Fatal("error migrating the database: ", err)
+	}
+
+	err = controllers.SeedInitialManager()
+	if err != nil {
+		log.Fatal("error setting up the first manager: ", err)
+	}
+
+	// free the tables of bookings whose party never arrived
+	go controllers.SweepNoShows(5 * time.Minute)
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8000"
@@ -29,17 +50,35 @@ func main() {
 
 	// user routes
 	routes.UserRoutes(router)
+	routes.ImageRoutes(router)
 
 	// middleware
 	router.Use(middleware.Authentication)
 
 	// routes
+	routes.SignedInUserRoutes(router)
 	routes.FoodRoutes(router)
 	routes.MenuRoutes(router)
 	routes.TableRoutes(router)
+	routes.TableGroupRoutes(router)
 	routes.OrderRoutes(router)
 	routes.OrderItemRoutes(router)
 	routes.InvoiceRoutes(router)
+	routes.PaymentRoutes(router)
+	routes.RefundRoutes(router)
+	routes.CashSessionRoutes(router)
+	routes.PromotionRoutes(router)
+	routes.AuditRoutes(router)
+	routes.ReservationRoutes(router)
+	routes.WaitlistRoutes(router)
+	routes.ServerAssignmentRoutes(router)
+	routes.IngredientRoutes(router)
+	routes.RecipeRoutes(router)
+	routes.StockTakeRoutes(router)
+	routes.SupplierRoutes(router)
+	routes.PurchaseOrderRoutes(router)
+	routes.ReportRoutes(router)
+	routes.SettingsRoutes(router)
 
 	//running server
 	fmt.Println("starting server on port: " + port)
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	AuditPaymentTaken         = "PAYMENT_TAKEN"
+	AuditPaymentRefunded      = "PAYMENT_REFUNDED"
+	AuditInvoiceMarkedPaid    = "INVOICE_MARKED_PAID"
+	AuditInvoiceVoided        = "INVOICE_VOIDED"
+	AuditDiscountApplied      = "DISCOUNT_APPLIED"
+	AuditDiscountRemoved      = "DISCOUNT_REMOVED"
+	AuditServiceChargeRemoved = "SERVICE_CHARGE_REMOVED"
+)
+
+// AuditEntry records one action that moved money or changed what is owed.
+// Entries are only ever added, never changed or removed.
+type AuditEntry struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Action     string             `bson:"action" json:"action"`
+	InvoiceID  string             `bson:"invoice_id" json:"invoice_id"`
+	PaymentID  *string            `bson:"payment_id" json:"payment_id"`
+	RefundID   *string            `bson:"refund_id" json:"refund_id"`
+	Amount     float64            `bson:"amount" json:"amount"`
+	Reason     *string            `bson:"reason" json:"reason"`
+	UserID     string             `bson:"user_id" json:"user_id"`
+	ApprovedBy *string            `bson:"approved_by" json:"approved_by"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	AuditID    string             `bson:"audit_id" json:"audit_id"`
+}
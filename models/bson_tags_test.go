@@ -0,0 +1,49 @@
+package models
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var taggedModels = []interface{}{
+	AuditEntry{}, CashSession{}, CashMovement{}, CashReport{}, CashCount{}, Counter{},
+	Food{}, Image{}, Ingredient{}, Invoice{}, Menu{}, Note{}, OrderItem{}, Order{},
+	Payment{}, Promotion{}, PurchaseOrder{}, Recipe{}, Refund{}, Reservation{},
+	ServerAssignment{}, Settings{}, StockMovement{}, StockTake{}, Supplier{},
+	TableGroup{}, Table{}, User{}, WaitlistEntry{},
+}
+
+// the queries filter and sort on the json names, so every stored field must use it
+func TestBsonNamesMatchJsonNames(t *testing.T) {
+	for _, model := range taggedModels {
+		checkBsonNames(t, reflect.TypeOf(model), map[reflect.Type]bool{})
+	}
+}
+
+func checkBsonNames(t *testing.T, model reflect.Type, seen map[reflect.Type]bool) {
+	if seen[model] {
+		return
+	}
+	seen[model] = true
+
+	for i := 0; i < model.NumField(); i++ {
+		field := model.Field(i)
+		jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+		bsonName := strings.Split(field.Tag.Get("bson"), ",")[0]
+
+		if bsonName == "" {
+			t.Errorf("%s.%s has no bson name", model.Name(), field.Name)
+		} else if jsonName != "" && jsonName != "-" && bsonName != "_id" && bsonName != jsonName {
+			t.Errorf("%s.%s is stored as %q but sent as %q", model.Name(), field.Name, bsonName, jsonName)
+		}
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice || fieldType.Kind() == reflect.Map {
+			fieldType = fieldType.Elem()
+		}
+		if fieldType.Kind() == reflect.Struct && fieldType.PkgPath() == model.PkgPath() {
+			checkBsonNames(t, fieldType, seen)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	CashSessionOpen   = "OPEN"
+	CashSessionClosed = "CLOSED"
+)
+
+const (
+	CashMovementIn  = "IN"
+	CashMovementOut = "OUT"
+)
+
+const (
+	CashReportX = "X"
+	CashReportZ = "Z"
+)
+
+// CashMovement is cash put into or taken out of the drawer other than for a sale,
+// e.g. change fetched from the bank or a delivery paid out of the till
+type CashMovement struct {
+	MovementID string    `bson:"movement_id" json:"movement_id"`
+	Type       *string   `bson:"type" json:"type" validate:"required,eq=IN|eq=OUT"`
+	Amount     *float64  `bson:"amount" json:"amount" validate:"required,gt=0"`
+	Reason     *string   `bson:"reason" json:"reason" validate:"required,min=3,max=200"`
+	CreatedBy  string    `bson:"created_by" json:"created_by"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+}
+
+// CashReport adds up the takings of a cash drawer session. An X report is a look at
+// the figures while the drawer is open; the Z report is taken when it is closed and
+// compares the cash counted with the cash there should be. A negative variance
+// means the drawer is short.
+type CashReport struct {
+	Type           string    `bson:"type" json:"type"`
+	From           time.Time `bson:"from" json:"from"`
+	To             time.Time `bson:"to" json:"to"`
+	OpeningFloat   float64   `bson:"opening_float" json:"opening_float"`
+	CashSales      float64   `bson:"cash_sales" json:"cash_sales"`
+	CashTips       float64   `bson:"cash_tips" json:"cash_tips"`
+	CashRefunds    float64   `bson:"cash_refunds" json:"cash_refunds"`
+	CashIn         float64   `bson:"cash_in" json:"cash_in"`
+	CashOut        float64   `bson:"cash_out" json:"cash_out"`
+	ExpectedCash   float64   `bson:"expected_cash" json:"expected_cash"`
+	CountedCash    *float64  `bson:"counted_cash" json:"counted_cash"`
+	Variance       *float64  `bson:"variance" json:"variance"`
+	CardSales      float64   `bson:"card_sales" json:"card_sales"`
+	CardTips       float64   `bson:"card_tips" json:"card_tips"`
+	CardRefunds    float64   `bson:"card_refunds" json:"card_refunds"`
+	PaymentCount   int       `bson:"payment_count" json:"payment_count"`
+	InvoiceCount   int       `bson:"invoice_count" json:"invoice_count"`
+	SalesTotal     float64   `bson:"sales_total" json:"sales_total"`
+	DiscountTotal  float64   `bson:"discount_total" json:"discount_total"`
+	TaxTotal       float64   `bson:"tax_total" json:"tax_total"`
+	VoidCount      int       `bson:"void_count" json:"void_count"`
+	OpenInvoices   int       `bson:"open_invoices" json:"open_invoices"`
+	LockedInvoices int       `bson:"locked_invoices" json:"locked_invoices"`
+	GeneratedBy    string    `bson:"generated_by" json:"generated_by"`
+	GeneratedAt    time.Time `bson:"generated_at" json:"generated_at"`
+}
+
+// CashCount is what the cashier counted in the drawer when closing it
+type CashCount struct {
+	CountedCash *float64 `bson:"counted_cash" json:"counted_cash" validate:"required,min=0"`
+	Note        *string  `bson:"note" json:"note" validate:"omitempty,max=500"`
+}
+
+// CashSession is the cash drawer from when it is opened with a float until it is
+// cashed up. Only one drawer session can be open at a time.
+type CashSession struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	Status        string             `bson:"status" json:"status"`
+	OpeningFloat  *float64           `bson:"opening_float" json:"opening_float" validate:"required,min=0"`
+	Movements     []CashMovement     `bson:"movements" json:"movements"`
+	CountedCash   *float64           `bson:"counted_cash" json:"counted_cash"`
+	Note          *string            `bson:"note" json:"note"`
+	ZReport       *CashReport        `bson:"z_report" json:"z_report"`
+	OpenedBy      string             `bson:"opened_by" json:"opened_by"`
+	OpenedAt      time.Time          `bson:"opened_at" json:"opened_at"`
+	ClosedBy      *string            `bson:"closed_by" json:"closed_by"`
+	ClosedAt      *time.Time         `bson:"closed_at" json:"closed_at"`
+	CashSessionID string             `bson:"cash_session_id" json:"cash_session_id"`
+}
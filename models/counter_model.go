@@ -0,0 +1,8 @@
+package models
+
+// Counter hands out sequence numbers, one document per sequence. The _id names
+// the sequence, e.g. the invoices of one restaurant in one fiscal year.
+type Counter struct {
+	ID       string `bson:"_id"`
+	Sequence int    `bson:"sequence" json:"sequence"`
+}
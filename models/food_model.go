@@ -5,13 +5,29 @@ import (
 	"time"
 )
 
+// Allergens are the 14 major allergens a food can be labelled with
+var Allergens = []string{
+	"celery", "gluten", "crustaceans", "eggs", "fish", "lupin", "milk",
+	"molluscs", "mustard", "nuts", "peanuts", "sesame", "soya", "sulphites",
+}
+
+// DietaryTags are the diets a food can be labelled as suitable for
+var DietaryTags = []string{"vegan", "vegetarian", "halal", "kosher", "gluten-free", "dairy-free"}
+
 type Food struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	Name      *string            `json:"name" validate:"required,min=2,max=100"`
-	Price     *float64           `json:"price" validate:"required"`
-	FoodImage *string            `json:"food_image" validate:"required"`
-	CreatedAt time.Time          `json:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at"`
-	FoodID    string             `json:"food_id"`
-	MenuID    *string            `json:"menu_id" validate:"required"`
+	ID                primitive.ObjectID `bson:"_id"`
+	Name              *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Price             *float64           `bson:"price" json:"price" validate:"required"`
+	FoodImage         *string            `bson:"food_image" json:"food_image"`
+	ImageID           *string            `bson:"image_id" json:"image_id"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+	FoodID            string             `bson:"food_id" json:"food_id"`
+	MenuID            *string            `bson:"menu_id" json:"menu_id" validate:"required"`
+	Archived          bool               `bson:"archived" json:"archived"`
+	Available         *bool              `bson:"available" json:"available"`
+	RemainingPortions *int               `bson:"remaining_portions" json:"remaining_portions" validate:"omitempty,min=0"`
+	Allergens         []string           `bson:"allergens" json:"allergens" validate:"omitempty,dive,allergen"`
+	DietaryTags       []string           `bson:"dietary_tags" json:"dietary_tags" validate:"omitempty,dive,dietary_tag"`
+	TaxCategory       *string            `bson:"tax_category" json:"tax_category"`
 }
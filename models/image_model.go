@@ -0,0 +1,30 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	ImageOwnerFood = "FOOD"
+	ImageOwnerUser = "USER"
+)
+
+// ImageVariant is one stored copy of an uploaded image
+type ImageVariant struct {
+	Key         string `bson:"key" json:"key"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Width       int    `bson:"width" json:"width"`
+	Height      int    `bson:"height" json:"height"`
+}
+
+type Image struct {
+	ID         primitive.ObjectID      `bson:"_id"`
+	OwnerType  string                  `bson:"owner_type" json:"owner_type"`
+	OwnerID    string                  `bson:"owner_id" json:"owner_id"`
+	FileName   string                  `bson:"file_name" json:"file_name"`
+	Variants   map[string]ImageVariant `bson:"variants" json:"variants"`
+	UploadedBy string                  `bson:"uploaded_by" json:"uploaded_by"`
+	CreatedAt  time.Time               `bson:"created_at" json:"created_at"`
+	ImageID    string                  `bson:"image_id" json:"image_id"`
+}
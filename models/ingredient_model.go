@@ -0,0 +1,20 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type Ingredient struct {
+	ID                primitive.ObjectID `bson:"_id"`
+	Name              *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Unit              *string            `bson:"unit" json:"unit" validate:"required"`
+	StockLevel        *float64           `bson:"stock_level" json:"stock_level" validate:"omitempty,min=0"`
+	LowStockThreshold *float64           `bson:"low_stock_threshold" json:"low_stock_threshold" validate:"omitempty,min=0"`
+	ParLevel          *float64           `bson:"par_level" json:"par_level" validate:"omitempty,min=0"`
+	UnitCost          *float64           `bson:"unit_cost" json:"unit_cost" validate:"omitempty,min=0"`
+	SupplierID        *string            `bson:"supplier_id" json:"supplier_id"`
+	CreatedAt         time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at" json:"updated_at"`
+	IngredientID      string             `bson:"ingredient_id" json:"ingredient_id"`
+}
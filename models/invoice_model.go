@@ -5,13 +5,80 @@ import (
 	"time"
 )
 
+const (
+	InvoiceStatusPending           = "PENDING"
+	InvoiceStatusPartiallyPaid     = "PARTIALLY_PAID"
+	InvoiceStatusPaid              = "PAID"
+	InvoiceStatusPartiallyRefunded = "PARTIALLY_REFUNDED"
+	InvoiceStatusRefunded          = "REFUNDED"
+	InvoiceStatusVoid              = "VOID"
+)
+
+// InvoiceTax is the tax charged on one category of the items on an invoice;
+// Taxable is the amount the tax was worked out on, excluding the tax itself
+type InvoiceTax struct {
+	Category string  `bson:"category" json:"category"`
+	Name     string  `bson:"name" json:"name"`
+	Rate     float64 `bson:"rate" json:"rate"`
+	Taxable  float64 `bson:"taxable" json:"taxable"`
+	Tax      float64 `bson:"tax" json:"tax"`
+}
+
+// InvoiceServiceCharge is the service charge line on an invoice
+type InvoiceServiceCharge struct {
+	Name      string  `bson:"name" json:"name"`
+	Type      string  `bson:"type" json:"type"`
+	Value     float64 `bson:"value" json:"value"`
+	PartySize int     `bson:"party_size" json:"party_size"`
+	Amount    float64 `bson:"amount" json:"amount"`
+	Taxable   bool    `bson:"taxable" json:"taxable"`
+}
+
+// ServiceChargeRemoval records a manager taking the service charge off an invoice
+type ServiceChargeRemoval struct {
+	Reason     string    `bson:"reason" json:"reason"`
+	RemovedBy  string    `bson:"removed_by" json:"removed_by"`
+	ApprovedBy string    `bson:"approved_by" json:"approved_by"`
+	RemovedAt  time.Time `bson:"removed_at" json:"removed_at"`
+}
+
+// Invoice is the bill for an order. InvoiceNumber is the sequential number the
+// accounts know it by, e.g. 2026-000042, while InvoiceID stays the internal ID.
+// Covers is the number of guests served, taken from the tables when it is raised.
+// Once the Z report of a cash session has taken a settled invoice it is locked.
 type Invoice struct {
-	ID             primitive.ObjectID `bson:"_id"`
-	InvoiceID      string             `json:"invoice_id"`
-	OrderID        string             `json:"order_id"`
-	PaymentMethod  *string            `json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
-	PaymentStatus  *string            `json:"payment_status" validate:"required,eq=PENDING|eq=PAID"`
-	PaymentDueDate time.Time          `json:"payment_due_date"`
-	CreatedAt      time.Time          `json:"created_at"`
-	UpdatedAt      time.Time          `json:"updated_at"`
+	ID                   primitive.ObjectID    `bson:"_id"`
+	InvoiceID            string                `bson:"invoice_id" json:"invoice_id"`
+	InvoiceNumber        string                `bson:"invoice_number" json:"invoice_number"`
+	FiscalYear           int                   `bson:"fiscal_year" json:"fiscal_year"`
+	InvoiceSequence      int                   `bson:"invoice_sequence" json:"invoice_sequence"`
+	OrderID              string                `bson:"order_id" json:"order_id"`
+	Covers               int                   `bson:"covers" json:"covers"`
+	PaymentMethod        *string               `bson:"payment_method" json:"payment_method" validate:"eq=CARD|eq=CASH|eq="`
+	PaymentStatus        *string               `bson:"payment_status" json:"payment_status" validate:"required,eq=PENDING|eq=PARTIALLY_PAID|eq=PAID|eq=PARTIALLY_REFUNDED|eq=REFUNDED|eq=VOID"`
+	PaymentDueDate       time.Time             `bson:"payment_due_date" json:"payment_due_date"`
+	Subtotal             float64               `bson:"subtotal" json:"subtotal"`
+	Discounts            []InvoiceDiscount     `bson:"discounts" json:"discounts"`
+	DiscountTotal        float64               `bson:"discount_total" json:"discount_total"`
+	CouponCode           *string               `bson:"coupon_code" json:"coupon_code"`
+	ManualDiscounts      []ManualDiscount      `bson:"manual_discounts" json:"manual_discounts"`
+	ServiceCharge        *InvoiceServiceCharge `bson:"service_charge" json:"service_charge"`
+	ServiceChargeRemoval *ServiceChargeRemoval `bson:"service_charge_removal" json:"service_charge_removal"`
+	TaxTotal             float64               `bson:"tax_total" json:"tax_total"`
+	Taxes                []InvoiceTax          `bson:"taxes" json:"taxes"`
+	PricesIncludeTax     bool                  `bson:"prices_include_tax" json:"prices_include_tax"`
+	Total                float64               `bson:"total" json:"total"`
+	AmountPaid           float64               `bson:"amount_paid" json:"amount_paid"`
+	TipTotal             float64               `bson:"tip_total" json:"tip_total"`
+	BalanceDue           float64               `bson:"balance_due" json:"balance_due"`
+	RefundTotal          float64               `bson:"refund_total" json:"refund_total"`
+	VoidReason           *string               `bson:"void_reason" json:"void_reason"`
+	VoidedBy             *string               `bson:"voided_by" json:"voided_by"`
+	VoidApprovedBy       *string               `bson:"void_approved_by" json:"void_approved_by"`
+	VoidedAt             *time.Time            `bson:"voided_at" json:"voided_at"`
+	PaidAt               *time.Time            `bson:"paid_at" json:"paid_at"`
+	CashSessionID        *string               `bson:"cash_session_id" json:"cash_session_id"`
+	LockedAt             *time.Time            `bson:"locked_at" json:"locked_at"`
+	CreatedAt            time.Time             `bson:"created_at" json:"created_at"`
+	UpdatedAt            time.Time             `bson:"updated_at" json:"updated_at"`
 }
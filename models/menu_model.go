@@ -6,12 +6,25 @@ import (
 )
 
 type Menu struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	Name      string             `json:"name" validate:"required"`
-	Category  string             `json:"category" validate:"required"`
-	StartDate *time.Time         `json:"start_date"`
-	EndDate   *time.Time         `json:"end_date"`
-	CreatedAt time.Time          `json:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at"`
-	MenuID    string             `json:"menu_id"`
+	ID         primitive.ObjectID `bson:"_id"`
+	Name       string             `bson:"name" json:"name" validate:"required"`
+	Category   string             `bson:"category" json:"category" validate:"required"`
+	StartDate  *time.Time         `bson:"start_date" json:"start_date"`
+	EndDate    *time.Time         `bson:"end_date" json:"end_date"`
+	Schedules  []MenuSchedule     `bson:"schedules" json:"schedules" validate:"omitempty,dive"`
+	Archived   bool               `bson:"archived" json:"archived"`
+	ArchivedAt *time.Time         `bson:"archived_at" json:"archived_at"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt  time.Time          `bson:"updated_at" json:"updated_at"`
+	MenuID     string             `bson:"menu_id" json:"menu_id"`
+}
+
+// MenuSchedule is a recurring daypart, such as breakfast on weekdays from 07:00 to 11:00.
+// A menu with schedules is only served while one of them is running.
+type MenuSchedule struct {
+	Name      string   `bson:"name" json:"name"`
+	Days      []string `bson:"days" json:"days" validate:"required,min=1,dive,oneof=MON TUE WED THU FRI SAT SUN"`
+	StartTime string   `bson:"start_time" json:"start_time" validate:"required"`
+	EndTime   string   `bson:"end_time" json:"end_time" validate:"required"`
+	Timezone  string   `bson:"timezone" json:"timezone" validate:"required"`
 }
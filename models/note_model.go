@@ -7,9 +7,9 @@ import (
 
 type Note struct {
 	ID        primitive.ObjectID `bson:"_id"`
-	Title     string             `json:"title"`
-	Text      string             `json:"text"`
-	CreatedAt time.Time          `json:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at"`
-	NoteID    string             `json:"note_id"`
+	Title     string             `bson:"title" json:"title"`
+	Text      string             `bson:"text" json:"text"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	NoteID    string             `bson:"note_id" json:"note_id"`
 }
@@ -7,11 +7,12 @@ import (
 
 type OrderItem struct {
 	ID          primitive.ObjectID `bson:"_id"`
-	Quantity    *string            `json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
-	UnitPrice   *float64           `json:"unit_price" validate:"required"`
-	CreatedAt   time.Time          `json:"created_at"`
-	UpdatedAt   time.Time          `json:"updated_at"`
-	FoodID      *string            `json:"food_id" validate:"required"`
-	OrderItemID string             `json:"order_item_id"`
-	OrderID     string             `json:"order_id" validate:"required"`
+	Quantity    *string            `bson:"quantity" json:"quantity" validate:"required,eq=S|eq=M|eq=L"`
+	UnitPrice   *float64           `bson:"unit_price" json:"unit_price" validate:"required"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	FoodID      *string            `bson:"food_id" json:"food_id" validate:"required"`
+	OrderItemID string             `bson:"order_item_id" json:"order_item_id"`
+	OrderID     string             `bson:"order_id" json:"order_id" validate:"required"`
+	ServedAt    *time.Time         `bson:"served_at" json:"served_at"`
 }
@@ -5,11 +5,38 @@ import (
 	"time"
 )
 
+const (
+	OrderStatusOpen   = "OPEN"
+	OrderStatusClosed = "CLOSED"
+)
+
+const (
+	OrderTypeDineIn   = "DINE_IN"
+	OrderTypeTakeaway = "TAKEAWAY"
+	OrderTypeDelivery = "DELIVERY"
+)
+
 type Order struct {
-	ID        primitive.ObjectID `bson:"_id"`
-	OrderDate time.Time          `json:"order_date" validate:"required"`
-	CreatedAt time.Time          `json:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at"`
-	TableID   *string            `json:"table_id" validate:"required"`
-	OrderID   string             `json:"order_id"`
+	ID           primitive.ObjectID `bson:"_id"`
+	OrderDate    time.Time          `bson:"order_date" json:"order_date" validate:"required"`
+	Status       *string            `bson:"status" json:"status" validate:"omitempty,eq=OPEN|eq=CLOSED"`
+	OrderType    *string            `bson:"order_type" json:"order_type" validate:"omitempty,eq=DINE_IN|eq=TAKEAWAY|eq=DELIVERY"`
+	ClosedAt     *time.Time         `bson:"closed_at" json:"closed_at"`
+	CreatedBy    string             `bson:"created_by" json:"created_by"`
+	ServerID     *string            `bson:"server_id" json:"server_id"`
+	Transfers    []OrderTransfer    `bson:"transfers" json:"transfers"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+	TableID      *string            `bson:"table_id" json:"table_id" validate:"required"`
+	TableGroupID *string            `bson:"table_group_id" json:"table_group_id"`
+	Allergies    []string           `bson:"allergies" json:"allergies" validate:"omitempty,dive,allergen"`
+	OrderID      string             `bson:"order_id" json:"order_id"`
+}
+
+// OrderTransfer records an order being handed from one server to another
+type OrderTransfer struct {
+	FromServerID  string    `bson:"from_server_id" json:"from_server_id"`
+	ToServerID    string    `bson:"to_server_id" json:"to_server_id"`
+	TransferredBy string    `bson:"transferred_by" json:"transferred_by"`
+	TransferredAt time.Time `bson:"transferred_at" json:"transferred_at"`
 }
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	PaymentMethodCash = "CASH"
+	PaymentMethodCard = "CARD"
+)
+
+const (
+	PaymentStatusPending  = "PENDING"
+	PaymentStatusCaptured = "CAPTURED"
+	PaymentStatusDeclined = "DECLINED"
+	PaymentStatusFailed   = "FAILED"
+	PaymentStatusRefunded = "REFUNDED"
+)
+
+// Payment is one tender against an invoice. Amount is what it pays off the
+// invoice; the tip is on top, and for cash the change is what is handed back
+// from the amount tendered. Card payments go through the payment provider and
+// keep its transaction reference. Refunds come off the amount first and the tip last.
+type Payment struct {
+	ID                    primitive.ObjectID `bson:"_id"`
+	InvoiceID             string             `bson:"invoice_id" json:"invoice_id"`
+	Method                *string            `bson:"method" json:"method" validate:"required,eq=CASH|eq=CARD"`
+	Amount                *float64           `bson:"amount" json:"amount" validate:"required,gt=0"`
+	Tip                   *float64           `bson:"tip" json:"tip" validate:"omitempty,min=0"`
+	Tendered              *float64           `bson:"tendered" json:"tendered" validate:"omitempty,gt=0"`
+	Change                float64            `bson:"change" json:"change"`
+	Refunded              float64            `bson:"refunded" json:"refunded"`
+	Status                string             `bson:"status" json:"status"`
+	Provider              *string            `bson:"provider" json:"provider"`
+	ProviderTransactionID *string            `bson:"provider_transaction_id" json:"provider_transaction_id"`
+	FailureReason         *string            `bson:"failure_reason" json:"failure_reason"`
+	IdempotencyKey        *string            `bson:"idempotency_key" json:"idempotency_key" validate:"omitempty,max=255"`
+	ReceivedBy            string             `bson:"received_by" json:"received_by"`
+	CreatedAt             time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt             time.Time          `bson:"updated_at" json:"updated_at"`
+	PaymentID             string             `bson:"payment_id" json:"payment_id"`
+}
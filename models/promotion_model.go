@@ -0,0 +1,76 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	PromotionPercentage = "PERCENTAGE"
+	PromotionFixed      = "FIXED"
+	PromotionBuyXGetY   = "BUY_X_GET_Y"
+)
+
+const (
+	PromotionScopeItem    = "ITEM"
+	PromotionScopeInvoice = "INVOICE"
+)
+
+const (
+	DiscountSourcePromotion = "PROMOTION"
+	DiscountSourceCoupon    = "COUPON"
+	DiscountSourceManual    = "MANUAL"
+)
+
+// Promotion takes money off an invoice. An ITEM promotion discounts each matching
+// item, an INVOICE promotion the whole bill. Items match when they are one of the
+// foods, or on a menu in one of the menu categories, listed; with neither listed
+// every item matches. BUY_X_GET_Y gives GetQuantity of every BuyQuantity+GetQuantity
+// matching items free, cheapest first. Schedules limit an item promotion to items
+// ordered, and an invoice promotion to invoices raised, while one of them is running,
+// e.g. happy hour. A promotion with a coupon code only applies once the coupon is
+// added to the invoice.
+type Promotion struct {
+	ID             primitive.ObjectID `bson:"_id"`
+	Name           *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Type           *string            `bson:"type" json:"type" validate:"required,eq=PERCENTAGE|eq=FIXED|eq=BUY_X_GET_Y"`
+	Scope          *string            `bson:"scope" json:"scope" validate:"omitempty,eq=ITEM|eq=INVOICE"`
+	Value          *float64           `bson:"value" json:"value" validate:"omitempty,gt=0"`
+	BuyQuantity    *int               `bson:"buy_quantity" json:"buy_quantity" validate:"omitempty,min=1"`
+	GetQuantity    *int               `bson:"get_quantity" json:"get_quantity" validate:"omitempty,min=1"`
+	FoodIDs        []string           `bson:"food_ids" json:"food_ids"`
+	MenuCategories []string           `bson:"menu_categories" json:"menu_categories"`
+	Schedules      []MenuSchedule     `bson:"schedules" json:"schedules" validate:"omitempty,dive"`
+	StartDate      *time.Time         `bson:"start_date" json:"start_date"`
+	EndDate        *time.Time         `bson:"end_date" json:"end_date"`
+	CouponCode     *string            `bson:"coupon_code" json:"coupon_code" validate:"omitempty,min=3,max=50"`
+	UsageLimit     *int               `bson:"usage_limit" json:"usage_limit" validate:"omitempty,min=1"`
+	UsageCount     int                `bson:"usage_count" json:"usage_count"`
+	Active         *bool              `bson:"active" json:"active"`
+	CreatedBy      string             `bson:"created_by" json:"created_by"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	PromotionID    string             `bson:"promotion_id" json:"promotion_id"`
+}
+
+// ManualDiscount is a discount a manager gives on an invoice, on one item when
+// OrderItemID is set or on the whole bill otherwise
+type ManualDiscount struct {
+	DiscountID  string    `bson:"discount_id" json:"discount_id"`
+	Type        *string   `bson:"type" json:"type" validate:"required,eq=PERCENTAGE|eq=FIXED"`
+	Value       *float64  `bson:"value" json:"value" validate:"required,gt=0"`
+	OrderItemID *string   `bson:"order_item_id" json:"order_item_id"`
+	Reason      *string   `bson:"reason" json:"reason" validate:"required,min=3,max=500"`
+	AppliedBy   string    `bson:"applied_by" json:"applied_by"`
+	ApprovedBy  string    `bson:"approved_by" json:"approved_by"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+}
+
+// InvoiceDiscount is one discount line on the bill
+type InvoiceDiscount struct {
+	Source      string  `bson:"source" json:"source"`
+	ID          string  `bson:"id" json:"id"`
+	Name        string  `bson:"name" json:"name"`
+	OrderItemID *string `bson:"order_item_id" json:"order_item_id"`
+	Amount      float64 `bson:"amount" json:"amount"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	PurchaseOrderStatusDraft             = "DRAFT"
+	PurchaseOrderStatusOrdered           = "ORDERED"
+	PurchaseOrderStatusPartiallyReceived = "PARTIALLY_RECEIVED"
+	PurchaseOrderStatusReceived          = "RECEIVED"
+	PurchaseOrderStatusCancelled         = "CANCELLED"
+)
+
+type PurchaseOrderLine struct {
+	IngredientID     *string  `bson:"ingredient_id" json:"ingredient_id" validate:"required"`
+	OrderedQuantity  *float64 `bson:"ordered_quantity" json:"ordered_quantity" validate:"required,gt=0"`
+	UnitCost         *float64 `bson:"unit_cost" json:"unit_cost" validate:"omitempty,min=0"`
+	ReceivedQuantity float64  `bson:"received_quantity" json:"received_quantity"`
+	ReceivedCost     float64  `bson:"received_cost" json:"received_cost"`
+}
+
+// ReceivedLine is one ingredient in a delivery, at the cost actually charged per unit
+type ReceivedLine struct {
+	IngredientID *string  `bson:"ingredient_id" json:"ingredient_id" validate:"required"`
+	Quantity     *float64 `bson:"quantity" json:"quantity" validate:"required,gt=0"`
+	UnitCost     *float64 `bson:"unit_cost" json:"unit_cost" validate:"required,min=0"`
+}
+
+type PurchaseOrderReceipt struct {
+	Lines      []ReceivedLine `bson:"lines" json:"lines"`
+	ReceivedBy string         `bson:"received_by" json:"received_by"`
+	ReceivedAt time.Time      `bson:"received_at" json:"received_at"`
+}
+
+// PurchaseOrder is stock ordered from a supplier. Version goes up with every change
+// so concurrent updates can tell whether the order moved under them.
+type PurchaseOrder struct {
+	ID              primitive.ObjectID     `bson:"_id"`
+	SupplierID      *string                `bson:"supplier_id" json:"supplier_id" validate:"required"`
+	Status          *string                `bson:"status" json:"status" validate:"omitempty,eq=DRAFT|eq=ORDERED|eq=CANCELLED"`
+	Lines           []PurchaseOrderLine    `bson:"lines" json:"lines" validate:"required,min=1,dive"`
+	Receipts        []PurchaseOrderReceipt `bson:"receipts" json:"receipts"`
+	Notes           *string                `bson:"notes" json:"notes"`
+	CreatedBy       string                 `bson:"created_by" json:"created_by"`
+	OrderedAt       *time.Time             `bson:"ordered_at" json:"ordered_at"`
+	ReceivedAt      *time.Time             `bson:"received_at" json:"received_at"`
+	CreatedAt       time.Time              `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time              `bson:"updated_at" json:"updated_at"`
+	PurchaseOrderID string                 `bson:"purchase_order_id" json:"purchase_order_id"`
+	Version         int                    `bson:"version" json:"version"`
+}
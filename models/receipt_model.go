@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+// ReceiptLine is an item as it is printed on a receipt
+type ReceiptLine struct {
+	Name     string
+	Quantity string
+	Price    float64
+}
+
+// ReceiptPayment is a payment as it is printed on a receipt
+type ReceiptPayment struct {
+	Method   string
+	Amount   float64
+	Tip      float64
+	Tendered float64
+	Change   float64
+	Refunded float64
+}
+
+// Receipt is everything a receipt template can print
+type Receipt struct {
+	RestaurantName string
+	Address        string
+	Phone          string
+	TaxNumber      string
+	Footer         string
+	Invoice        Invoice
+	TableNumber    int
+	Lines          []ReceiptLine
+	Payments       []ReceiptPayment
+	PrintedAt      time.Time
+}
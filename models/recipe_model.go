@@ -0,0 +1,21 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// RecipeIngredient is the quantity of an ingredient, in the ingredient's unit, that goes into one portion
+type RecipeIngredient struct {
+	IngredientID *string  `bson:"ingredient_id" json:"ingredient_id" validate:"required"`
+	Quantity     *float64 `bson:"quantity" json:"quantity" validate:"required,gt=0"`
+}
+
+type Recipe struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	FoodID      *string            `bson:"food_id" json:"food_id" validate:"required"`
+	Ingredients []RecipeIngredient `bson:"ingredients" json:"ingredients" validate:"required,min=1,dive"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	RecipeID    string             `bson:"recipe_id" json:"recipe_id"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+// Refund gives back some or all of a payment. Card refunds go back through the
+// provider that took the payment.
+type Refund struct {
+	ID                    primitive.ObjectID `bson:"_id"`
+	PaymentID             string             `bson:"payment_id" json:"payment_id"`
+	InvoiceID             string             `bson:"invoice_id" json:"invoice_id"`
+	Amount                *float64           `bson:"amount" json:"amount" validate:"omitempty,gt=0"`
+	Reason                *string            `bson:"reason" json:"reason" validate:"required,min=3,max=500"`
+	Method                string             `bson:"method" json:"method"`
+	Provider              *string            `bson:"provider" json:"provider"`
+	ProviderTransactionID *string            `bson:"provider_transaction_id" json:"provider_transaction_id"`
+	CreatedBy             string             `bson:"created_by" json:"created_by"`
+	CreatedAt             time.Time          `bson:"created_at" json:"created_at"`
+	RefundID              string             `bson:"refund_id" json:"refund_id"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	ReservationStatusBooked    = "BOOKED"
+	ReservationStatusSeated    = "SEATED"
+	ReservationStatusCancelled = "CANCELLED"
+	ReservationStatusNoShow    = "NO_SHOW"
+)
+
+type Reservation struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	GuestName       *string            `bson:"guest_name" json:"guest_name" validate:"required,min=2,max=100"`
+	Phone           *string            `bson:"phone_number" json:"phone_number" validate:"required"`
+	PartySize       *int               `bson:"party_size" json:"party_size" validate:"required,min=1"`
+	ReservationTime *time.Time         `bson:"reservation_time" json:"reservation_time" validate:"required"`
+	Duration        *int               `bson:"duration" json:"duration" validate:"omitempty,min=15"`
+	EndTime         time.Time          `bson:"end_time" json:"end_time"`
+	Notes           *string            `bson:"notes" json:"notes"`
+	TableID         *string            `bson:"table_id" json:"table_id"`
+	TableGroupID    *string            `bson:"table_group_id" json:"table_group_id"`
+	TableIDs        []string           `bson:"table_ids" json:"table_ids"`
+	Status          *string            `bson:"status" json:"status" validate:"omitempty,eq=BOOKED|eq=SEATED|eq=CANCELLED|eq=NO_SHOW"`
+	CancelledAt     *time.Time         `bson:"cancelled_at" json:"cancelled_at"`
+	SeatedAt        *time.Time         `bson:"seated_at" json:"seated_at"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	ReservationID   string             `bson:"reservation_id" json:"reservation_id"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type ServerAssignment struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	ServerID     *string            `bson:"server_id" json:"server_id" validate:"required"`
+	TableIDs     []string           `bson:"table_ids" json:"table_ids"`
+	Sections     []string           `bson:"sections" json:"sections"`
+	ShiftStart   *time.Time         `bson:"shift_start" json:"shift_start" validate:"required"`
+	ShiftEnd     *time.Time         `bson:"shift_end" json:"shift_end" validate:"required"`
+	CreatedBy    string             `bson:"created_by" json:"created_by"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+	AssignmentID string             `bson:"assignment_id" json:"assignment_id"`
+}
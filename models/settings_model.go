@@ -0,0 +1,56 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	TaxRoundingLine    = "LINE"
+	TaxRoundingInvoice = "INVOICE"
+)
+
+// TaxRate is the rate charged on one tax category, e.g. food or alcohol, as a percentage
+type TaxRate struct {
+	Category *string  `bson:"category" json:"category" validate:"required,min=2,max=50"`
+	Name     *string  `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Rate     *float64 `bson:"rate" json:"rate" validate:"required,min=0,max=100"`
+}
+
+// ServiceChargeRule adds a service charge to an invoice, a percentage of the bill after
+// discounts or a flat amount. A rule only applies to parties of at least MinPartySize
+// guests and, when OrderTypes are listed, to those kinds of order. A taxable charge is
+// taxed at the rate of its tax category.
+type ServiceChargeRule struct {
+	Name         *string  `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	Type         *string  `bson:"type" json:"type" validate:"required,eq=PERCENTAGE|eq=FIXED"`
+	Value        *float64 `bson:"value" json:"value" validate:"required,gt=0"`
+	MinPartySize *int     `bson:"min_party_size" json:"min_party_size" validate:"omitempty,min=1"`
+	OrderTypes   []string `bson:"order_types" json:"order_types" validate:"omitempty,dive,oneof=DINE_IN TAKEAWAY DELIVERY"`
+	Taxable      bool     `bson:"taxable" json:"taxable"`
+	TaxCategory  *string  `bson:"tax_category" json:"tax_category"`
+}
+
+// Settings are the restaurant wide settings; there is only ever one document.
+// Foods without a tax category are taxed at the default category's rate. The
+// receipt template is a Go text/template; without one the default layout is used.
+// FiscalYearStart is the month the fiscal year starts in, January unless it is set;
+// invoice numbers start again from 1 every fiscal year.
+type Settings struct {
+	ID                 primitive.ObjectID  `bson:"_id"`
+	SettingsID         string              `bson:"settings_id" json:"settings_id"`
+	TaxRates           []TaxRate           `bson:"tax_rates" json:"tax_rates" validate:"omitempty,dive"`
+	DefaultTaxCategory *string             `bson:"default_tax_category" json:"default_tax_category"`
+	PricesIncludeTax   *bool               `bson:"prices_include_tax" json:"prices_include_tax"`
+	TaxRounding        *string             `bson:"tax_rounding" json:"tax_rounding" validate:"omitempty,eq=LINE|eq=INVOICE"`
+	ServiceChargeRules []ServiceChargeRule `bson:"service_charge_rules" json:"service_charge_rules" validate:"omitempty,dive"`
+	RestaurantName     *string             `bson:"restaurant_name" json:"restaurant_name" validate:"omitempty,max=100"`
+	Address            *string             `bson:"address" json:"address" validate:"omitempty,max=200"`
+	Phone              *string             `bson:"phone" json:"phone" validate:"omitempty,max=30"`
+	TaxNumber          *string             `bson:"tax_number" json:"tax_number" validate:"omitempty,max=50"`
+	ReceiptFooter      *string             `bson:"receipt_footer" json:"receipt_footer" validate:"omitempty,max=200"`
+	ReceiptTemplate    *string             `bson:"receipt_template" json:"receipt_template" validate:"omitempty,max=10000"`
+	FiscalYearStart    *int                `bson:"fiscal_year_start" json:"fiscal_year_start" validate:"omitempty,min=1,max=12"`
+	UpdatedBy          string              `bson:"updated_by" json:"updated_by"`
+	UpdatedAt          time.Time           `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	StockMovementServed    = "SERVED"
+	StockMovementStockTake = "STOCK_TAKE"
+	StockMovementReceived  = "RECEIVED"
+)
+
+// StockMovement records a change to an ingredient's stock level; Quantity is negative when stock goes out
+type StockMovement struct {
+	ID              primitive.ObjectID `bson:"_id"`
+	IngredientID    string             `bson:"ingredient_id" json:"ingredient_id"`
+	Quantity        float64            `bson:"quantity" json:"quantity"`
+	Reason          string             `bson:"reason" json:"reason"`
+	OrderItemID     *string            `bson:"order_item_id" json:"order_item_id"`
+	StockTakeID     *string            `bson:"stock_take_id" json:"stock_take_id"`
+	PurchaseOrderID *string            `bson:"purchase_order_id" json:"purchase_order_id"`
+	CreatedBy       string             `bson:"created_by" json:"created_by"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+	StockMovementID string             `bson:"stock_movement_id" json:"stock_movement_id"`
+}
+
+// StockCount is the physical count of one ingredient, compared with what the system expected
+type StockCount struct {
+	IngredientID     *string    `bson:"ingredient_id" json:"ingredient_id" validate:"required"`
+	CountedQuantity  *float64   `bson:"counted_quantity" json:"counted_quantity" validate:"required,min=0"`
+	ExpectedQuantity float64    `bson:"expected_quantity" json:"expected_quantity"`
+	TheoreticalUsage float64    `bson:"theoretical_usage" json:"theoretical_usage"`
+	Variance         float64    `bson:"variance" json:"variance"`
+	PeriodStart      *time.Time `bson:"period_start" json:"period_start"`
+}
+
+type StockTake struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Counts      []StockCount       `bson:"counts" json:"counts" validate:"required,min=1,dive"`
+	Notes       *string            `bson:"notes" json:"notes"`
+	CountedBy   string             `bson:"counted_by" json:"counted_by"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	StockTakeID string             `bson:"stock_take_id" json:"stock_take_id"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type Supplier struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Name        *string            `bson:"name" json:"name" validate:"required,min=2,max=100"`
+	ContactName *string            `bson:"contact_name" json:"contact_name"`
+	Phone       *string            `bson:"phone_number" json:"phone_number"`
+	Email       *string            `bson:"email" json:"email" validate:"omitempty,email"`
+	Notes       *string            `bson:"notes" json:"notes"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+	SupplierID  string             `bson:"supplier_id" json:"supplier_id"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	TableGroupStatusActive    = "ACTIVE"
+	TableGroupStatusDissolved = "DISSOLVED"
+)
+
+// TableGroup links tables pushed together for a large party
+type TableGroup struct {
+	ID           primitive.ObjectID `bson:"_id"`
+	Name         *string            `bson:"name" json:"name"`
+	TableIDs     []string           `bson:"table_ids" json:"table_ids" validate:"required,min=2,dive,required"`
+	Capacity     int                `bson:"capacity" json:"capacity"`
+	Status       *string            `bson:"status" json:"status"`
+	CreatedBy    string             `bson:"created_by" json:"created_by"`
+	DissolvedAt  *time.Time         `bson:"dissolved_at" json:"dissolved_at"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+	TableGroupID string             `bson:"table_group_id" json:"table_group_id"`
+}
@@ -5,11 +5,27 @@ import (
 	"time"
 )
 
+// live table states shown on the floor plan
+const (
+	TableStatusFree         = "FREE"
+	TableStatusSeated       = "SEATED"
+	TableStatusOrdering     = "ORDERING"
+	TableStatusAwaitingBill = "AWAITING_BILL"
+	TableStatusDirty        = "DIRTY"
+)
+
 type Table struct {
 	ID             primitive.ObjectID `bson:"_id"`
-	NumberOfGuests *int               `json:"number_of_guests" validate:"required"`
-	TableNumber    *int               `json:"table_number" validate:"required"`
-	CreatedAt      time.Time          `json:"created_at"`
-	UpdatedAt      time.Time          `json:"updated_at"`
-	TableID        string             `json:"table_id"`
+	NumberOfGuests *int               `bson:"number_of_guests" json:"number_of_guests" validate:"required"`
+	TableNumber    *int               `bson:"table_number" json:"table_number" validate:"required"`
+	MinCapacity    *int               `bson:"min_capacity" json:"min_capacity" validate:"omitempty,min=1"`
+	MaxCapacity    *int               `bson:"max_capacity" json:"max_capacity" validate:"omitempty,min=1"`
+	Section        *string            `bson:"section" json:"section"`
+	PositionX      *float64           `bson:"position_x" json:"position_x"`
+	PositionY      *float64           `bson:"position_y" json:"position_y"`
+	NeedsCleaning  *bool              `bson:"needs_cleaning" json:"needs_cleaning"`
+	SeatedOrderID  *string            `bson:"seated_order_id" json:"seated_order_id"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+	TableID        string             `bson:"table_id" json:"table_id"`
 }
@@ -5,17 +5,30 @@ import (
 	"time"
 )
 
+const (
+	UserRoleStaff   = "STAFF"
+	UserRoleManager = "MANAGER"
+)
+
 type User struct {
 	ID           primitive.ObjectID `bson:"_id"`
-	FirstName    *string            `json:"first_name" validate:"required,min=2,max=100"`
-	LastName     *string            `json:"last_name" validate:"required,min=2,max=100"`
-	Password     *string            `json:"password" validate:"required,min=6"`
-	Email        *string            `json:"email" validate:"required"`
-	Phone        *string            `json:"phone_number" validate:"required"`
-	Avatar       *string            `json:"avatar"`
-	Token        *string            `json:"token"`
-	RefreshToken *string            `json:"refresh_token"`
-	CreatedAt    time.Time          `json:"created_at"`
-	UpdatedAt    time.Time          `json:"updated_at"`
-	UserID       string             `json:"user_id"`
+	FirstName    *string            `bson:"first_name" json:"first_name" validate:"required,min=2,max=100"`
+	LastName     *string            `bson:"last_name" json:"last_name" validate:"required,min=2,max=100"`
+	Password     *string            `bson:"password" json:"password" validate:"required,min=6"`
+	Email        *string            `bson:"email" json:"email" validate:"required"`
+	Phone        *string            `bson:"phone_number" json:"phone_number" validate:"required"`
+	Avatar       *string            `bson:"avatar" json:"avatar"`
+	AvatarID     *string            `bson:"avatar_id" json:"avatar_id"`
+	Role         *string            `bson:"role" json:"role" validate:"omitempty,eq=STAFF|eq=MANAGER"`
+	Token        *string            `bson:"token" json:"token"`
+	RefreshToken *string            `bson:"refresh_token" json:"refresh_token"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt    time.Time          `bson:"updated_at" json:"updated_at"`
+	UserID       string             `bson:"user_id" json:"user_id"`
+}
+
+// ManagerApproval is a manager signing off on an action taken by another member of staff
+type ManagerApproval struct {
+	Email    *string `bson:"email" json:"email" validate:"required"`
+	Password *string `bson:"password" json:"password" validate:"required"`
 }
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+const (
+	WaitlistStatusWaiting  = "WAITING"
+	WaitlistStatusNotified = "NOTIFIED"
+	WaitlistStatusSeated   = "SEATED"
+	WaitlistStatusRemoved  = "REMOVED"
+)
+
+type WaitlistEntry struct {
+	ID            primitive.ObjectID `bson:"_id"`
+	GuestName     *string            `bson:"guest_name" json:"guest_name" validate:"required,min=2,max=100"`
+	Phone         *string            `bson:"phone_number" json:"phone_number" validate:"required"`
+	PartySize     *int               `bson:"party_size" json:"party_size" validate:"required,min=1"`
+	Notes         *string            `bson:"notes" json:"notes"`
+	Status        *string            `bson:"status" json:"status"`
+	QuotedWait    int                `bson:"quoted_wait" json:"quoted_wait"`
+	EstimatedWait int                `bson:"estimated_wait" json:"estimated_wait"`
+	TableID       *string            `bson:"table_id" json:"table_id"`
+	OrderID       *string            `bson:"order_id" json:"order_id"`
+	NotifiedAt    *time.Time         `bson:"notified_at" json:"notified_at"`
+	SeatedAt      *time.Time         `bson:"seated_at" json:"seated_at"`
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+	WaitlistID    string             `bson:"waitlist_id" json:"waitlist_id"`
+}
@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+// AuditRoutes only read the audit log, entries are added by the actions they record
+func AuditRoutes(route *gin.Engine) {
+	route.GET("/auditLog", controllers.GetAuditLog())
+}
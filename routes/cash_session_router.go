@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func CashSessionRoutes(route *gin.Engine) {
+	route.POST("/cashSessions", controllers.OpenCashSession())
+	route.GET("/cashSessions", controllers.GetCashSessions())
+	route.GET("/cashSessions/:cash_session_id", controllers.GetCashSessionById())
+	route.POST("/cashSessions/:cash_session_id/movements", controllers.AddCashMovement())
+	route.GET("/cashSessions/:cash_session_id/x-report", controllers.GetXReport())
+	route.POST("/cashSessions/:cash_session_id/close", controllers.CloseCashSession())
+}
@@ -10,4 +10,7 @@ func FoodRoutes(route *gin.Engine) {
 	route.GET("/foods", controllers.GetFoods())
 	route.GET("/foods/:food_id", controllers.GetFoodById())
 	route.PATCH("/foods/:food_id", controllers.UpdateFood())
+	route.PATCH("/foods/:food_id/86", controllers.MarkFoodUnavailable())
+	route.PATCH("/foods/:food_id/un-86", controllers.MarkFoodAvailable())
+	route.POST("/foods/:food_id/image", controllers.UploadFoodImage())
 }
@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+// ImageRoutes serves stored images; they are public so they can be used directly in <img> tags
+func ImageRoutes(route *gin.Engine) {
+	route.GET("/images/:image_id/:size", controllers.GetImage())
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func IngredientRoutes(route *gin.Engine) {
+	route.POST("/ingredients", controllers.CreateIngredient())
+	route.GET("/ingredients", controllers.GetIngredients())
+	route.GET("/ingredients/low-stock", controllers.GetLowStockIngredients())
+	route.GET("/ingredients/:ingredient_id", controllers.GetIngredientById())
+	route.PATCH("/ingredients/:ingredient_id", controllers.UpdateIngredient())
+}
@@ -10,4 +10,7 @@ func InvoiceRoutes(route *gin.Engine) {
 	route.GET("/invoices", controllers.GetInvoices())
 	route.GET("/invoices/:invoice_id", controllers.GetInvoiceById())
 	route.PATCH("/invoices/:invoice_id", controllers.UpdateInvoice())
+	route.POST("/invoices/:invoice_id/void", controllers.VoidInvoice())
+	route.DELETE("/invoices/:invoice_id/service-charge", controllers.RemoveServiceCharge())
+	route.GET("/invoices/:invoice_id/receipt", controllers.GetInvoiceReceipt())
 }
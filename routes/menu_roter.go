@@ -8,6 +8,8 @@ import (
 func MenuRoutes(route *gin.Engine) {
 	route.POST("/menus", controllers.CreateMenu())
 	route.GET("/menus", controllers.GetMenus())
+	route.GET("/menus/active", controllers.GetActiveMenus())
 	route.GET("/menus/:menu_id", controllers.GetMenuById())
 	route.PATCH("/menus/:menu_id", controllers.UpdateMenu())
+	route.DELETE("/menus/:menu_id", controllers.ArchiveMenu())
 }
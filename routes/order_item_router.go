@@ -11,4 +11,5 @@ func OrderItemRoutes(route *gin.Engine) {
 	route.GET("/orderItems/:orderItem_id", controllers.GetOrderItemById())
 	route.GET("/orderItems-order/:order_id", controllers.GetOrderItemsByOrder())
 	route.PATCH("/orderItems/:orderItem_id", controllers.UpdateOrderItem())
+	route.PATCH("/orderItems/:orderItem_id/serve", controllers.ServeOrderItem())
 }
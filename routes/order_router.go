@@ -10,4 +10,6 @@ func OrderRoutes(route *gin.Engine) {
 	route.GET("/orders", controllers.GetOrders())
 	route.GET("/orders/:order_id", controllers.GetOrderById())
 	route.PATCH("/orders/:order_id", controllers.UpdateOrder())
+	route.PATCH("/orders/:order_id/transfer", controllers.TransferOrder())
+	route.GET("/orders/:order_id/ticket", controllers.GetKitchenTicket())
 }
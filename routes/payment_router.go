@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func PaymentRoutes(route *gin.Engine) {
+	route.POST("/invoices/:invoice_id/payments", controllers.CreatePayment())
+	route.GET("/invoices/:invoice_id/payments", controllers.GetInvoicePayments())
+	route.GET("/payments/:payment_id", controllers.GetPaymentById())
+}
@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func PromotionRoutes(route *gin.Engine) {
+	route.POST("/promotions", controllers.CreatePromotion())
+	route.GET("/promotions", controllers.GetPromotions())
+	route.GET("/promotions/:promotion_id", controllers.GetPromotionById())
+	route.PATCH("/promotions/:promotion_id", controllers.UpdatePromotion())
+	route.POST("/invoices/:invoice_id/coupon", controllers.ApplyCoupon())
+	route.DELETE("/invoices/:invoice_id/coupon", controllers.RemoveCoupon())
+	route.POST("/invoices/:invoice_id/discounts", controllers.AddManualDiscount())
+	route.DELETE("/invoices/:invoice_id/discounts/:discount_id", controllers.RemoveManualDiscount())
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func PurchaseOrderRoutes(route *gin.Engine) {
+	route.POST("/purchaseOrders", controllers.CreatePurchaseOrder())
+	route.POST("/purchaseOrders/suggested", controllers.SuggestPurchaseOrders())
+	route.GET("/purchaseOrders", controllers.GetPurchaseOrders())
+	route.GET("/purchaseOrders/:purchase_order_id", controllers.GetPurchaseOrderById())
+	route.PATCH("/purchaseOrders/:purchase_order_id", controllers.UpdatePurchaseOrder())
+	route.POST("/purchaseOrders/:purchase_order_id/receive", controllers.ReceivePurchaseOrder())
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func RecipeRoutes(route *gin.Engine) {
+	route.POST("/recipes", controllers.CreateRecipe())
+	route.GET("/recipes", controllers.GetRecipes())
+	route.GET("/recipes/:recipe_id", controllers.GetRecipeById())
+	route.PATCH("/recipes/:recipe_id", controllers.UpdateRecipe())
+}
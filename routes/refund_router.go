@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func RefundRoutes(route *gin.Engine) {
+	route.POST("/payments/:payment_id/refunds", controllers.CreateRefund())
+	route.GET("/payments/:payment_id/refunds", controllers.GetPaymentRefunds())
+	route.GET("/refunds/:refund_id", controllers.GetRefundById())
+}
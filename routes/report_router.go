@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func ReportRoutes(route *gin.Engine) {
+	route.GET("/reports/food-cost", controllers.GetFoodCostReport())
+	route.GET("/reports/menu-engineering", controllers.GetMenuEngineeringReport())
+	route.GET("/reports/tax", controllers.GetTaxReport())
+	route.GET("/reports/sales", controllers.GetSalesReport())
+	route.GET("/reports/top-foods", controllers.GetTopFoodsReport())
+	route.GET("/reports/categories", controllers.GetCategorySalesReport())
+	route.GET("/reports/covers", controllers.GetCoversReport())
+	route.GET("/reports/servers", controllers.GetServerSalesReport())
+}
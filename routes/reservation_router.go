@@ -0,0 +1,17 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func ReservationRoutes(route *gin.Engine) {
+	route.POST("/reservations", controllers.CreateReservation())
+	route.GET("/reservations", controllers.GetReservations())
+	route.GET("/reservations/availability", controllers.GetAvailability())
+	route.GET("/reservations/:reservation_id", controllers.GetReservationById())
+	route.PATCH("/reservations/:reservation_id", controllers.UpdateReservation())
+	route.PATCH("/reservations/:reservation_id/cancel", controllers.CancelReservation())
+	route.PATCH("/reservations/:reservation_id/seat", controllers.SeatReservation())
+	route.PATCH("/reservations/:reservation_id/no-show", controllers.MarkNoShow())
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func ServerAssignmentRoutes(route *gin.Engine) {
+	route.POST("/assignments", controllers.CreateAssignment())
+	route.GET("/assignments", controllers.GetAssignments())
+	route.GET("/assignments/:assignment_id", controllers.GetAssignmentById())
+	route.PATCH("/assignments/:assignment_id", controllers.UpdateAssignment())
+}
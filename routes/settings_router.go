@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func SettingsRoutes(route *gin.Engine) {
+	route.GET("/settings", controllers.GetSettings())
+	route.PATCH("/settings", controllers.UpdateSettings())
+}
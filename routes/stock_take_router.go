@@ -0,0 +1,12 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func StockTakeRoutes(route *gin.Engine) {
+	route.POST("/stockTakes", controllers.CreateStockTake())
+	route.GET("/stockTakes", controllers.GetStockTakes())
+	route.GET("/stockTakes/:stock_take_id", controllers.GetStockTakeById())
+}
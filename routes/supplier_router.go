@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func SupplierRoutes(route *gin.Engine) {
+	route.POST("/suppliers", controllers.CreateSupplier())
+	route.GET("/suppliers", controllers.GetSuppliers())
+	route.GET("/suppliers/:supplier_id", controllers.GetSupplierById())
+	route.PATCH("/suppliers/:supplier_id", controllers.UpdateSupplier())
+}
@@ -0,0 +1,13 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func TableGroupRoutes(route *gin.Engine) {
+	route.POST("/tableGroups", controllers.CreateTableGroup())
+	route.GET("/tableGroups", controllers.GetTableGroups())
+	route.GET("/tableGroups/:table_group_id", controllers.GetTableGroupById())
+	route.PATCH("/tableGroups/:table_group_id/dissolve", controllers.DissolveTableGroup())
+}
@@ -8,6 +8,7 @@ import (
 func TableRoutes(route *gin.Engine) {
 	route.POST("/tables", controllers.CreateTable())
 	route.GET("/tables", controllers.GetTables())
+	route.GET("/tables/floor", controllers.GetFloor())
 	route.GET("/tables/:table_id", controllers.GetTableById())
 	route.PATCH("/tables/:table_id", controllers.UpdateTable())
 }
@@ -11,3 +11,9 @@ func UserRoutes(route *gin.Engine) {
 	route.GET("/users", controllers.GetUsers())
 	route.GET("/users/:user_id", controllers.GetUserById())
 }
+
+// SignedInUserRoutes need the signed in user, so they are registered after the authentication middleware
+func SignedInUserRoutes(route *gin.Engine) {
+	route.POST("/users/:user_id/avatar", controllers.UploadAvatar())
+	route.PATCH("/users/:user_id/role", controllers.UpdateUserRole())
+}
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/dastardlyjockey/restaurant-management-backend/controllers"
+	"github.com/gin-gonic/gin"
+)
+
+func WaitlistRoutes(route *gin.Engine) {
+	route.POST("/waitlist", controllers.AddToWaitlist())
+	route.GET("/waitlist", controllers.GetWaitlist())
+	route.PATCH("/waitlist/:waitlist_id/notify", controllers.NotifyWaitlistParty())
+	route.PATCH("/waitlist/:waitlist_id/seat", controllers.SeatWaitlistParty())
+	route.DELETE("/waitlist/:waitlist_id", controllers.RemoveFromWaitlist())
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStorage(filepath.Join(t.TempDir(), "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "images/abc/thumbnail.jpg"
+	if err := store.Save(ctx, key, strings.NewReader("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(ctx, key, strings.NewReader("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "second" {
+		t.Errorf("got %q, want the second save to replace the first", data)
+	}
+
+	// nothing but the file itself is left in its directory
+	entries, err := os.ReadDir(filepath.Join(store.Root, "images", "abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files next to the upload, want none", len(entries)-1)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("opening a deleted file: got %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("deleting a file twice: got %v", err)
+	}
+}
+
+func TestLocalStorageKeepsKeysBelowRoot(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalStorage(filepath.Join(dir, "uploads"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "/", "../escape", "images/../../escape", "images/.."} {
+		if err := store.Save(ctx, key, strings.NewReader("data")); err == nil {
+			t.Errorf("saving under %q was allowed", key)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "escape")); !os.IsNotExist(err) {
+		t.Error("a file was written outside the storage directory")
+	}
+
+	// a leading slash stays below the root
+	if err := store.Save(ctx, "/images/abc.png", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(store.Root, "images", "abc.png")); err != nil {
+		t.Errorf("the file was not stored below the root: %v", err)
+	}
+}